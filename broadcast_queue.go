@@ -0,0 +1,168 @@
+package main
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/Nitro/sidecar/catalog"
+	"github.com/Nitro/sidecar/service"
+	metrics "github.com/armon/go-metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	MAX_PENDING_LENGTH = 100  // Number of messages we can keep in the broadcast queue
+	MAX_BROADCAST_SIZE = 1398 // Longest message that fits in a memberlist UDP packet
+)
+
+// Broadcast priorities, most urgent first. Peers need to hear about services
+// going away before they hear that others are still alive.
+const (
+	PRIORITY_TOMBSTONE = iota
+	PRIORITY_DRAINING
+	PRIORITY_ALIVE
+)
+
+type queuedBroadcast struct {
+	key      string // Hostname and ID of the service
+	priority int
+	svc      *service.Service
+	message  []byte
+	seq      uint64 // Order we queued it in
+}
+
+// A broadcastQueue holds the encoded service records waiting to go out in
+// our gossip packets. It keeps only the newest record for each service, so
+// one that is queued again replaces the one already waiting. Service IDs are
+// only unique on their own host, so records are keyed by both.
+type broadcastQueue struct {
+	sync.Mutex
+	entries map[string]*queuedBroadcast
+	seq     uint64
+	limit   int
+}
+
+func newBroadcastQueue(limit int) *broadcastQueue {
+	return &broadcastQueue{
+		entries: make(map[string]*queuedBroadcast),
+		limit:   limit,
+	}
+}
+
+func priorityFor(status int) int {
+	switch status {
+	case service.TOMBSTONE:
+		return PRIORITY_TOMBSTONE
+	case service.DRAINING:
+		return PRIORITY_DRAINING
+	default:
+		return PRIORITY_ALIVE
+	}
+}
+
+// Len returns the number of messages waiting to go out
+func (q *broadcastQueue) Len() int {
+	q.Lock()
+	defer q.Unlock()
+	return len(q.entries)
+}
+
+// Push adds an encoded service record to the queue. If we already have a
+// record for the same service, the older of the two is superseded.
+func (q *broadcastQueue) Push(broadcast catalog.Broadcast) {
+	if len(broadcast.Message) < 1 || broadcast.Service == nil {
+		return
+	}
+
+	svc := broadcast.Service
+	key := svc.Hostname + "/" + svc.ID
+
+	q.Lock()
+	defer q.Unlock()
+
+	if queued, ok := q.entries[key]; ok {
+		if queued.svc.Invalidates(svc) {
+			metrics.IncrCounter([]string{"delegate", "staleBroadcasts"}, 1)
+			return
+		}
+		metrics.IncrCounter([]string{"delegate", "supersededBroadcasts"}, 1)
+	}
+
+	q.seq++
+	q.entries[key] = &queuedBroadcast{
+		key:      key,
+		priority: priorityFor(svc.Status),
+		svc:      svc,
+		message:  broadcast.Message,
+		seq:      q.seq,
+	}
+
+	q.trim()
+}
+
+// trim drops the least urgent messages when the queue is too long.
+// Note: Not synchronized!
+func (q *broadcastQueue) trim() {
+	if len(q.entries) <= q.limit {
+		return
+	}
+
+	sorted := q.sorted()
+	for _, entry := range sorted[q.limit:] {
+		delete(q.entries, entry.key)
+	}
+
+	metrics.IncrCounter([]string{"delegate", "droppedBroadcasts"}, float32(len(sorted)-q.limit))
+	log.Debugf("Dropped %d broadcasts from a full queue", len(sorted)-q.limit)
+}
+
+// sorted returns the entries most urgent first, and the newest first within
+// the same priority.
+// Note: Not synchronized!
+func (q *broadcastQueue) sorted() []*queuedBroadcast {
+	sorted := make([]*queuedBroadcast, 0, len(q.entries))
+	for _, entry := range q.entries {
+		sorted = append(sorted, entry)
+	}
+
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].priority != sorted[j].priority {
+			return sorted[i].priority < sorted[j].priority
+		}
+		return sorted[i].seq > sorted[j].seq
+	})
+
+	return sorted
+}
+
+// Pop removes and returns as many messages as fit into a packet of limit
+// bytes, when each one costs overhead bytes on top of its length. Messages
+// that don't fit wait for the next packet, except the ones that never could,
+// which are dropped so they don't sit in the queue forever.
+func (q *broadcastQueue) Pop(overhead int, limit int) [][]byte {
+	q.Lock()
+	defer q.Unlock()
+
+	var packet [][]byte
+	total := 0
+
+	for _, entry := range q.sorted() {
+		if len(entry.message) > MAX_BROADCAST_SIZE {
+			log.Warnf("Dropping broadcast for %s, %d bytes is too long to send", entry.key, len(entry.message))
+			metrics.IncrCounter([]string{"delegate", "droppedBroadcasts"}, 1)
+			delete(q.entries, entry.key)
+			continue
+		}
+
+		size := len(entry.message) + overhead
+		if total+size > limit {
+			continue
+		}
+
+		packet = append(packet, entry.message)
+		total += size
+		delete(q.entries, entry.key)
+	}
+
+	return packet
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Nitro/sidecar/catalog"
+	"github.com/Nitro/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_broadcastQueue(t *testing.T) {
+	Convey("The broadcast queue", t, func() {
+		queue := newBroadcastQueue(3)
+		baseTime := time.Now().UTC()
+
+		encode := func(id string, status int, updated time.Time) catalog.Broadcast {
+			svc := service.Service{ID: id, Hostname: "chaucer", Status: status, Updated: updated}
+			encoded, _ := svc.Encode()
+			return catalog.Broadcast{Service: &svc, Message: encoded}
+		}
+
+		Convey("sends tombstones first, then draining services", func() {
+			alive := encode("alive", service.ALIVE, baseTime)
+			draining := encode("draining", service.DRAINING, baseTime)
+			tombstone := encode("tombstone", service.TOMBSTONE, baseTime)
+
+			queue.Push(alive)
+			queue.Push(draining)
+			queue.Push(tombstone)
+
+			So(queue.Pop(3, 1398), ShouldResemble, [][]byte{tombstone.Message, draining.Message, alive.Message})
+		})
+
+		Convey("replaces a queued record with a newer one", func() {
+			queue.Push(encode("beowulf", service.ALIVE, baseTime))
+			newer := encode("beowulf", service.TOMBSTONE, baseTime.Add(time.Second))
+			queue.Push(newer)
+
+			So(queue.Len(), ShouldEqual, 1)
+			So(queue.Pop(3, 1398), ShouldResemble, [][]byte{newer.Message})
+		})
+
+		Convey("keeps a queued record over an older one", func() {
+			newer := encode("beowulf", service.TOMBSTONE, baseTime.Add(time.Second))
+			queue.Push(newer)
+			queue.Push(encode("beowulf", service.ALIVE, baseTime))
+
+			So(queue.Pop(3, 1398), ShouldResemble, [][]byte{newer.Message})
+		})
+
+		Convey("keeps the records of services on different hosts apart", func() {
+			chaucer := encode("beowulf", service.ALIVE, baseTime)
+			gower := encode("beowulf", service.ALIVE, baseTime.Add(time.Second))
+			gower.Service.Hostname = "gower"
+			gower.Message, _ = gower.Service.Encode()
+
+			queue.Push(chaucer)
+			queue.Push(gower)
+
+			So(queue.Len(), ShouldEqual, 2)
+			So(queue.Pop(3, 1398), ShouldResemble, [][]byte{gower.Message, chaucer.Message})
+		})
+
+		Convey("drops the least urgent messages when full", func() {
+			tombstone := encode("tombstone", service.TOMBSTONE, baseTime)
+			queue.Push(tombstone)
+			queue.Push(encode("alive1", service.ALIVE, baseTime))
+			queue.Push(encode("alive2", service.ALIVE, baseTime))
+			queue.Push(encode("alive3", service.ALIVE, baseTime))
+
+			So(queue.Len(), ShouldEqual, 3)
+			So(queue.Pop(3, 1398)[0], ShouldResemble, tombstone.Message)
+		})
+
+		Convey("sends what fits around a message that doesn't", func() {
+			big := service.Service{ID: "big", Hostname: strings.Repeat("x", 500), Status: service.TOMBSTONE, Updated: baseTime}
+			bigEncoded, _ := big.Encode()
+			small := encode("small", service.ALIVE, baseTime)
+
+			queue.Push(catalog.Broadcast{Service: &big, Message: bigEncoded})
+			queue.Push(small)
+
+			So(queue.Pop(3, 300), ShouldResemble, [][]byte{small.Message})
+			So(queue.Len(), ShouldEqual, 1)
+		})
+
+		Convey("drops messages that can never be sent", func() {
+			huge := service.Service{ID: "huge", Hostname: strings.Repeat("x", MAX_BROADCAST_SIZE), Updated: baseTime}
+			encoded, _ := huge.Encode()
+			queue.Push(catalog.Broadcast{Service: &huge, Message: encoded})
+
+			So(queue.Pop(3, 1398), ShouldBeEmpty)
+			So(queue.Len(), ShouldEqual, 0)
+		})
+
+		Convey("skips broadcasts without a service", func() {
+			queue.Push(catalog.Broadcast{Message: []byte("junk")})
+			So(queue.Len(), ShouldEqual, 0)
+		})
+	})
+}
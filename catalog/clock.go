@@ -0,0 +1,53 @@
+package catalog
+
+import (
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+	"github.com/armon/go-metrics"
+)
+
+// Each host stamps the records it sends with its own clock, so we can't
+// compare those times against ours. Instead we remember when we received
+// each record, and we estimate how far off each server's clock is from
+// the records it sends us. The estimate includes the time the record
+// spent in transit.
+
+// markSeen records that we just received a newer record for the service
+// Note: Not synchronized!
+func (state *ServicesState) markSeen(hostname string, id string) {
+	state.lastSeen[staleKey(hostname, id)] = time.Now().UTC()
+}
+
+// seenAt returns when we last received a newer record for the service. For
+// records that didn't come to us through AddServiceEntry(), that is the
+// time on the record.
+// Note: Not synchronized!
+func (state *ServicesState) seenAt(svc *service.Service) time.Time {
+	if seen, ok := state.lastSeen[staleKey(svc.Hostname, svc.ID)]; ok {
+		return seen
+	}
+	return svc.Updated
+}
+
+// Note: Not synchronized!
+func (state *ServicesState) setSkew(hostname string, skew time.Duration) {
+	state.skews[hostname] = skew
+	metrics.SetGauge(
+		[]string{"services_state", "clockSkew", hostname},
+		float32(skew)/float32(time.Millisecond),
+	)
+}
+
+// Note: Not synchronized!
+func (state *ServicesState) clearSkew(hostname string) {
+	delete(state.skews, hostname)
+}
+
+// ClockSkew returns how far ahead of ours the server's clock appears to
+// be. It is negative when the server is behind, and zero when we haven't
+// heard from it.
+// Note: Not synchronized!
+func (state *ServicesState) ClockSkew(hostname string) time.Duration {
+	return state.skews[hostname]
+}
@@ -0,0 +1,182 @@
+package catalog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"sort"
+
+	"github.com/Nitro/sidecar/service"
+	log "github.com/sirupsen/logrus"
+)
+
+// Digest-based anti-entropy. Instead of the whole state, peers exchange a
+// hash of each server's services on push/pull. Each side then asks the other
+// directly for the servers whose hashes differ, and merges only those. Joins
+// still exchange the whole state.
+
+const (
+	DIGEST_MSG         = 0x80 // A digest of the state, sent on push/pull
+	DIGEST_REQUEST_MSG = 0x81 // A request for the servers whose digests differ
+	SERVERS_MSG        = 0x82 // The state of the servers that were requested
+)
+
+// None of the leading bytes above can start a JSON or compact wire message,
+// so they can share the channels those are sent on. The keyring package
+// uses 0x83 and 0x84 for its key requests.
+
+// A Digest summarizes the state of each server
+type Digest struct {
+	Node    string            `codec:"n"` // The memberlist node that sent it
+	Servers map[string]uint64 `codec:"s"`
+}
+
+type digestRequest struct {
+	Node    string   `codec:"n"` // Who to send the servers to
+	Servers []string `codec:"s"`
+}
+
+// Digest hashes the ID, clock reading, and status of each service. Any change
+// we would gossip about changes the digest.
+// Note: Not synchronized!
+func (server *Server) Digest() uint64 {
+	ids := make([]string, 0, len(server.Services))
+	for id := range server.Services {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	hash := fnv.New64a()
+	buf := make([]byte, 8)
+	for _, id := range ids {
+		svc := server.Services[id]
+		hash.Write([]byte(id))
+		binary.BigEndian.PutUint64(buf, uint64(svc.Updated.UnixNano()))
+		hash.Write(buf)
+		binary.BigEndian.PutUint64(buf, uint64(svc.Logical))
+		hash.Write(buf)
+		binary.BigEndian.PutUint64(buf, uint64(svc.Status))
+		hash.Write(buf)
+	}
+
+	return hash.Sum64()
+}
+
+// EncodeDigest returns the digest of the whole state, sent from the named
+// memberlist node. Like Encode(), it returns an empty slice on failure.
+// Note: Not synchronized!
+func (state *ServicesState) EncodeDigest(node string) []byte {
+	digest := Digest{
+		Node:    node,
+		Servers: make(map[string]uint64, len(state.Servers)),
+	}
+
+	for name, server := range state.Servers {
+		digest.Servers[name] = server.Digest()
+	}
+
+	return encodeControlMsg(DIGEST_MSG, &digest)
+}
+
+// DecodeDigest decodes a digest sent by EncodeDigest
+func DecodeDigest(data []byte) (*Digest, error) {
+	var digest Digest
+	err := decodeControlMsg(DIGEST_MSG, data, &digest)
+	if err != nil {
+		return nil, err
+	}
+
+	return &digest, nil
+}
+
+// DiffDigest returns the names of the servers that the remote state has a
+// different view of. We don't ask for our own server, we know it best.
+// Note: Not synchronized!
+func (state *ServicesState) DiffDigest(remote *Digest) []string {
+	var names []string
+	for name, digest := range remote.Servers {
+		if name == state.Hostname {
+			continue
+		}
+
+		server, ok := state.Servers[name]
+		if !ok || server.Digest() != digest {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// EncodeDigestRequest asks a peer to send the named servers to our node
+func EncodeDigestRequest(node string, servers []string) []byte {
+	return encodeControlMsg(DIGEST_REQUEST_MSG, &digestRequest{Node: node, Servers: servers})
+}
+
+// DecodeDigestRequest returns the node that wants the servers, and which
+// servers it wants
+func DecodeDigestRequest(data []byte) (string, []string, error) {
+	var request digestRequest
+	err := decodeControlMsg(DIGEST_REQUEST_MSG, data, &request)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return request.Node, request.Servers, nil
+}
+
+// EncodeServers encodes the state of the named servers that we know about,
+// in the format we gossip with. Like Encode(), it returns an empty slice on
+// failure.
+// Note: Not synchronized!
+func (state *ServicesState) EncodeServers(names []string) []byte {
+	partial := &ServicesState{
+		Servers:     make(map[string]*Server, len(names)),
+		LastChanged: state.LastChanged,
+		ClusterName: state.ClusterName,
+		Hostname:    state.Hostname,
+		WireFormat:  state.WireFormat,
+	}
+
+	for _, name := range names {
+		if server, ok := state.Servers[name]; ok {
+			partial.Servers[name] = server
+		}
+	}
+
+	encoded := partial.EncodeWire()
+	if len(encoded) == 0 {
+		return encoded
+	}
+
+	return append([]byte{SERVERS_MSG}, encoded...)
+}
+
+// DecodeServers decodes servers sent by EncodeServers into a state that can
+// be merged
+func DecodeServers(data []byte) (*ServicesState, error) {
+	if len(data) < 1 || data[0] != SERVERS_MSG {
+		return nil, fmt.Errorf("not a servers message")
+	}
+
+	return Decode(data[1:])
+}
+
+func encodeControlMsg(msgType byte, value interface{}) []byte {
+	encoded, err := service.MarshalWire(value, service.COMPRESSED_FORMAT)
+	if err != nil {
+		log.Errorf("ERROR: Failed to encode message type %d: %s", msgType, err)
+		return []byte{}
+	}
+
+	return append([]byte{msgType}, encoded...)
+}
+
+func decodeControlMsg(msgType byte, data []byte, value interface{}) error {
+	if len(data) < 1 || data[0] != msgType {
+		return fmt.Errorf("not a message of type %d", msgType)
+	}
+
+	return service.UnmarshalWire(data[1:], value)
+}
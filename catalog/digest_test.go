@@ -0,0 +1,120 @@
+package catalog
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Digests(t *testing.T) {
+	Convey("Working with state digests", t, func() {
+		baseTime := time.Now().UTC().Round(time.Second)
+
+		svc1 := service.Service{ID: "deadbeef123", Hostname: anotherHostname, Updated: baseTime, Status: service.ALIVE}
+		svc2 := service.Service{ID: "deadbeef456", Hostname: anotherHostname, Updated: baseTime, Status: service.ALIVE}
+		svc3 := service.Service{ID: "abba123", Hostname: hostname, Updated: baseTime, Status: service.ALIVE}
+
+		newState := func() *ServicesState {
+			state := NewServicesState()
+			state.Hostname = hostname
+			for _, svc := range []service.Service{svc1, svc2, svc3} {
+				svc := svc
+				if !state.HasServer(svc.Hostname) {
+					state.Servers[svc.Hostname] = NewServer(svc.Hostname)
+				}
+				state.Servers[svc.Hostname].Services[svc.ID] = &svc
+			}
+			return state
+		}
+
+		state := newState()
+		otherState := newState()
+
+		Convey("Server.Digest()", func() {
+			server := state.Servers[anotherHostname]
+			digest := server.Digest()
+
+			Convey("is the same for the same services", func() {
+				So(otherState.Servers[anotherHostname].Digest(), ShouldEqual, digest)
+			})
+
+			Convey("changes when a service is updated", func() {
+				server.Services[svc1.ID].Updated = baseTime.Add(time.Second)
+				So(server.Digest(), ShouldNotEqual, digest)
+			})
+
+			Convey("changes when a service changes status", func() {
+				server.Services[svc1.ID].Status = service.TOMBSTONE
+				So(server.Digest(), ShouldNotEqual, digest)
+			})
+
+			Convey("changes when a service goes away", func() {
+				delete(server.Services, svc2.ID)
+				So(server.Digest(), ShouldNotEqual, digest)
+			})
+		})
+
+		Convey("DiffDigest()", func() {
+			encoded := otherState.EncodeDigest("other-node")
+			So(encoded[0], ShouldEqual, DIGEST_MSG)
+
+			Convey("finds nothing when the states match", func() {
+				digest, err := DecodeDigest(encoded)
+				So(err, ShouldBeNil)
+				So(digest.Node, ShouldEqual, "other-node")
+				So(state.DiffDigest(digest), ShouldBeEmpty)
+			})
+
+			Convey("finds the servers that differ", func() {
+				otherState.Servers[anotherHostname].Services[svc1.ID].Updated = baseTime.Add(time.Second)
+				digest, _ := DecodeDigest(otherState.EncodeDigest("other-node"))
+				So(state.DiffDigest(digest), ShouldResemble, []string{anotherHostname})
+			})
+
+			Convey("finds the servers we don't know about", func() {
+				delete(state.Servers, anotherHostname)
+				digest, _ := DecodeDigest(encoded)
+				So(state.DiffDigest(digest), ShouldResemble, []string{anotherHostname})
+			})
+
+			Convey("never asks for our own server", func() {
+				otherState.Servers[hostname].Services[svc3.ID].Updated = baseTime.Add(time.Second)
+				digest, _ := DecodeDigest(otherState.EncodeDigest("other-node"))
+				So(state.DiffDigest(digest), ShouldBeEmpty)
+			})
+		})
+
+		Convey("Digest requests round trip", func() {
+			node, servers, err := DecodeDigestRequest(EncodeDigestRequest("me", []string{"a", "b"}))
+			So(err, ShouldBeNil)
+			So(node, ShouldEqual, "me")
+			So(servers, ShouldResemble, []string{"a", "b"})
+		})
+
+		Convey("Messages of the wrong type are rejected", func() {
+			_, err := DecodeDigest(EncodeDigestRequest("me", []string{"a"}))
+			So(err, ShouldNotBeNil)
+
+			_, err = DecodeServers(state.EncodeDigest("me"))
+			So(err, ShouldNotBeNil)
+		})
+
+		for _, format := range []service.WireFormat{service.JSON_FORMAT, service.MSGPACK_FORMAT, service.COMPRESSED_FORMAT} {
+			format := format
+
+			Convey(fmt.Sprintf("EncodeServers() sends only the servers asked for in format %d", format), func() {
+				state.WireFormat = format
+				encoded := state.EncodeServers([]string{anotherHostname, "unknown"})
+				So(encoded[0], ShouldEqual, SERVERS_MSG)
+
+				decoded, err := DecodeServers(encoded)
+				So(err, ShouldBeNil)
+				So(len(decoded.Servers), ShouldEqual, 1)
+				So(decoded.Servers[anotherHostname].Digest(), ShouldEqual, state.Servers[anotherHostname].Digest())
+			})
+		}
+	})
+}
@@ -0,0 +1,184 @@
+package catalog
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+	log "github.com/sirupsen/logrus"
+)
+
+// The journal keeps the last few thousand changes to the state, so that when
+// a service goes away we can find out when it happened and why. Listeners
+// only get the changes as they happen. The journal can also append each
+// change to a file, which survives restarts, for post-mortems.
+
+const (
+	JOURNAL_SIZE        = 2000 // The number of events we keep in memory
+	JOURNAL_BUFFER_SIZE = 100  // The number of events waiting to be written to the file
+)
+
+// Why a service changed
+const (
+	CAUSE_ANNOUNCED = "announced" // The server running the service told us about it
+	CAUSE_EXPIRED   = "expired"   // We didn't hear from the service in time and tombstoned it
+	CAUSE_REMOVED   = "removed"   // Discovery no longer finds our service
+	CAUSE_LEFT      = "left"      // The server left the cluster
+	CAUSE_DRAINED   = "drained"   // We drained our services on shutdown
+	CAUSE_SNAPSHOT  = "snapshot"  // Loaded from the state snapshot at startup
+)
+
+// A JournalEvent records a change in the status of a service
+type JournalEvent struct {
+	Time           time.Time // When the change happened here
+	Service        service.Service
+	PreviousStatus string
+	Status         string
+	Cause          string
+	Origin         string // The node that made the change
+	Revision       uint64 // The revision of the state after the change
+}
+
+type Journal struct {
+	sync.RWMutex
+	events []JournalEvent // Ring buffer, oldest event at start
+	start  int
+	count  int
+	writes chan JournalEvent
+}
+
+// NewJournal returns a journal that keeps the last size events
+func NewJournal(size int) *Journal {
+	if size < 1 {
+		size = 1
+	}
+
+	return &Journal{events: make([]JournalEvent, size)}
+}
+
+// AppendTo appends each event to the file as a line of JSON, from now on.
+// Writing happens in the background so it never holds up the state.
+func (j *Journal) AppendTo(filename string) error {
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	writes := make(chan JournalEvent, JOURNAL_BUFFER_SIZE)
+	go func() {
+		encoder := json.NewEncoder(file)
+		for event := range writes {
+			err := encoder.Encode(&event)
+			if err != nil {
+				log.Errorf("Unable to write event to journal file %s: %s", filename, err)
+			}
+		}
+		file.Close()
+	}()
+
+	j.Lock()
+	if j.writes != nil {
+		close(j.writes)
+	}
+	j.writes = writes
+	j.Unlock()
+
+	return nil
+}
+
+// Record adds the event to the journal
+func (j *Journal) Record(event JournalEvent) {
+	if j == nil {
+		return
+	}
+
+	j.Lock()
+	defer j.Unlock()
+
+	if j.count < len(j.events) {
+		j.events[(j.start+j.count)%len(j.events)] = event
+		j.count++
+	} else {
+		j.events[j.start] = event
+		j.start = (j.start + 1) % len(j.events)
+	}
+
+	if j.writes == nil {
+		return
+	}
+
+	select {
+	case j.writes <- event:
+	default:
+		log.Warn("Journal file is not keeping up, dropping event")
+	}
+}
+
+// Events returns the events for the named service, or all services when the
+// name is empty, that happened after since. Oldest first.
+func (j *Journal) Events(name string, since time.Time) []JournalEvent {
+	if j == nil {
+		return nil
+	}
+
+	j.RLock()
+	defer j.RUnlock()
+
+	var result []JournalEvent
+	for i := 0; i < j.count; i++ {
+		event := j.events[(j.start+i)%len(j.events)]
+
+		if len(name) > 0 && event.Service.Name != name && event.Service.ID != name {
+			continue
+		}
+
+		if !event.Time.After(since) {
+			continue
+		}
+
+		result = append(result, event)
+	}
+
+	return result
+}
+
+// Since returns the events after the revision, oldest first
+func (j *Journal) Since(revision uint64) []JournalEvent {
+	if j == nil {
+		return nil
+	}
+
+	j.RLock()
+	defer j.RUnlock()
+
+	var result []JournalEvent
+	for i := 0; i < j.count; i++ {
+		event := j.events[(j.start+i)%len(j.events)]
+		if event.Revision > revision {
+			result = append(result, event)
+		}
+	}
+
+	return result
+}
+
+// recordChange adds a change to the service to the journal
+// Note: Not synchronized!
+func (state *ServicesState) recordChange(svc *service.Service, previousStatus int, cause string) {
+	origin := state.Hostname
+	if cause == CAUSE_ANNOUNCED || cause == CAUSE_SNAPSHOT {
+		origin = svc.Hostname
+	}
+
+	state.Journal.Record(JournalEvent{
+		Time:           time.Now().UTC(),
+		Service:        *svc,
+		PreviousStatus: service.StatusString(previousStatus),
+		Status:         svc.StatusString(),
+		Cause:          cause,
+		Origin:         origin,
+		Revision:       state.revision,
+	})
+}
@@ -0,0 +1,221 @@
+package catalog
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Journal(t *testing.T) {
+	Convey("The journal", t, func() {
+		baseTime := time.Now().UTC()
+		journal := NewJournal(3)
+
+		event := func(name string, offset time.Duration) JournalEvent {
+			return JournalEvent{
+				Time:    baseTime.Add(offset),
+				Service: service.Service{ID: name + "-id", Name: name},
+				Status:  "Alive",
+			}
+		}
+
+		Convey("returns the events oldest first", func() {
+			journal.Record(event("bocaccio", 0))
+			journal.Record(event("shakespeare", time.Second))
+
+			events := journal.Events("", time.Time{})
+			So(len(events), ShouldEqual, 2)
+			So(events[0].Service.Name, ShouldEqual, "bocaccio")
+			So(events[1].Service.Name, ShouldEqual, "shakespeare")
+		})
+
+		Convey("only keeps the latest events", func() {
+			for i := 0; i < 5; i++ {
+				journal.Record(event("bocaccio", time.Duration(i)*time.Second))
+			}
+
+			events := journal.Events("", time.Time{})
+			So(len(events), ShouldEqual, 3)
+			So(events[0].Time, ShouldEqual, baseTime.Add(2*time.Second))
+			So(events[2].Time, ShouldEqual, baseTime.Add(4*time.Second))
+		})
+
+		Convey("filters by service name or ID", func() {
+			journal.Record(event("bocaccio", 0))
+			journal.Record(event("shakespeare", time.Second))
+
+			So(len(journal.Events("shakespeare", time.Time{})), ShouldEqual, 1)
+			So(len(journal.Events("bocaccio-id", time.Time{})), ShouldEqual, 1)
+			So(journal.Events("chaucer", time.Time{}), ShouldBeEmpty)
+		})
+
+		Convey("filters by time", func() {
+			journal.Record(event("bocaccio", 0))
+			journal.Record(event("shakespeare", time.Second))
+
+			events := journal.Events("", baseTime)
+			So(len(events), ShouldEqual, 1)
+			So(events[0].Service.Name, ShouldEqual, "shakespeare")
+		})
+
+		Convey("works when nil", func() {
+			var journal *Journal
+			journal.Record(event("bocaccio", 0))
+			So(journal.Events("", time.Time{}), ShouldBeEmpty)
+		})
+
+		Convey("appends the events to a file", func() {
+			dir, err := ioutil.TempDir("", "sidecar-journal")
+			So(err, ShouldBeNil)
+			Reset(func() { os.RemoveAll(dir) })
+
+			filename := filepath.Join(dir, "journal.json")
+			So(journal.AppendTo(filename), ShouldBeNil)
+
+			journal.Record(event("bocaccio", 0))
+			journal.Record(event("shakespeare", time.Second))
+
+			var lines []string
+			for i := 0; i < 100 && len(lines) < 2; i++ {
+				time.Sleep(5 * time.Millisecond)
+				file, err := os.Open(filename)
+				So(err, ShouldBeNil)
+				lines = nil
+				scanner := bufio.NewScanner(file)
+				for scanner.Scan() {
+					lines = append(lines, scanner.Text())
+				}
+				file.Close()
+			}
+
+			So(len(lines), ShouldEqual, 2)
+
+			var written JournalEvent
+			So(json.Unmarshal([]byte(lines[1]), &written), ShouldBeNil)
+			So(written.Service.Name, ShouldEqual, "shakespeare")
+		})
+	})
+
+	Convey("The state records changes in the journal", t, func() {
+		state := NewServicesState()
+		state.Hostname = "chaucer"
+		state.Servers["chaucer"] = NewServer("chaucer")
+
+		svc := service.Service{
+			ID:       "deadbeef123",
+			Name:     "bocaccio",
+			Hostname: "shakespeare",
+			Updated:  time.Now().UTC(),
+			Status:   service.ALIVE,
+		}
+
+		Convey("when a service is announced", func() {
+			state.AddServiceEntry(svc)
+
+			events := state.Journal.Events("bocaccio", time.Time{})
+			So(len(events), ShouldEqual, 1)
+			So(events[0].Cause, ShouldEqual, CAUSE_ANNOUNCED)
+			So(events[0].Origin, ShouldEqual, "shakespeare")
+			So(events[0].PreviousStatus, ShouldEqual, "Unknown")
+			So(events[0].Status, ShouldEqual, "Alive")
+		})
+
+		Convey("when we tombstone a service", func() {
+			svc.Hostname = "chaucer"
+			state.AddServiceEntry(svc)
+
+			state.Lock()
+			state.TombstoneServices("chaucer", []service.Service{})
+			state.Unlock()
+
+			events := state.Journal.Events("bocaccio", time.Time{})
+			So(len(events), ShouldEqual, 2)
+			So(events[1].Cause, ShouldEqual, CAUSE_REMOVED)
+			So(events[1].Origin, ShouldEqual, "chaucer")
+			So(events[1].PreviousStatus, ShouldEqual, "Alive")
+			So(events[1].Status, ShouldEqual, "Tombstone")
+		})
+	})
+
+	Convey("The state keeps a revision", t, func() {
+		state := NewServicesState()
+		state.Hostname = "chaucer"
+		state.Servers["chaucer"] = NewServer("chaucer")
+
+		svc := service.Service{
+			ID:       "deadbeef123",
+			Name:     "bocaccio",
+			Hostname: "shakespeare",
+			Updated:  time.Now().UTC(),
+			Status:   service.ALIVE,
+		}
+
+		start := state.Revision()
+
+		Convey("that goes up with each change", func() {
+			state.AddServiceEntry(svc)
+			So(state.Revision(), ShouldEqual, start+1)
+
+			// Nothing changed this time
+			state.AddServiceEntry(svc)
+			So(state.Revision(), ShouldEqual, start+1)
+
+			svc.Status = service.UNHEALTHY
+			svc.Updated = svc.Updated.Add(time.Second)
+			state.AddServiceEntry(svc)
+			So(state.Revision(), ShouldEqual, start+2)
+		})
+
+		Convey("that listeners get with the change", func() {
+			listener := &mockListener{"listener1", make(chan ChangeEvent, 1), false}
+			state.AddListener(listener)
+
+			state.AddServiceEntry(svc)
+
+			event := <-listener.Chan()
+			So(event.Revision, ShouldEqual, start+1)
+		})
+
+		Convey("that we can replay changes from", func() {
+			state.AddServiceEntry(svc)
+			svc.Status = service.UNHEALTHY
+			svc.Updated = svc.Updated.Add(time.Second)
+			state.AddServiceEntry(svc)
+
+			changes, ok := state.ChangesSince(start)
+			So(ok, ShouldBeTrue)
+			So(len(changes), ShouldEqual, 2)
+			So(changes[0].Revision, ShouldEqual, start+1)
+			So(changes[1].Status, ShouldEqual, "Unhealthy")
+
+			changes, ok = state.ChangesSince(start + 1)
+			So(ok, ShouldBeTrue)
+			So(len(changes), ShouldEqual, 1)
+
+			changes, ok = state.ChangesSince(start + 2)
+			So(ok, ShouldBeTrue)
+			So(changes, ShouldBeEmpty)
+		})
+
+		Convey("that we can't replay changes from when it's too old or in the future", func() {
+			state.Journal = NewJournal(1)
+			state.AddServiceEntry(svc)
+			svc.Status = service.UNHEALTHY
+			svc.Updated = svc.Updated.Add(time.Second)
+			state.AddServiceEntry(svc)
+
+			_, ok := state.ChangesSince(start)
+			So(ok, ShouldBeFalse)
+
+			_, ok = state.ChangesSince(start + 3)
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
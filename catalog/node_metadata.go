@@ -0,0 +1,65 @@
+package catalog
+
+import (
+	"encoding/json"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// NodeMetadata describes a node in the cluster. Each node publishes its own
+// in memberlist's node metadata, so the rest of the cluster knows about it
+// as soon as the node joins.
+type NodeMetadata struct {
+	ClusterName string
+	State       string
+	Version     string            `json:",omitempty"` // The Sidecar version the node runs
+	Region      string            `json:",omitempty"`
+	Zone        string            `json:",omitempty"`
+	Rack        string            `json:",omitempty"`
+	Roles       []string          `json:",omitempty"`
+	Labels      map[string]string `json:",omitempty"`
+}
+
+// Encode returns the metadata encoded in no more than limit bytes. Labels
+// are the first thing to go when it doesn't fit, then everything but the
+// cluster name and state.
+func (meta *NodeMetadata) Encode(limit int) []byte {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		log.Errorf("Error encoding node metadata: %s", err)
+		return []byte("{}")
+	}
+
+	if len(data) <= limit {
+		return data
+	}
+
+	log.Errorf("Node metadata is %d bytes, more than the %d that fit. Dropping the labels.",
+		len(data), limit,
+	)
+	trimmed := *meta
+	trimmed.Labels = nil
+	data, _ = json.Marshal(&trimmed)
+	if len(data) <= limit {
+		return data
+	}
+
+	log.Errorf("Node metadata still doesn't fit, only sending the cluster name and state")
+	data, _ = json.Marshal(&NodeMetadata{ClusterName: meta.ClusterName, State: meta.State})
+	if len(data) <= limit {
+		return data
+	}
+
+	return []byte("{}")
+}
+
+// DecodeNodeMetadata decodes the metadata a node sent with Encode()
+func DecodeNodeMetadata(data []byte) (*NodeMetadata, error) {
+	var meta NodeMetadata
+	err := json.Unmarshal(data, &meta)
+	if err != nil {
+		return nil, err
+	}
+
+	return &meta, nil
+}
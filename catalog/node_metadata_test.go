@@ -0,0 +1,51 @@
+package catalog
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_NodeMetadata(t *testing.T) {
+	Convey("Encoding node metadata", t, func() {
+		meta := &NodeMetadata{
+			ClusterName: "default",
+			State:       "Running",
+			Version:     "1.2.3",
+			Region:      "us-east-1",
+			Zone:        "us-east-1a",
+			Roles:       []string{"edge"},
+			Labels:      map[string]string{"team": "platform"},
+		}
+
+		Convey("round trips through DecodeNodeMetadata()", func() {
+			decoded, err := DecodeNodeMetadata(meta.Encode(512))
+			So(err, ShouldBeNil)
+			So(decoded, ShouldResemble, meta)
+		})
+
+		Convey("drops the labels first when it doesn't fit", func() {
+			full := meta.Encode(512)
+			decoded, err := DecodeNodeMetadata(meta.Encode(len(full) - 1))
+			So(err, ShouldBeNil)
+			So(decoded.Labels, ShouldBeNil)
+			So(decoded.Region, ShouldEqual, "us-east-1")
+			So(meta.Labels, ShouldNotBeNil)
+		})
+
+		Convey("falls back to the cluster name and state", func() {
+			decoded, err := DecodeNodeMetadata(meta.Encode(50))
+			So(err, ShouldBeNil)
+			So(decoded, ShouldResemble, &NodeMetadata{ClusterName: "default", State: "Running"})
+		})
+
+		Convey("sends nothing when even that doesn't fit", func() {
+			So(string(meta.Encode(10)), ShouldEqual, "{}")
+		})
+
+		Convey("DecodeNodeMetadata() returns an error on junk", func() {
+			_, err := DecodeNodeMetadata([]byte("junk"))
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
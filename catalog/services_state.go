@@ -34,6 +34,7 @@ const (
 	ALIVE_SLEEP_INTERVAL       = 1 * time.Second                // Sleep between local service checks
 	ALIVE_BROADCAST_INTERVAL   = 1 * time.Minute                // Broadcast Alive messages every minute
 	LISTENER_EVENT_BUFFER_SIZE = 20                             // The number of events that can be buffered in the listener eventChannel
+	BROADCAST_BUFFER_SIZE      = 100                            // The number of batches of broadcasts waiting for the delegate
 )
 
 // A ChangeEvent represents the time and hostname that was modified and signals a major
@@ -43,6 +44,7 @@ type ChangeEvent struct {
 	Service        service.Service
 	PreviousStatus int
 	Time           time.Time
+	Revision       uint64 // The revision of the state after the change
 }
 
 // Holds the state about one server in our cluster
@@ -66,16 +68,30 @@ func NewServer(name string) *Server {
 	return server
 }
 
+// A Broadcast is an encoded service record on its way to our peers. It
+// carries the service along so the delegate doesn't have to decode it.
+type Broadcast struct {
+	Service *service.Service
+	Message []byte
+}
+
 // Holds the state about all the servers in the cluster
 type ServicesState struct {
 	Servers             map[string]*Server
 	LastChanged         time.Time
 	ClusterName         string
 	Hostname            string
-	Broadcasts          chan [][]byte        `json:"-"`
+	Broadcasts          chan []Broadcast     `json:"-"`
 	ServiceMsgs         chan service.Service `json:"-"`
+	WireFormat          service.WireFormat   `json:"-"` // How we encode gossip messages
+	Clock               *service.Clock       `json:"-"` // Stamps the records we announce
+	Journal             *Journal             `json:"-"` // The recent changes to services
 	listeners           map[string]Listener
 	tombstoneRetransmit time.Duration
+	stale               map[string]time.Time     // Services loaded from a snapshot and not yet confirmed
+	lastSeen            map[string]time.Time     // When we last received a newer record for a service
+	skews               map[string]time.Duration // How far ahead each server's clock is of ours
+	revision            uint64                   // Counts the changes to services, see Revision()
 	sync.RWMutex
 }
 
@@ -86,16 +102,29 @@ type Listener interface {
 	Managed() bool          // Is this managed by us? (e.g. auto-added/removed)
 }
 
+// A FilteredListener only receives the events it wants. It is asked about
+// every change, in order.
+type FilteredListener interface {
+	Listener
+	Wants(event *ChangeEvent) bool
+}
+
 // Returns a pointer to a properly configured ServicesState
 func NewServicesState() *ServicesState {
 	var err error
 	state := &ServicesState{
 		Servers:             make(map[string]*Server, 5),
-		Broadcasts:          make(chan [][]byte),
+		Broadcasts:          make(chan []Broadcast, BROADCAST_BUFFER_SIZE),
 		LastChanged:         time.Unix(0, 0),
 		tombstoneRetransmit: TOMBSTONE_RETRANSMIT,
 		ServiceMsgs:         make(chan service.Service, 25),
+		Clock:               service.NewClock(),
+		Journal:             NewJournal(JOURNAL_SIZE),
 		listeners:           make(map[string]Listener),
+		stale:               make(map[string]time.Time),
+		lastSeen:            make(map[string]time.Time),
+		skews:               make(map[string]time.Duration),
+		revision:            uint64(time.Now().UnixNano() / int64(time.Microsecond)),
 	}
 	state.Hostname, err = os.Hostname()
 	if err != nil {
@@ -174,9 +203,10 @@ func (state *ServicesState) ExpireServer(hostname string) {
 	var tombstones []service.Service
 
 	for _, svc := range state.Servers[hostname].Services {
+		state.clearStale(svc.Hostname, svc.ID)
 		previousStatus := svc.Status
-		svc.Tombstone()
-		state.ServiceChanged(svc, previousStatus, svc.Updated)
+		svc.Tombstone(state.Clock)
+		state.ServiceChanged(svc, previousStatus, svc.Updated, CAUSE_LEFT)
 		tombstones = append(tombstones, *svc)
 	}
 
@@ -191,12 +221,46 @@ func (state *ServicesState) ExpireServer(hostname string) {
 	)
 }
 
-// Tell the state that a particular service transitioned from one state to another.
-func (state *ServicesState) ServiceChanged(svc *service.Service, previousStatus int, updated time.Time) {
+// Tell the state that a particular service transitioned from one state to
+// another, and why. See the CAUSE_* constants.
+func (state *ServicesState) ServiceChanged(svc *service.Service, previousStatus int, updated time.Time, cause string) {
 	state.serverChanged(svc.Hostname, updated)
+	state.revision++
+	state.recordChange(svc, previousStatus, cause)
 	state.NotifyListeners(svc, previousStatus, state.LastChanged)
 }
 
+// Revision returns the current revision of the state. It goes up by one
+// each time a service changes. It starts from the time, in microseconds, so
+// that it keeps going up when Sidecar restarts and clients that resume from
+// a revision can tell they missed something. That also keeps it small enough
+// for JavaScript clients to parse exactly.
+// Note: Not synchronized!
+func (state *ServicesState) Revision() uint64 {
+	return state.revision
+}
+
+// ChangesSince returns the changes to services after the revision, oldest
+// first. It returns false when the journal doesn't go back that far, or
+// when the revision isn't one of ours.
+// Note: Not synchronized!
+func (state *ServicesState) ChangesSince(revision uint64) ([]JournalEvent, bool) {
+	if revision == state.revision {
+		return nil, true
+	}
+
+	if revision > state.revision {
+		return nil, false
+	}
+
+	events := state.Journal.Since(revision)
+	if len(events) == 0 || events[0].Revision != revision+1 {
+		return nil, false
+	}
+
+	return events, true
+}
+
 // Tell the state that something changed on a particular server so that it
 // can keep the timestamps up to date.
 // Note: not synchronized!
@@ -224,12 +288,18 @@ func (state *ServicesState) NotifyListeners(svc *service.Service, previousStatus
 
 	log.Debugf("Notifying listeners of change at %s", changedTime.String())
 
-	event := ChangeEvent{Service: *svc, PreviousStatus: previousStatus, Time: changedTime}
+	event := ChangeEvent{
+		Service: *svc, PreviousStatus: previousStatus, Time: changedTime, Revision: state.revision,
+	}
 	for _, listener := range listeners {
 		if listener == nil {
 			continue
 		}
 
+		if filtered, ok := listener.(FilteredListener); ok && !filtered.Wants(&event) {
+			continue
+		}
+
 		select {
 		case listener.Chan() <- event:
 			continue
@@ -302,12 +372,28 @@ func (state *ServicesState) AddServiceEntry(newSvc service.Service) {
 
 	server := state.Servers[newSvc.Hostname]
 
+	// Hearing about a service from anyone confirms what we loaded
+	// from the snapshot
+	state.clearStale(newSvc.Hostname, newSvc.ID)
+
+	// Keep our clock ahead of every record we hear about. Only the server
+	// that runs a service announces it alive, so the newest live record
+	// from a server tells us how far its clock is from ours.
+	offset := state.Clock.Observe(&newSvc)
+	if newSvc.Hostname != state.Hostname && !newSvc.IsTombstone() &&
+		newSvc.Updated.After(server.LastUpdated) {
+		state.setSkew(newSvc.Hostname, offset)
+	}
+
 	// Only apply changes that are newer or services are missing
 	if !server.HasService(newSvc.ID) {
 		server.Services[newSvc.ID] = &newSvc
-		state.ServiceChanged(&newSvc, service.UNKNOWN, newSvc.Updated)
+		state.markSeen(newSvc.Hostname, newSvc.ID)
+		state.ServiceChanged(&newSvc, service.UNKNOWN, newSvc.Updated, CAUSE_ANNOUNCED)
 		state.retransmit(newSvc)
 	} else if newSvc.Invalidates(server.Services[newSvc.ID]) {
+		state.markSeen(newSvc.Hostname, newSvc.ID)
+
 		// We have to set these even if the status did not change
 		server.LastUpdated = newSvc.Updated
 
@@ -322,10 +408,12 @@ func (state *ServicesState) AddServiceEntry(newSvc service.Service) {
 		// Update the new one
 		server.Services[newSvc.ID] = &newSvc
 
-		// When the status changes, the SeviceChanged() method will
-		// update all the accounting fields in the state and Server newSvc.
-		if oldEntry.Status != newSvc.Status {
-			state.ServiceChanged(&newSvc, oldEntry.Status, newSvc.Updated)
+		// When the status or anything else about the service changes, the
+		// ServiceChanged() method will update all the accounting fields in
+		// the state and Server newSvc. A record that only refreshes the
+		// service isn't a change.
+		if !newSvc.SameContent(oldEntry) {
+			state.ServiceChanged(&newSvc, oldEntry.Status, newSvc.Updated, CAUSE_ANNOUNCED)
 		}
 
 		// We tell our gossip peers about the updated service
@@ -371,12 +459,12 @@ func (state *ServicesState) retransmit(svc service.Service) {
 	}
 
 	go func() {
-		encoded, err := svc.Encode()
+		encoded, err := svc.EncodeWire(state.WireFormat)
 		if err != nil {
 			log.Errorf("ERROR encoding message to forward: (%s)", err.Error())
 			return
 		}
-		state.Broadcasts <- [][]byte{encoded}
+		state.broadcast([]Broadcast{{Service: &svc, Message: encoded}})
 	}()
 }
 
@@ -435,6 +523,7 @@ func (state *ServicesState) Print(list *memberlist.Memberlist) {
 func (state *ServicesState) TrackNewServices(fn func() []service.Service, looper director.Looper) {
 	looper.Loop(func() error {
 		for _, svc := range fn() {
+			state.Clock.Stamp(&svc)
 			state.UpdateService(svc)
 		}
 		return nil
@@ -526,6 +615,7 @@ func (state *ServicesState) BroadcastServices(fn func() []service.Service, loope
 
 		for _, svc := range servicesList {
 			isNew := state.IsNewService(&svc)
+			state.Clock.Stamp(&svc)
 
 			// We'll broadcast it now if it's new or we've hit refresh window
 			if isNew {
@@ -555,7 +645,7 @@ func (state *ServicesState) BroadcastServices(fn func() []service.Service, loope
 		} else {
 			// We expect there to always be _something_ in the channel
 			// once we've run.
-			state.Broadcasts <- nil
+			state.broadcast(nil)
 		}
 
 		return nil
@@ -572,26 +662,39 @@ func (state *ServicesState) SendServices(services []service.Service, looper dire
 
 		additionalTime := 0 * time.Second
 		looper.Loop(func() error {
-			var prepared [][]byte
+			var prepared []Broadcast
 
 			for _, svc := range services {
-				svc.Updated = svc.Updated.Add(additionalTime)
-				encoded, err := svc.Encode()
+				svc := svc // Each Broadcast needs its own copy
+				state.Clock.Supersede(&svc, additionalTime)
+				encoded, err := svc.EncodeWire(state.WireFormat)
 				if err != nil {
 					log.Errorf("ERROR encoding container: (%s)", err.Error())
 				}
-				prepared = append(prepared, encoded)
+				prepared = append(prepared, Broadcast{Service: &svc, Message: encoded})
 			}
 
 			// We add time to make sure that these get retransmitted by peers.
 			// Otherwise they aren't "new" messages and don't get retransmitted.
 			additionalTime = additionalTime + 50*time.Nanosecond
-			state.Broadcasts <- prepared // Put it on the wire
+			state.broadcast(prepared) // Put it on the wire
 			return nil
 		})
 	}()
 }
 
+// broadcast hands messages to the delegate to send. It never blocks, if the
+// delegate has fallen that far behind, we drop them. Services are announced
+// repeatedly, so they will go out next time.
+func (state *ServicesState) broadcast(messages []Broadcast) {
+	select {
+	case state.Broadcasts <- messages:
+	default:
+		metrics.IncrCounter([]string{"services_state", "droppedBroadcasts"}, float32(len(messages)))
+		log.Debugf("Broadcasts channel is full, dropped %d messages", len(messages))
+	}
+}
+
 func (state *ServicesState) BroadcastTombstones(fn func() []service.Service, looper director.Looper) {
 	looper.Loop(func() error {
 		defer metrics.MeasureSince([]string{"services_state", "BroadcastTombstones"}, time.Now())
@@ -614,7 +717,7 @@ func (state *ServicesState) BroadcastTombstones(fn func() []service.Service, loo
 		} else {
 			// We expect there to always be _something_ in the channel
 			// once we've run.
-			state.Broadcasts <- nil
+			state.broadcast(nil)
 		}
 
 		return nil
@@ -634,10 +737,13 @@ func (state *ServicesState) TombstoneOthersServices() []service.Service {
 		if svc.IsTombstone() &&
 			svc.Updated.Before(time.Now().UTC().Add(0-TOMBSTONE_LIFESPAN)) {
 			delete(state.Servers[*hostname].Services, *id)
+			state.clearStale(*hostname, *id)
+			delete(state.lastSeen, staleKey(*hostname, *id))
 
 			// If this is the last service, remove the server
 			if len(state.Servers[*hostname].Services) < 1 {
 				delete(state.Servers, *hostname)
+				state.clearSkew(*hostname)
 			}
 		}
 
@@ -645,10 +751,20 @@ func (state *ServicesState) TombstoneOthersServices() []service.Service {
 		if svc.IsDraining() {
 			svcLifespan = DRAINING_LIFESPAN
 		}
+		// We time the lifespan from when we last heard about the service,
+		// so it doesn't depend on the sender's clock agreeing with ours.
+		// Services loaded from a snapshot get a full lifespan from when
+		// they were loaded to be confirmed by gossip
+		lastSeen := state.seenAt(svc)
+		if loaded, ok := state.stale[staleKey(*hostname, *id)]; ok && loaded.After(lastSeen) {
+			lastSeen = loaded
+		}
+
 		// Everything that is not tombstoned needs to be considered for
 		// removal if it exceeds the allowed ALIVE_TIMESPAN
 		if !svc.IsTombstone() &&
-			svc.Updated.Before(time.Now().UTC().Add(0-svcLifespan)) {
+			lastSeen.Before(time.Now().UTC().Add(0-svcLifespan)) {
+			state.clearStale(*hostname, *id)
 			log.Warnf("Found expired service %s ID %s from %s, tombstoning",
 				svc.Name, svc.ID, svc.Hostname,
 			)
@@ -658,11 +774,11 @@ func (state *ServicesState) TombstoneOthersServices() []service.Service {
 			// timestamp + 1 second. This way we don't invalidate newer records
 			// we didn't see. This might happen when any node is removed from
 			// cluster and re-joins, for example. So we can't use svc.Tombstone()
-			// which updates the timestamp to Now().UTC()
+			// which takes the current time from the clock
 			previousStatus := svc.Status
 			svc.Status = service.TOMBSTONE
-			svc.Updated = svc.Updated.Add(time.Second)
-			state.ServiceChanged(svc, previousStatus, svc.Updated)
+			state.Clock.Supersede(svc, time.Second)
+			state.ServiceChanged(svc, previousStatus, svc.Updated, CAUSE_EXPIRED)
 
 			result = append(result, *svc)
 		}
@@ -687,11 +803,17 @@ func (state *ServicesState) TombstoneServices(hostname string, containerList []s
 
 	// Tombstone our own services that went away
 	for id, svc := range services {
+		// Discovery may not have found services loaded from the snapshot
+		// yet. They expire like everyone else's if they don't show up.
+		if state.IsStale(svc) {
+			continue
+		}
+
 		if _, ok := mapping[id]; !ok && !svc.IsTombstone() {
 			log.Warnf("Tombstoning %s", svc.ID)
 			previousStatus := svc.Status
-			svc.Tombstone()
-			state.ServiceChanged(svc, previousStatus, svc.Updated)
+			svc.Tombstone(state.Clock)
+			state.ServiceChanged(svc, previousStatus, svc.Updated, CAUSE_REMOVED)
 
 			// Tombstone each record twice to help with receipt
 			for i := 0; i < 2; i++ {
@@ -703,6 +825,35 @@ func (state *ServicesState) TombstoneServices(hostname string, containerList []s
 	return result
 }
 
+// DrainLocalServices sets all of the live services on this host to DRAINING
+// and returns them so they can be announced. Used on shutdown so our peers
+// stop sending new traffic to them before they go away.
+func (state *ServicesState) DrainLocalServices() []service.Service {
+	state.Lock()
+	defer state.Unlock()
+
+	if !state.HasServer(state.Hostname) {
+		return nil
+	}
+
+	var result []service.Service
+
+	for _, svc := range state.Servers[state.Hostname].Services {
+		if !svc.IsAlive() {
+			continue
+		}
+
+		previousStatus := svc.Status
+		svc.Status = service.DRAINING
+		svc.Updated, svc.Logical = state.Clock.Now()
+		state.ServiceChanged(svc, previousStatus, svc.Updated, CAUSE_DRAINED)
+
+		result = append(result, *svc)
+	}
+
+	return result
+}
+
 func (state *ServicesState) EachServer(fn func(hostname *string, server *Server)) {
 	if state == nil {
 		return
@@ -736,6 +887,38 @@ func (state *ServicesState) ByService() map[string][]*service.Service {
 	return serviceMap
 }
 
+// Filtered returns a copy of the state with only the services that match,
+// and the servers they run on. The services are shared with the state.
+// Note: Not synchronized!
+func (state *ServicesState) Filtered(matches func(svc *service.Service) bool) *ServicesState {
+	filtered := &ServicesState{
+		Servers:     make(map[string]*Server),
+		LastChanged: state.LastChanged,
+		ClusterName: state.ClusterName,
+		Hostname:    state.Hostname,
+	}
+
+	state.EachServer(func(hostname *string, server *Server) {
+		for id, svc := range server.Services {
+			if !matches(svc) {
+				continue
+			}
+
+			if _, ok := filtered.Servers[*hostname]; !ok {
+				filtered.Servers[*hostname] = &Server{
+					Name:        server.Name,
+					Services:    make(map[string]*service.Service),
+					LastUpdated: server.LastUpdated,
+					LastChanged: server.LastChanged,
+				}
+			}
+			filtered.Servers[*hostname].Services[id] = svc
+		}
+	})
+
+	return filtered
+}
+
 func DecodeStream(input io.Reader, callback func(map[string][]*service.Service, error)) error {
 	dec := json.NewDecoder(input)
 	for dec.More() {
@@ -759,10 +942,17 @@ func makeServiceMapping(svcList []service.Service) map[string]*service.Service {
 	return mapping
 }
 
-// Take a byte slice and return a properly reconstituted state struct
+// Take a byte slice, in JSON or the compact wire format, and return a
+// properly reconstituted state struct
 func Decode(data []byte) (*ServicesState, error) {
 	newState := NewServicesState()
-	err := newState.UnmarshalJSON(data)
+
+	var err error
+	if service.IsJSON(data) {
+		err = newState.UnmarshalJSON(data)
+	} else {
+		err = newState.decodeWire(data)
+	}
 	if err != nil {
 		log.Errorf("Error decoding state! (%s)", err.Error())
 	}
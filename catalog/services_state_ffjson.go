@@ -57,6 +57,8 @@ func (j *ChangeEvent) MarshalJSONBuf(buf fflib.EncodingBuffer) error {
 		buf.Write(obj)
 
 	}
+	buf.WriteString(`,"Revision":`)
+	fflib.FormatBits2(buf, uint64(j.Revision), 10, false)
 	buf.WriteByte('}')
 	return nil
 }
@@ -70,6 +72,8 @@ const (
 	ffjtChangeEventPreviousStatus
 
 	ffjtChangeEventTime
+
+	ffjtChangeEventRevision
 )
 
 var ffjKeyChangeEventService = []byte("Service")
@@ -78,6 +82,8 @@ var ffjKeyChangeEventPreviousStatus = []byte("PreviousStatus")
 
 var ffjKeyChangeEventTime = []byte("Time")
 
+var ffjKeyChangeEventRevision = []byte("Revision")
+
 // UnmarshalJSON umarshall json - template of ffjson
 func (j *ChangeEvent) UnmarshalJSON(input []byte) error {
 	fs := fflib.NewFFLexer(input)
@@ -147,6 +153,14 @@ mainparse:
 						goto mainparse
 					}
 
+				case 'R':
+
+					if bytes.Equal(ffjKeyChangeEventRevision, kn) {
+						currentKey = ffjtChangeEventRevision
+						state = fflib.FFParse_want_colon
+						goto mainparse
+					}
+
 				case 'S':
 
 					if bytes.Equal(ffjKeyChangeEventService, kn) {
@@ -165,6 +179,12 @@ mainparse:
 
 				}
 
+				if fflib.EqualFoldRight(ffjKeyChangeEventRevision, kn) {
+					currentKey = ffjtChangeEventRevision
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
 				if fflib.SimpleLetterEqualFold(ffjKeyChangeEventTime, kn) {
 					currentKey = ffjtChangeEventTime
 					state = fflib.FFParse_want_colon
@@ -209,6 +229,9 @@ mainparse:
 				case ffjtChangeEventTime:
 					goto handle_Time
 
+				case ffjtChangeEventRevision:
+					goto handle_Revision
+
 				case ffjtChangeEventnosuchkey:
 					err = fs.SkipField(tok)
 					if err != nil {
@@ -298,6 +321,36 @@ handle_Time:
 	state = fflib.FFParse_after_value
 	goto mainparse
 
+handle_Revision:
+
+	/* handler: j.Revision type=uint64 kind=uint64 quoted=false*/
+
+	{
+		if tok != fflib.FFTok_integer && tok != fflib.FFTok_null {
+			return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for uint64", tok))
+		}
+	}
+
+	{
+
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			tval, err := fflib.ParseUint(fs.Output.Bytes(), 10, 64)
+
+			if err != nil {
+				return fs.WrapErr(err)
+			}
+
+			j.Revision = uint64(tval)
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
 wantedvalue:
 	return fs.WrapErr(fmt.Errorf("wanted value token, but got token: %v", tok))
 wrongtokenerror:
@@ -31,6 +31,15 @@ func (l *mockListener) Chan() chan ChangeEvent {
 	return l.events
 }
 
+type mockFilteredListener struct {
+	mockListener
+	wants string
+}
+
+func (l *mockFilteredListener) Wants(event *ChangeEvent) bool {
+	return event.Service.Name == l.wants
+}
+
 func (l *mockListener) Managed() bool {
 	return l.managed
 }
@@ -105,6 +114,34 @@ func Test_ServicesStateWithData(t *testing.T) {
 			So(len(decoded.Servers), ShouldEqual, 1)
 		})
 
+		Convey("EncodeWire() generates the compact format that we can Decode()", func() {
+			state.AddServiceEntry(svc)
+			state.ClusterName = "boxen"
+			state.WireFormat = service.COMPRESSED_FORMAT
+
+			encoded := state.EncodeWire()
+			So(encoded[0], ShouldEqual, service.WIRE_VERSION_COMPRESSED)
+
+			decoded, err := Decode(encoded)
+
+			So(err, ShouldBeNil)
+			So(decoded.ClusterName, ShouldEqual, "boxen")
+			So(len(decoded.Servers), ShouldEqual, 2)
+			So(decoded.Servers[hostname].Name, ShouldEqual, hostname)
+			So(decoded.Servers[anotherHostname].Services[svcId], ShouldResemble, &svc)
+		})
+
+		Convey("EncodeWire() generates JSON by default", func() {
+			So(service.IsJSON(state.EncodeWire()), ShouldBeTrue)
+		})
+
+		Convey("Decode() accepts pretty printed JSON", func() {
+			decoded, err := Decode(append([]byte("\n  "), state.Encode()...))
+
+			So(err, ShouldBeNil)
+			So(decoded.Servers[hostname].Name, ShouldEqual, hostname)
+		})
+
 		Convey("Decode() returns an error when handed junk", func() {
 			result, err := Decode([]byte("asdf"))
 
@@ -174,7 +211,7 @@ func Test_ServicesStateWithData(t *testing.T) {
 			Convey("Updates the LastChanged time for a service when changing", func() {
 				state.AddServiceEntry(svc)
 				lastChanged := state.LastChanged
-				svc.Tombstone()
+				svc.Tombstone(state.Clock)
 				state.AddServiceEntry(svc)
 
 				So(state.LastChanged.After(lastChanged), ShouldBeTrue)
@@ -189,22 +226,64 @@ func Test_ServicesStateWithData(t *testing.T) {
 				So(state.LastChanged.After(lastChanged), ShouldBeFalse)
 			})
 
+			Convey("Announces a change to a service's ports", func() {
+				listener := &mockListener{"listener1", make(chan ChangeEvent, 2), false}
+				state.AddListener(listener)
+
+				svc.Ports = []service.Port{{Type: "tcp", Port: 10234, ServicePort: 9999}}
+				state.AddServiceEntry(svc)
+				<-listener.Chan()
+				revision := state.Revision()
+
+				svc.Ports = []service.Port{{Type: "tcp", Port: 10235, ServicePort: 9999}}
+				svc.Updated = svc.Updated.Add(time.Second)
+				state.AddServiceEntry(svc)
+
+				So(len(listener.Chan()), ShouldEqual, 1)
+				event := <-listener.Chan()
+				So(event.Service.Ports[0].Port, ShouldEqual, 10235)
+				So(event.PreviousStatus, ShouldEqual, service.ALIVE)
+				So(event.Revision, ShouldBeGreaterThan, revision)
+				So(state.Revision(), ShouldBeGreaterThan, revision)
+			})
+
+			Convey("Announces a change to a service's tags", func() {
+				listener := &mockListener{"listener1", make(chan ChangeEvent, 2), false}
+				state.AddListener(listener)
+
+				svc.Tags = map[string]string{"epic": "beowulf"}
+				state.AddServiceEntry(svc)
+				<-listener.Chan()
+				revision := state.Revision()
+
+				svc.Tags = map[string]string{"epic": "grendel"}
+				svc.Updated = svc.Updated.Add(time.Second)
+				state.AddServiceEntry(svc)
+
+				So(len(listener.Chan()), ShouldEqual, 1)
+				event := <-listener.Chan()
+				So(event.Service.Tags["epic"], ShouldEqual, "grendel")
+				So(event.PreviousStatus, ShouldEqual, service.ALIVE)
+				So(event.Revision, ShouldBeGreaterThan, revision)
+				So(state.Revision(), ShouldBeGreaterThan, revision)
+			})
+
 			Convey("Retransmits a packet when the state changes", func() {
 				state.AddServiceEntry(svc)
 				<-state.Broadcasts // Catch the retransmit from the initial add
-				svc.Tombstone()
+				svc.Tombstone(state.Clock)
 				state.AddServiceEntry(svc)
 
 				packet := <-state.Broadcasts
 
 				encoded, _ := svc.Encode()
 				So(len(packet), ShouldEqual, 1)
-				So(string(packet[0]), ShouldEqual, string(encoded))
+				So(string(packet[0].Message), ShouldEqual, string(encoded))
 			})
 
 			Convey("Doesn't retransmit an add of a new service for this host", func() {
 				state.Hostname = hostname
-				state.Broadcasts = make(chan [][]byte, 1)
+				state.Broadcasts = make(chan []Broadcast, 1)
 				svc.Hostname = hostname
 				state.AddServiceEntry(svc)
 
@@ -285,6 +364,30 @@ func Test_ServicesStateWithData(t *testing.T) {
 			So(secondState.Servers[svcId], ShouldEqual, firstState.Servers[svcId])
 		})
 
+		Convey("Filtered() returns only the services that match, and their servers", func() {
+			state.AddServiceEntry(svc)
+			other := svc
+			other.ID = "deadbeef456"
+			other.Name = "bocaccio"
+			state.AddServiceEntry(other)
+
+			filtered := state.Filtered(func(svc *service.Service) bool {
+				return svc.Name == "radical_service"
+			})
+
+			So(len(filtered.Servers), ShouldEqual, 1)
+			So(len(filtered.Servers[anotherHostname].Services), ShouldEqual, 1)
+			So(filtered.Servers[anotherHostname].Services[svcId], ShouldEqual, state.Servers[anotherHostname].Services[svcId])
+			So(filtered.LastChanged, ShouldEqual, state.LastChanged)
+
+			// The state itself is left alone
+			So(len(state.Servers[anotherHostname].Services), ShouldEqual, 2)
+
+			decoded, err := Decode(filtered.Encode())
+			So(err, ShouldBeNil)
+			So(decoded.Servers[anotherHostname].Services[svcId].Name, ShouldEqual, "radical_service")
+		})
+
 		Convey("Format() pretty-prints the state even without a Memberlist", func() {
 			formatted := state.Format(nil)
 
@@ -322,7 +425,7 @@ func Test_TrackingAndBroadcasting(t *testing.T) {
 
 		Convey("The correct number of messages are sent", func() {
 			looper := director.NewFreeLooper(5, make(chan error))
-			state.Broadcasts = make(chan [][]byte, 5)
+			state.Broadcasts = make(chan []Broadcast, 5)
 			state.SendServices(services, looper)
 			err := looper.Wait()
 			So(err, ShouldBeNil)
@@ -351,8 +454,8 @@ func Test_TrackingAndBroadcasting(t *testing.T) {
 
 			readBroadcasts := <-state.Broadcasts
 			So(len(readBroadcasts), ShouldEqual, 2)
-			So(string(readBroadcasts[0]), ShouldEqual, string(json1))
-			So(string(readBroadcasts[1]), ShouldEqual, string(json2))
+			So(string(readBroadcasts[0].Message), ShouldEqual, string(json1))
+			So(string(readBroadcasts[1].Message), ShouldEqual, string(json2))
 		})
 
 		Convey("Puts a nil into the broadcasts channel when no services", func() {
@@ -373,15 +476,15 @@ func Test_TrackingAndBroadcasting(t *testing.T) {
 			readBroadcasts := <-state.Broadcasts
 			So(len(readBroadcasts), ShouldEqual, 2) // 2 per service
 			// Match with regexes since the timestamp changes during tombstoning
-			So(readBroadcasts[0], ShouldMatch, "^{\"ID\":\"runs\".*\"Status\":1}$")
-			So(readBroadcasts[1], ShouldMatch, "^{\"ID\":\"runs\".*\"Status\":1}$")
+			So(readBroadcasts[0].Message, ShouldMatch, "^{\"ID\":\"runs\".*\"Status\":1}$")
+			So(readBroadcasts[1].Message, ShouldMatch, "^{\"ID\":\"runs\".*\"Status\":1}$")
 		})
 
 		Convey("The timestamp is incremented on each subsequent service broadcast background run", func() {
-			state.Broadcasts = make(chan [][]byte, 4)
+			state.Broadcasts = make(chan []Broadcast, 4)
 			looper := director.NewFreeLooper(2, make(chan error))
-			service1.Tombstone()
-			service2.Tombstone()
+			service1.Tombstone(state.Clock)
+			service2.Tombstone(state.Clock)
 			go state.SendServices([]service.Service{service1, service2}, looper)
 
 			err := looper.Wait()
@@ -391,14 +494,14 @@ func Test_TrackingAndBroadcasting(t *testing.T) {
 			broadcasts := <-state.Broadcasts
 			So(len(broadcasts), ShouldEqual, 2)
 			// It's JSON so just string match rather than decoding
-			So(broadcasts[0], ShouldMatch, service1.Updated.Format(time.RFC3339Nano))
-			So(broadcasts[1], ShouldMatch, service2.Updated.Format(time.RFC3339Nano))
+			So(broadcasts[0].Message, ShouldMatch, service1.Updated.Format(time.RFC3339Nano))
+			So(broadcasts[1].Message, ShouldMatch, service2.Updated.Format(time.RFC3339Nano))
 
 			// Second go-round
 			broadcasts = <-state.Broadcasts
 			So(len(broadcasts), ShouldEqual, 2)
-			So(broadcasts[0], ShouldMatch, service1.Updated.Add(50*time.Nanosecond).Format(time.RFC3339Nano))
-			So(broadcasts[1], ShouldMatch, service2.Updated.Add(50*time.Nanosecond).Format(time.RFC3339Nano))
+			So(broadcasts[0].Message, ShouldMatch, service1.Updated.Add(50*time.Nanosecond).Format(time.RFC3339Nano))
+			So(broadcasts[1].Message, ShouldMatch, service2.Updated.Add(50*time.Nanosecond).Format(time.RFC3339Nano))
 		})
 
 		Convey("The LastChanged time is changed when a service is Tombstoned", func() {
@@ -430,7 +533,7 @@ func Test_TrackingAndBroadcasting(t *testing.T) {
 		})
 
 		Convey("Tombstones have a lifespan, then expire", func() {
-			service1.Tombstone()
+			service1.Tombstone(state.Clock)
 			service1.Updated = service1.Updated.Add(0 - TOMBSTONE_LIFESPAN - 1*time.Minute)
 			state.AddServiceEntry(service1)
 			state.AddServiceEntry(service2)
@@ -446,7 +549,7 @@ func Test_TrackingAndBroadcasting(t *testing.T) {
 			state := NewServicesState() // Totally empty
 			state.Hostname = hostname
 			state.AddServiceEntry(service1)
-			state.Servers[hostname].Services[service1.ID].Tombstone()
+			state.Servers[hostname].Services[service1.ID].Tombstone(state.Clock)
 			state.Servers[hostname].Services[service1.ID].Updated =
 				service1.Updated.Add(0 - TOMBSTONE_LIFESPAN - 1*time.Minute)
 
@@ -461,6 +564,7 @@ func Test_TrackingAndBroadcasting(t *testing.T) {
 			svc := state.Servers[hostname].Services[service1.ID]
 			stamp := service1.Updated.Add(0 - ALIVE_LIFESPAN - 5*time.Second)
 			svc.Updated = stamp
+			state.lastSeen[staleKey(hostname, service1.ID)] = stamp
 
 			state.TombstoneOthersServices()
 
@@ -476,6 +580,7 @@ func Test_TrackingAndBroadcasting(t *testing.T) {
 			svc := state.Servers[hostname].Services[service1.ID]
 			stamp := service1.Updated.Add(0 - DRAINING_LIFESPAN - 5*time.Second)
 			svc.Updated = stamp
+			state.lastSeen[staleKey(hostname, service1.ID)] = stamp
 
 			state.TombstoneOthersServices()
 
@@ -491,6 +596,7 @@ func Test_TrackingAndBroadcasting(t *testing.T) {
 			svc := state.Servers[hostname].Services[service1.ID]
 			stamp := service1.Updated.Add(0 - ALIVE_LIFESPAN - 5*time.Second)
 			svc.Updated = stamp
+			state.lastSeen[staleKey(hostname, service1.ID)] = stamp
 
 			state.TombstoneOthersServices()
 
@@ -511,6 +617,8 @@ func Test_TrackingAndBroadcasting(t *testing.T) {
 
 			svcs["unhealthy_shakespeare"].Updated = stamp
 			svcs["unknown_shakespeare"].Updated = stamp
+			state.lastSeen[staleKey(hostname, "unhealthy_shakespeare")] = stamp
+			state.lastSeen[staleKey(hostname, "unknown_shakespeare")] = stamp
 
 			state.TombstoneOthersServices()
 
@@ -518,6 +626,29 @@ func Test_TrackingAndBroadcasting(t *testing.T) {
 			So(svcs["unknown_shakespeare"].Status, ShouldEqual, service.TOMBSTONE)
 		})
 
+		Convey("Alive services are timed from when we heard about them", func() {
+			behind := service.Service{ID: "slow_shakespeare", Hostname: anotherHostname, Updated: baseTime.Add(0 - ALIVE_LIFESPAN - 5*time.Second)}
+			state.AddServiceEntry(behind)
+
+			state.TombstoneOthersServices()
+
+			So(state.Servers[anotherHostname].Services[behind.ID].Status, ShouldEqual, service.ALIVE)
+		})
+
+		Convey("Tracks how far each server's clock is from ours", func() {
+			ahead := service.Service{ID: "fast_shakespeare", Hostname: anotherHostname, Updated: time.Now().UTC().Add(time.Minute)}
+			state.AddServiceEntry(ahead)
+
+			So(state.ClockSkew(anotherHostname), ShouldBeBetween, 59*time.Second, time.Minute)
+			So(state.ClockSkew("unknown"), ShouldEqual, 0)
+
+			Convey("and stamps our records ahead of theirs", func() {
+				svc := service.Service{ID: "fast_shakespeare", Hostname: anotherHostname, Updated: time.Now().UTC()}
+				state.Clock.Stamp(&svc)
+				So(svc.Invalidates(&ahead), ShouldBeTrue)
+			})
+		})
+
 		Convey("Tombstones aren't re-tombstoned", func() {
 			tombstonedService := service.Service{ID: "dead_shakespeare", Hostname: hostname, Updated: baseTime, Status: service.TOMBSTONE}
 			state.AddServiceEntry(tombstonedService)
@@ -544,6 +675,31 @@ func Test_TrackingAndBroadcasting(t *testing.T) {
 
 			So(state.IsNewService(&services[0]), ShouldBeFalse)
 		})
+
+		Convey("DrainLocalServices() drains only the live local services", func() {
+			// Rounding could put baseTime in the future, so use a time
+			// that has passed
+			updated := time.Now().UTC().Truncate(time.Second)
+			service1.Status = service.ALIVE
+			service1.Updated = updated
+			service2.Status = service.UNHEALTHY
+			service2.Updated = updated
+			state.AddServiceEntry(service1)
+			state.AddServiceEntry(service2)
+			otherService := service.Service{ID: "chaucer_svc", Hostname: anotherHostname, Updated: updated, Status: service.ALIVE}
+			state.AddServiceEntry(otherService)
+
+			before := time.Now().UTC()
+			drained := state.DrainLocalServices()
+
+			So(len(drained), ShouldEqual, 1)
+			So(drained[0].ID, ShouldEqual, svcId1)
+			So(drained[0].Updated.Before(before), ShouldBeFalse)
+			So(drained[0].Invalidates(&service1), ShouldBeTrue)
+			So(state.Servers[hostname].Services[svcId1].Status, ShouldEqual, service.DRAINING)
+			So(state.Servers[hostname].Services[svcId2].Status, ShouldEqual, service.UNHEALTHY)
+			So(state.Servers[anotherHostname].Services["chaucer_svc"].Status, ShouldEqual, service.ALIVE)
+		})
 	})
 }
 
@@ -603,6 +759,21 @@ func Test_Listeners(t *testing.T) {
 			So(result2.Service.Hostname, ShouldEqual, hostname)
 		})
 
+		Convey("A filtered listener only hears about the services it wants", func() {
+			filtered := &mockFilteredListener{
+				mockListener{"filtered", make(chan ChangeEvent, 2), false}, "bocaccio",
+			}
+			state.AddListener(filtered)
+
+			state.AddServiceEntry(svc1)
+
+			svc2 := service.Service{ID: "deadbeef456", Name: "bocaccio", Hostname: hostname, Updated: baseTime}
+			state.AddServiceEntry(svc2)
+
+			So(len(filtered.Chan()), ShouldEqual, 1)
+			So((<-filtered.Chan()).Service.ID, ShouldEqual, "deadbeef456")
+		})
+
 		Convey("GetListeners() returns all the listeners", func() {
 			state.AddListener(listener)
 			state.AddListener(listener2)
@@ -680,8 +851,8 @@ func Test_ClusterMembershipManagement(t *testing.T) {
 
 				So(len(expired), ShouldEqual, 2)
 				// Timestamps chagne when tombstoning, so regex match
-				So(expired[0], ShouldMatch, "^{\"ID\":\"deadbeef.*\"Status\":1}$")
-				So(expired[1], ShouldMatch, "^{\"ID\":\"deadbeef.*\"Status\":1}$")
+				So(expired[0].Message, ShouldMatch, "^{\"ID\":\"deadbeef.*\"Status\":1}$")
+				So(expired[1].Message, ShouldMatch, "^{\"ID\":\"deadbeef.*\"Status\":1}$")
 
 				Convey("and sends the tombstones to any listener", func() {
 					for i := 0; i < len(state.Servers[hostname].Services); i++ {
@@ -0,0 +1,146 @@
+package catalog
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+	"github.com/relistan/go-director"
+	log "github.com/sirupsen/logrus"
+)
+
+// The snapshot is an optional copy of the state on disk. We load it at
+// boot so that the proxies have backends to send traffic to while gossip
+// rebuilds the cluster view. Services loaded from it are stale until we
+// hear about them again, and they expire under the normal ALIVE_LIFESPAN
+// rules if we don't.
+
+const (
+	SNAPSHOT_INTERVAL = 10 * time.Second // How often we write the snapshot
+)
+
+// WriteSnapshot encodes the state and writes it to the named file. The file
+// is replaced atomically so a crash never leaves a partial snapshot behind.
+func (state *ServicesState) WriteSnapshot(filename string) error {
+	state.RLock()
+	data := state.Encode()
+	state.RUnlock()
+
+	if len(data) == 0 {
+		return fmt.Errorf("unable to encode state")
+	}
+
+	tmpFile, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name()) // Fails harmlessly after the rename
+
+	_, err = tmpFile.Write(data)
+	if err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	err = tmpFile.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), filename)
+}
+
+// WriteSnapshots is to be run in a goroutine, and writes a snapshot on each
+// iteration of the looper.
+func (state *ServicesState) WriteSnapshots(filename string, looper director.Looper) {
+	looper.Loop(func() error {
+		err := state.WriteSnapshot(filename)
+		if err != nil {
+			log.Errorf("Unable to write state snapshot %s: %s", filename, err)
+		}
+		return nil
+	})
+}
+
+// LoadSnapshot reads a snapshot written by WriteSnapshot and adds the
+// services from it that we don't already know about. Live services are
+// marked stale until gossip or discovery confirms them. A missing file is
+// not an error, there is nothing to load on the first boot.
+func (state *ServicesState) LoadSnapshot(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		log.Infof("No state snapshot found at %s", filename)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	snapshot, err := Decode(data)
+	if err != nil {
+		return err
+	}
+
+	state.Lock()
+	defer state.Unlock()
+
+	if snapshot.ClusterName != state.ClusterName {
+		return fmt.Errorf("snapshot is for cluster %q, not %q",
+			snapshot.ClusterName, state.ClusterName,
+		)
+	}
+
+	now := time.Now().UTC()
+	loaded := 0
+
+	snapshot.EachService(func(hostname *string, id *string, svc *service.Service) {
+		// Our peers have forgotten about anything this old
+		if svc.Updated.Before(now.Add(0 - TOMBSTONE_LIFESPAN)) {
+			return
+		}
+
+		// Our clock may be behind where it was before we restarted
+		state.Clock.Observe(svc)
+
+		if !state.HasServer(svc.Hostname) {
+			state.Servers[svc.Hostname] = NewServer(svc.Hostname)
+		}
+
+		server := state.Servers[svc.Hostname]
+		if server.HasService(svc.ID) {
+			return
+		}
+
+		server.Services[svc.ID] = svc
+		if !svc.IsTombstone() {
+			state.stale[staleKey(svc.Hostname, svc.ID)] = now
+			loaded++
+		}
+
+		state.ServiceChanged(svc, service.UNKNOWN, svc.Updated, CAUSE_SNAPSHOT)
+	})
+
+	log.Infof("Loaded %d services from state snapshot %s", loaded, filename)
+
+	return nil
+}
+
+// IsStale tells us if a service was loaded from the snapshot and has not
+// been confirmed since.
+// Note: Not synchronized!
+func (state *ServicesState) IsStale(svc *service.Service) bool {
+	_, ok := state.stale[staleKey(svc.Hostname, svc.ID)]
+	return ok
+}
+
+// Note: Not synchronized!
+func (state *ServicesState) clearStale(hostname string, id string) {
+	delete(state.stale, staleKey(hostname, id))
+}
+
+func staleKey(hostname string, id string) string {
+	return hostname + "/" + id
+}
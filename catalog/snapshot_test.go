@@ -0,0 +1,140 @@
+package catalog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Snapshot(t *testing.T) {
+	Convey("Working with state snapshots", t, func() {
+		dir, err := ioutil.TempDir("", "sidecar-snapshot")
+		So(err, ShouldBeNil)
+		Reset(func() { os.RemoveAll(dir) })
+
+		filename := filepath.Join(dir, "state.json")
+
+		baseTime := time.Now().UTC().Add(0 - 2*time.Minute)
+
+		svc := service.Service{
+			ID:       "deadbeef123",
+			Name:     "radical_service",
+			Image:    "101deadbeef",
+			Created:  baseTime,
+			Hostname: anotherHostname,
+			Updated:  baseTime,
+			Status:   service.ALIVE,
+		}
+
+		localSvc := svc
+		localSvc.ID = "abba123"
+		localSvc.Hostname = hostname
+
+		state := NewServicesState()
+		state.Hostname = hostname
+		state.ClusterName = "boxen"
+		state.Servers[anotherHostname] = NewServer(anotherHostname)
+		state.Servers[anotherHostname].Services[svc.ID] = &svc
+		state.Servers[hostname] = NewServer(hostname)
+		state.Servers[hostname].Services[localSvc.ID] = &localSvc
+
+		So(state.WriteSnapshot(filename), ShouldBeNil)
+
+		restarted := NewServicesState()
+		restarted.Hostname = hostname
+		restarted.ClusterName = "boxen"
+
+		Convey("LoadSnapshot() loads the services as stale", func() {
+			So(restarted.LoadSnapshot(filename), ShouldBeNil)
+
+			loaded := restarted.Servers[anotherHostname].Services[svc.ID]
+			So(loaded, ShouldNotBeNil)
+			So(loaded.Updated, ShouldBeTheSameTimeAs, baseTime)
+			So(restarted.IsStale(loaded), ShouldBeTrue)
+		})
+
+		Convey("LoadSnapshot() doesn't overwrite services we already know about", func() {
+			newer := svc
+			newer.Updated = time.Now().UTC()
+			restarted.AddServiceEntry(newer)
+
+			So(restarted.LoadSnapshot(filename), ShouldBeNil)
+
+			loaded := restarted.Servers[anotherHostname].Services[svc.ID]
+			So(loaded.Updated, ShouldBeTheSameTimeAs, newer.Updated)
+			So(restarted.IsStale(loaded), ShouldBeFalse)
+		})
+
+		Convey("LoadSnapshot() skips services older than the tombstone lifespan", func() {
+			svc.Updated = time.Now().UTC().Add(0 - TOMBSTONE_LIFESPAN - time.Minute)
+			So(state.WriteSnapshot(filename), ShouldBeNil)
+
+			So(restarted.LoadSnapshot(filename), ShouldBeNil)
+			So(restarted.HasServer(anotherHostname), ShouldBeFalse)
+		})
+
+		Convey("LoadSnapshot() is happy when there is no snapshot", func() {
+			So(restarted.LoadSnapshot(filepath.Join(dir, "missing.json")), ShouldBeNil)
+			So(restarted.Servers, ShouldBeEmpty)
+		})
+
+		Convey("LoadSnapshot() refuses a snapshot from another cluster", func() {
+			restarted.ClusterName = "other"
+
+			So(restarted.LoadSnapshot(filename), ShouldNotBeNil)
+			So(restarted.Servers, ShouldBeEmpty)
+		})
+
+		Convey("LoadSnapshot() returns an error for a corrupt snapshot", func() {
+			So(ioutil.WriteFile(filename, []byte("asdf"), 0644), ShouldBeNil)
+
+			So(restarted.LoadSnapshot(filename), ShouldNotBeNil)
+		})
+
+		Convey("Gossip about a stale service confirms it", func() {
+			So(restarted.LoadSnapshot(filename), ShouldBeNil)
+
+			confirmed := svc
+			confirmed.Updated = time.Now().UTC()
+			restarted.AddServiceEntry(confirmed)
+
+			So(restarted.IsStale(&confirmed), ShouldBeFalse)
+		})
+
+		Convey("TombstoneOthersServices()", func() {
+			So(restarted.LoadSnapshot(filename), ShouldBeNil)
+
+			Convey("gives stale services a lifespan from when they were loaded", func() {
+				result := restarted.TombstoneOthersServices()
+
+				So(result, ShouldBeEmpty)
+				So(restarted.Servers[anotherHostname].Services[svc.ID].IsAlive(), ShouldBeTrue)
+			})
+
+			Convey("expires stale services which were never confirmed", func() {
+				key := staleKey(anotherHostname, svc.ID)
+				restarted.stale[key] = time.Now().UTC().Add(0 - ALIVE_LIFESPAN - time.Second)
+
+				result := restarted.TombstoneOthersServices()
+
+				So(len(result), ShouldEqual, 1)
+				So(restarted.Servers[anotherHostname].Services[svc.ID].IsTombstone(), ShouldBeTrue)
+				So(restarted.IsStale(&svc), ShouldBeFalse)
+			})
+		})
+
+		Convey("TombstoneServices() leaves stale local services alone", func() {
+			So(restarted.LoadSnapshot(filename), ShouldBeNil)
+
+			result := restarted.TombstoneServices(hostname, []service.Service{})
+
+			So(result, ShouldBeEmpty)
+			So(restarted.Servers[hostname].Services[localSvc.ID].IsAlive(), ShouldBeTrue)
+		})
+	})
+}
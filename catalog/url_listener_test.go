@@ -70,7 +70,7 @@ func Test_Listen(t *testing.T) {
 		state := NewServicesState()
 		state.Hostname = hostname
 		state.AddServiceEntry(service1)
-		state.Servers[hostname].Services[service1.ID].Tombstone()
+		state.Servers[hostname].Services[service1.ID].Tombstone(state.Clock)
 
 		Reset(func() {
 			httpmock.DeactivateAndReset()
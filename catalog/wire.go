@@ -0,0 +1,86 @@
+package catalog
+
+import (
+	"github.com/Nitro/sidecar/service"
+	log "github.com/sirupsen/logrus"
+)
+
+// wireState is the representation of the state in the compact wire format
+// we use for push/pull anti-entropy. See service.WireFormat.
+type wireState struct {
+	ClusterName string       `codec:"c"`
+	Hostname    string       `codec:"h"`
+	LastChanged int64        `codec:"l"`
+	Servers     []wireServer `codec:"s"`
+}
+
+type wireServer struct {
+	Name        string                 `codec:"n"`
+	Services    []*service.WireService `codec:"s"`
+	LastUpdated int64                  `codec:"u"`
+	LastChanged int64                  `codec:"l"`
+}
+
+// EncodeWire encodes the state in the format we gossip with. Like Encode(),
+// it returns an empty slice on failure.
+func (state *ServicesState) EncodeWire() []byte {
+	if state.WireFormat == service.JSON_FORMAT {
+		return state.Encode()
+	}
+
+	wire := wireState{
+		ClusterName: state.ClusterName,
+		Hostname:    state.Hostname,
+		LastChanged: service.WireTime(state.LastChanged),
+		Servers:     make([]wireServer, 0, len(state.Servers)),
+	}
+
+	for _, server := range state.Servers {
+		wireSvr := wireServer{
+			Name:        server.Name,
+			Services:    make([]*service.WireService, 0, len(server.Services)),
+			LastUpdated: service.WireTime(server.LastUpdated),
+			LastChanged: service.WireTime(server.LastChanged),
+		}
+		for _, svc := range server.Services {
+			wireSvr.Services = append(wireSvr.Services, svc.ToWire())
+		}
+		wire.Servers = append(wire.Servers, wireSvr)
+	}
+
+	data, err := service.MarshalWire(&wire, state.WireFormat)
+	if err != nil {
+		log.Errorf("ERROR: Failed to encode state: %s", err)
+		return []byte{}
+	}
+
+	return data
+}
+
+// decodeWire decodes a state in the compact wire format into the state
+func (state *ServicesState) decodeWire(data []byte) error {
+	var wire wireState
+	err := service.UnmarshalWire(data, &wire)
+	if err != nil {
+		return err
+	}
+
+	state.ClusterName = wire.ClusterName
+	state.Hostname = wire.Hostname
+	state.LastChanged = service.FromWireTime(wire.LastChanged)
+
+	for _, wireSvr := range wire.Servers {
+		server := NewServer(wireSvr.Name)
+		server.LastUpdated = service.FromWireTime(wireSvr.LastUpdated)
+		server.LastChanged = service.FromWireTime(wireSvr.LastChanged)
+
+		for _, wireSvc := range wireSvr.Services {
+			svc := wireSvc.ToService()
+			server.Services[svc.ID] = svc
+		}
+
+		state.Servers[server.Name] = server
+	}
+
+	return nil
+}
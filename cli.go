@@ -8,12 +8,16 @@ import (
 )
 
 type CliOpts struct {
+	Command      string
 	AdvertiseIP  *string
 	ClusterIPs   *[]string
 	ClusterName  *string
 	CpuProfile   *bool
 	Discover     *[]string
 	LoggingLevel *string
+	KeysAddress  *string
+	KeysToken    *string
+	Key          *string
 }
 
 func exitWithError(err error, message string) {
@@ -34,8 +38,20 @@ func parseCommandLine() *CliOpts {
 	opts.Discover = app.Flag("discover", "Method of discovery").Short('d').NoEnvar().Strings()
 	opts.LoggingLevel = app.Flag("logging-level", "Set the logging level").Short('l').String()
 
-	_, err := app.Parse(os.Args[1:])
+	app.Command("run", "Run Sidecar").Default()
+
+	keys := app.Command("keys", "Manage the gossip encryption keys across the cluster")
+	opts.KeysAddress = keys.Flag("address", "The Sidecar HTTP API to talk to").Default("127.0.0.1:7777").String()
+	opts.KeysToken = keys.Flag("token", "The token needed to change the keys").Envar("SIDECAR_KEYS_TOKEN").String()
+	keys.Command("list", "List the keys installed on each node")
+	keys.Command("generate", "Generate a new key")
+	opts.Key = keys.Command("install", "Install a key on every node").Arg("key", "Base64 encoded key").Required().String()
+	keys.Command("use", "Make an installed key the primary key").Arg("key", "Base64 encoded key").Required().StringVar(opts.Key)
+	keys.Command("remove", "Remove a key from every node").Arg("key", "Base64 encoded key").Required().StringVar(opts.Key)
+
+	command, err := app.Parse(os.Args[1:])
 	exitWithError(err, "Failed to parse CLI opts")
+	opts.Command = command
 
 	return &opts
 }
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"os"
 	"time"
 
 	"github.com/kelseyhightower/envconfig"
@@ -8,6 +9,17 @@ import (
 	"gopkg.in/relistan/rubberneck.v1"
 )
 
+// A Secret is a setting that we must not print out with the rest of the
+// config at startup
+type Secret string
+
+func (s Secret) String() string {
+	if len(s) == 0 {
+		return ""
+	}
+	return "[redacted]"
+}
+
 type ListenerUrlsConfig struct {
 	Urls []string `envconfig:"URLS"`
 }
@@ -39,26 +51,50 @@ type ServicesConfig struct {
 }
 
 type SidecarConfig struct {
-	ExcludeIPs           []string      `envconfig:"EXCLUDE_IPS" default:"192.168.168.168"`
-	Discovery            []string      `envconfig:"DISCOVERY" default:"docker"`
-	StatsAddr            string        `envconfig:"STATS_ADDR"`
-	PushPullInterval     time.Duration `envconfig:"PUSH_PULL_INTERVAL" default:"20s"`
-	GossipMessages       int           `envconfig:"GOSSIP_MESSAGES" default:"15"`
-	LoggingFormat        string        `envconfig:"LOGGING_FORMAT"`
-	LoggingLevel         string        `envconfig:"LOGGING_LEVEL" default:"info"`
-	DefaultCheckEndpoint string        `envconfig:"DEFAULT_CHECK_ENDPOINT" default:"/version"`
-	Seeds                []string      `envconfig:"SEEDS"`
-	ClusterName          string        `envconfig:"CLUSTER_NAME" default:"default"`
-	AdvertiseIP          string        `envconfig:"ADVERTISE_IP"`
-	BindPort             int           `envconfig:"BIND_PORT" default:"7946"`
+	ExcludeIPs           []string          `envconfig:"EXCLUDE_IPS" default:"192.168.168.168"`
+	Discovery            []string          `envconfig:"DISCOVERY" default:"docker"`
+	StatsAddr            string            `envconfig:"STATS_ADDR"`
+	PushPullInterval     time.Duration     `envconfig:"PUSH_PULL_INTERVAL" default:"20s"`
+	GossipMessages       int               `envconfig:"GOSSIP_MESSAGES" default:"15"`
+	GossipFormat         string            `envconfig:"GOSSIP_FORMAT" default:"json"`
+	DigestSync           bool              `envconfig:"DIGEST_SYNC"`
+	Coordinates          bool              `envconfig:"COORDINATES"`
+	LoggingFormat        string            `envconfig:"LOGGING_FORMAT"`
+	LoggingLevel         string            `envconfig:"LOGGING_LEVEL" default:"info"`
+	DefaultCheckEndpoint string            `envconfig:"DEFAULT_CHECK_ENDPOINT" default:"/version"`
+	Seeds                []string          `envconfig:"SEEDS"`
+	SeedsSRV             []string          `envconfig:"SEEDS_SRV"`
+	SeedsFile            string            `envconfig:"SEEDS_FILE"`
+	RejoinInterval       time.Duration     `envconfig:"REJOIN_INTERVAL" default:"30s"`
+	ClusterName          string            `envconfig:"CLUSTER_NAME" default:"default"`
+	Hostname             string            `ignored:"true"` // From SIDECAR_HOSTNAME, see ParseConfig()
+	NodeIDFile           string            `envconfig:"NODE_ID_FILE"`
+	Region               string            `envconfig:"REGION"`
+	Zone                 string            `envconfig:"ZONE"`
+	Rack                 string            `envconfig:"RACK"`
+	Roles                []string          `envconfig:"ROLES"`
+	Labels               map[string]string `envconfig:"LABELS"`
+	AdvertiseIP          string            `envconfig:"ADVERTISE_IP"`
+	BindPort             int               `envconfig:"BIND_PORT" default:"7946"`
+	SnapshotFile         string            `envconfig:"SNAPSHOT_FILE"`
+	DrainGracePeriod     time.Duration     `envconfig:"DRAIN_GRACE_PERIOD"`
+	JournalSize          int               `envconfig:"JOURNAL_SIZE" default:"2000"`
+	JournalFile          string            `envconfig:"JOURNAL_FILE"`
+	GossipKey            Secret            `envconfig:"GOSSIP_KEY"`
+	KeyringFile          string            `envconfig:"KEYRING_FILE"`
+	KeysToken            Secret            `envconfig:"KEYS_TOKEN"`
+	GossipVerifyIncoming bool              `envconfig:"GOSSIP_VERIFY_INCOMING" default:"true"`
+	GossipVerifyOutgoing bool              `envconfig:"GOSSIP_VERIFY_OUTGOING" default:"true"`
 }
 
 type DockerConfig struct {
-	DockerURL string `envconfig:"URL" default:"unix:///var/run/docker.sock"`
+	DockerURL      string `envconfig:"URL" default:"unix:///var/run/docker.sock"`
+	TagLabelPrefix string `envconfig:"TAG_LABEL_PREFIX" default:"SidecarTag_"`
 }
 
 type StaticConfig struct {
 	ConfigFile string `envconfig:"CONFIG_FILE" default:"static.json"`
+	ConfigDir  string `envconfig:"CONFIG_DIR"`
 }
 
 type Config struct {
@@ -91,5 +127,9 @@ func ParseConfig() *Config {
 		}
 	}
 
+	// envconfig falls back to the unprefixed HOSTNAME, which Docker sets in
+	// every container, so we only look at our own
+	config.Sidecar.Hostname = os.Getenv("SIDECAR_HOSTNAME")
+
 	return &config
 }
@@ -0,0 +1,254 @@
+package discovery
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/relistan/go-director"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/Nitro/sidecar/service"
+)
+
+// An ApiDiscovery is a Discoverer for services which register and deregister
+// themselves at runtime over the Sidecar HTTP API. This allows services that
+// don't run in containers, e.g. on VMs or under systemd, to announce
+// themselves. Registrations with a TTL expire unless they are refreshed by
+// registering again before it runs out.
+type ApiDiscovery struct {
+	Hostname      string
+	NodeName      string // The node we announce our services for
+	DefaultIP     string
+	registrations map[string]*apiRegistration // Registered services, by ID
+	sleepInterval time.Duration               // How often we look for expired registrations
+	sync.RWMutex
+}
+
+// An ApiRegistration is the request a service sends to register itself. It
+// looks like a static discovery Target with an optional TTL.
+type ApiRegistration struct {
+	Service    service.Service
+	Check      StaticCheck
+	ListenPort int64
+	TTL        string // e.g. "30s", registrations without one never expire
+}
+
+type apiRegistration struct {
+	target  *Target
+	expires time.Time // Zero when the registration has no TTL
+}
+
+func (r *apiRegistration) isExpired(now time.Time) bool {
+	return !r.expires.IsZero() && now.After(r.expires)
+}
+
+func NewApiDiscovery(defaultIP string) *ApiDiscovery {
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Errorf("Error getting hostname! %s", err.Error())
+	}
+	return &ApiDiscovery{
+		Hostname:      hostname,
+		NodeName:      hostname,
+		DefaultIP:     defaultIP,
+		registrations: make(map[string]*apiRegistration),
+		sleepInterval: DefaultSleepInterval,
+	}
+}
+
+// Register adds a service, or refreshes it if it is already registered,
+// and returns the service as it will be announced. A changed Check replaces
+// the old one, and the health monitor picks it up on its next pass.
+func (d *ApiDiscovery) Register(id string, reg *ApiRegistration) (*service.Service, error) {
+	if id == "" {
+		return nil, fmt.Errorf("service ID is required")
+	}
+
+	if reg.Service.ID != "" && reg.Service.ID != id {
+		return nil, fmt.Errorf("service ID %q does not match %q", reg.Service.ID, id)
+	}
+
+	if reg.Service.Name == "" {
+		return nil, fmt.Errorf("service Name is required")
+	}
+
+	var ttl time.Duration
+	if reg.TTL != "" {
+		var err error
+		ttl, err = time.ParseDuration(reg.TTL)
+		if err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("invalid TTL %q", reg.TTL)
+		}
+	}
+
+	target := &Target{
+		Service:    reg.Service,
+		Check:      reg.Check,
+		ListenPort: reg.ListenPort,
+		Source:     "api",
+	}
+	target.Service.ID = id
+
+	// Services can be registered for a 3rd party. If we don't get
+	// a hostname, then it's for this host.
+	if target.Service.Hostname == "" {
+		target.Service.Hostname = d.NodeName
+	}
+
+	// Make sure we have an IP address on ports
+	for i, port := range target.Service.Ports {
+		if len(port.IP) == 0 {
+			target.Service.Ports[i].IP = d.DefaultIP
+		}
+	}
+
+	now := time.Now().UTC()
+	target.Service.Updated = now
+
+	d.Lock()
+	defer d.Unlock()
+
+	if existing, ok := d.registrations[id]; ok {
+		target.Service.Created = existing.target.Service.Created
+	} else {
+		target.Service.Created = now
+		log.Printf("Registered service: %s, ID: %s", target.Service.Name, id)
+	}
+
+	registration := &apiRegistration{target: target}
+	if ttl > 0 {
+		registration.expires = now.Add(ttl)
+	}
+	d.registrations[id] = registration
+
+	svc := target.Service
+	return &svc, nil
+}
+
+// Deregister removes a service. It returns an error if the service was not
+// registered.
+func (d *ApiDiscovery) Deregister(id string) error {
+	d.Lock()
+	defer d.Unlock()
+
+	registration, ok := d.registrations[id]
+	if !ok {
+		return fmt.Errorf("service %q is not registered", id)
+	}
+
+	delete(d.registrations, id)
+	log.Printf("Deregistered service: %s, ID: %s", registration.target.Service.Name, id)
+
+	return nil
+}
+
+func (d *ApiDiscovery) HealthCheck(svc *service.Service) (string, string) {
+	target := d.findTarget(svc.ID)
+	if target == nil {
+		return "", ""
+	}
+	return target.Check.Type, target.Check.Args
+}
+
+// HealthCheckOptions returns the scheduling settings from the Check block
+// of the registration.
+func (d *ApiDiscovery) HealthCheckOptions(svc *service.Service) HealthCheckOptions {
+	target := d.findTarget(svc.ID)
+	if target == nil {
+		return HealthCheckOptions{}
+	}
+
+	return HealthCheckOptions{
+		Interval: parseCheckDuration(target.Check.Interval, "Interval", svc.ID),
+		Timeout:  parseCheckDuration(target.Check.Timeout, "Timeout", svc.ID),
+		Rise:     target.Check.Rise,
+		Fall:     target.Check.Fall,
+	}.clampTimeout(svc.ID)
+}
+
+// Services returns the registered services which have not expired, sorted
+// by ID.
+func (d *ApiDiscovery) Services() []service.Service {
+	var services []service.Service
+	for _, target := range d.liveTargets() {
+		svc := target.Service
+		svc.Updated = time.Now().UTC()
+		services = append(services, svc)
+	}
+	return services
+}
+
+// Listeners returns the registered services which want to receive Sidecar
+// change events
+func (d *ApiDiscovery) Listeners() []ChangeListener {
+	var listeners []ChangeListener
+	for _, target := range d.liveTargets() {
+		if target.ListenPort > 0 {
+			listener := ChangeListener{
+				Name: target.Service.ListenerName(),
+				Url:  fmt.Sprintf("http://%s:%d/sidecar/update", d.Hostname, target.ListenPort),
+			}
+			listeners = append(listeners, listener)
+		}
+	}
+	return listeners
+}
+
+// Run removes expired registrations in the background
+func (d *ApiDiscovery) Run(looper director.Looper) {
+	go looper.Loop(func() error {
+		time.Sleep(d.sleepInterval)
+		d.expireRegistrations()
+		return nil
+	})
+}
+
+func (d *ApiDiscovery) expireRegistrations() {
+	d.Lock()
+	defer d.Unlock()
+
+	now := time.Now().UTC()
+	for id, registration := range d.registrations {
+		if registration.isExpired(now) {
+			log.Warnf("Registration for service %s, ID: %s expired",
+				registration.target.Service.Name, id,
+			)
+			delete(d.registrations, id)
+		}
+	}
+}
+
+// liveTargets returns the targets for the registrations which have not
+// expired, sorted by service ID
+func (d *ApiDiscovery) liveTargets() []*Target {
+	d.RLock()
+	defer d.RUnlock()
+
+	now := time.Now().UTC()
+	targets := make([]*Target, 0, len(d.registrations))
+	for _, registration := range d.registrations {
+		if !registration.isExpired(now) {
+			targets = append(targets, registration.target)
+		}
+	}
+
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].Service.ID < targets[j].Service.ID
+	})
+
+	return targets
+}
+
+func (d *ApiDiscovery) findTarget(id string) *Target {
+	d.RLock()
+	defer d.RUnlock()
+
+	registration, ok := d.registrations[id]
+	if !ok {
+		return nil
+	}
+	return registration.target
+}
@@ -0,0 +1,127 @@
+package discovery
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ApiDiscovery(t *testing.T) {
+	Convey("ApiDiscovery", t, func() {
+		disco := NewApiDiscovery("127.0.0.1")
+		disco.NodeName = hostname
+
+		registration := &ApiRegistration{
+			Service: service.Service{
+				Name:  "beowulf",
+				Ports: []service.Port{{Type: "tcp", Port: 10234, ServicePort: 9999}},
+			},
+			Check: StaticCheck{
+				Type:     "HttpGet",
+				Args:     "http://:10234/",
+				Interval: "30s",
+				Rise:     2,
+			},
+			ListenPort: 10234,
+		}
+
+		Convey("Registers services", func() {
+			svc, err := disco.Register("deadbeef123", registration)
+			So(err, ShouldBeNil)
+			So(svc.ID, ShouldEqual, "deadbeef123")
+			So(svc.Hostname, ShouldEqual, hostname)
+			So(svc.Ports[0].IP, ShouldEqual, "127.0.0.1")
+
+			services := disco.Services()
+			So(len(services), ShouldEqual, 1)
+			So(services[0].Name, ShouldEqual, "beowulf")
+		})
+
+		Convey("Returns the check for registered services", func() {
+			svc, _ := disco.Register("deadbeef123", registration)
+
+			checkType, checkArgs := disco.HealthCheck(svc)
+			So(checkType, ShouldEqual, "HttpGet")
+			So(checkArgs, ShouldEqual, "http://:10234/")
+
+			opts := disco.HealthCheckOptions(svc)
+			So(opts.Interval, ShouldEqual, 30*time.Second)
+			So(opts.Rise, ShouldEqual, 2)
+
+			checkType, _ = disco.HealthCheck(&service.Service{ID: "missing"})
+			So(checkType, ShouldEqual, "")
+		})
+
+		Convey("Clamps a check timeout longer than the interval", func() {
+			registration.Check.Timeout = "1m"
+			svc, _ := disco.Register("deadbeef123", registration)
+
+			opts := disco.HealthCheckOptions(svc)
+			So(opts.Timeout, ShouldEqual, 30*time.Second)
+		})
+
+		Convey("Returns listeners for registered services", func() {
+			disco.Register("deadbeef123", registration)
+
+			listeners := disco.Listeners()
+			So(len(listeners), ShouldEqual, 1)
+			So(listeners[0].Name, ShouldEqual, "Service(beowulf-deadbeef123)")
+		})
+
+		Convey("Keeps the creation time when refreshing a registration", func() {
+			first, _ := disco.Register("deadbeef123", registration)
+			time.Sleep(1 * time.Millisecond)
+			second, _ := disco.Register("deadbeef123", registration)
+
+			So(second.Created, ShouldResemble, first.Created)
+			So(second.Updated.After(first.Updated), ShouldBeTrue)
+		})
+
+		Convey("Rejects invalid registrations", func() {
+			_, err := disco.Register("", registration)
+			So(err, ShouldNotBeNil)
+
+			registration.Service.ID = "other"
+			_, err = disco.Register("deadbeef123", registration)
+			So(err.Error(), ShouldContainSubstring, "does not match")
+
+			registration.Service.ID = ""
+			registration.TTL = "junk"
+			_, err = disco.Register("deadbeef123", registration)
+			So(err.Error(), ShouldContainSubstring, "invalid TTL")
+
+			registration.TTL = ""
+			registration.Service.Name = ""
+			_, err = disco.Register("deadbeef123", registration)
+			So(err.Error(), ShouldContainSubstring, "Name is required")
+
+			So(disco.Services(), ShouldBeEmpty)
+		})
+
+		Convey("Deregisters services", func() {
+			disco.Register("deadbeef123", registration)
+
+			So(disco.Deregister("deadbeef123"), ShouldBeNil)
+			So(disco.Services(), ShouldBeEmpty)
+			So(disco.Deregister("deadbeef123"), ShouldNotBeNil)
+		})
+
+		Convey("Expires registrations that are not refreshed", func() {
+			registration.TTL = "1ms"
+			disco.Register("deadbeef123", registration)
+			registration.TTL = ""
+			disco.Register("deadbeef456", registration)
+
+			time.Sleep(5 * time.Millisecond)
+
+			services := disco.Services()
+			So(len(services), ShouldEqual, 1)
+			So(services[0].ID, ShouldEqual, "deadbeef456")
+
+			disco.expireRegistrations()
+			So(disco.Deregister("deadbeef123"), ShouldNotBeNil)
+		})
+	})
+}
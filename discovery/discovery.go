@@ -1,10 +1,14 @@
 package discovery
 
 import (
+	"fmt"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/Nitro/sidecar/service"
 	"github.com/relistan/go-director"
+	log "github.com/sirupsen/logrus"
 )
 
 const (
@@ -18,6 +22,22 @@ type ChangeListener struct {
 	Url  string // Url of the service to send events to
 }
 
+// HealthCheckOptions are the optional scheduling settings for a service's
+// health check. Zero values mean that the Monitor's defaults are used.
+type HealthCheckOptions struct {
+	Interval time.Duration // How often to run the check
+	Timeout  time.Duration // How long to wait for the check to complete
+	Rise     int           // Consecutive successes before a failed check is healthy
+	Fall     int           // Consecutive failures before a check is marked failed
+}
+
+// A HealthCheckOptioner is a Discoverer which can also supply per-service
+// health check scheduling settings. It is optional so that existing
+// Discoverers don't have to implement it.
+type HealthCheckOptioner interface {
+	HealthCheckOptions(svc *service.Service) HealthCheckOptions
+}
+
 // A Discoverer is responsible for finding services that we care
 // about. It must have a method to return the list of services, and
 // a Run() method that will be invoked when the discovery mechanism(s)
@@ -53,6 +73,23 @@ func (d *MultiDiscovery) HealthCheck(svc *service.Service) (string, string) {
 	return "", ""
 }
 
+// HealthCheckOptions returns the check options from the same discoverer that
+// supplied the health check for this service.
+func (d *MultiDiscovery) HealthCheckOptions(svc *service.Service) HealthCheckOptions {
+	for _, disco := range d.Discoverers {
+		if healthCheck, _ := disco.HealthCheck(svc); healthCheck == "" {
+			continue
+		}
+
+		if optioner, ok := disco.(HealthCheckOptioner); ok {
+			return optioner.HealthCheckOptions(svc)
+		}
+
+		break
+	}
+	return HealthCheckOptions{}
+}
+
 // Aggregates all the service slices from the discoverers
 func (d *MultiDiscovery) Services() []service.Service {
 	var aggregate []service.Service
@@ -100,3 +137,62 @@ func (d *MultiDiscovery) Run(looper director.Looper) {
 		l.Quit()
 	}
 }
+
+// clampTimeout cuts a timeout that is longer than the interval down to the
+// interval, since the check would be due again before it timed out
+func (o HealthCheckOptions) clampTimeout(svcID string) HealthCheckOptions {
+	if o.Interval > 0 && o.Timeout > o.Interval {
+		warnCheckSetting("Health check timeout %s is longer than the interval %s for service %s, using the interval",
+			o.Timeout, o.Interval, svcID,
+		)
+		o.Timeout = o.Interval
+	}
+
+	return o
+}
+
+// checkWarnings holds the health check setting warnings we have already
+// logged. The health monitor asks for the settings on every pass, so we'd
+// otherwise repeat them a couple of times a second.
+var checkWarnings sync.Map
+
+// warnCheckSetting logs a warning about a health check setting, but only
+// the first time we see it.
+func warnCheckSetting(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	if _, seen := checkWarnings.LoadOrStore(message, true); !seen {
+		log.Warn(message)
+	}
+}
+
+// parseCheckDuration parses a duration setting for a health check. Empty or
+// invalid values return zero so that the default is used.
+func parseCheckDuration(value string, setting string, svcID string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		warnCheckSetting("Invalid %s '%s' for service %s, using default", setting, value, svcID)
+		return 0
+	}
+
+	return duration
+}
+
+// parseCheckCount parses a count setting for a health check. Empty or
+// invalid values return zero so that the default is used.
+func parseCheckCount(value string, setting string, svcID string) int {
+	if value == "" {
+		return 0
+	}
+
+	count, err := strconv.Atoi(value)
+	if err != nil || count < 0 {
+		warnCheckSetting("Invalid %s '%s' for service %s, using default", setting, value, svcID)
+		return 0
+	}
+
+	return count
+}
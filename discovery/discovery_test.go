@@ -2,6 +2,7 @@ package discovery
 
 import (
 	"testing"
+	"time"
 
 	"github.com/Nitro/sidecar/service"
 	"github.com/relistan/go-director"
@@ -115,5 +116,19 @@ func Test_MultiDiscovery(t *testing.T) {
 			So(check, ShouldEqual, "")
 			So(args, ShouldEqual, "")
 		})
+
+		Convey("HealthCheckOptions() uses the discoverer that supplied the check", func() {
+			static := NewStaticDiscovery(STATIC_JSON, "127.0.0.1")
+			static.Targets = []*Target{{
+				Service: service.Service{Name: "svc3", ID: "3"},
+				Check:   StaticCheck{Type: "HttpGet", Interval: "30s"},
+			}}
+			multi.Discoverers = append(multi.Discoverers, static)
+
+			opts := multi.HealthCheckOptions(&static.Targets[0].Service)
+			So(opts.Interval, ShouldEqual, 30*time.Second)
+
+			So(multi.HealthCheckOptions(&svc1), ShouldResemble, HealthCheckOptions{})
+		})
 	})
 }
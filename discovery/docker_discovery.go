@@ -2,7 +2,9 @@ package discovery
 
 import (
 	"fmt"
+	"os"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,7 +16,8 @@ import (
 )
 
 const (
-	CacheDrainInterval = 10 * time.Minute // Drain the cache every 10 mins
+	CacheDrainInterval    = 10 * time.Minute // Drain the cache every 10 mins
+	DefaultTagLabelPrefix = "SidecarTag_"    // e.g. SidecarTag_zone=us-east-1a
 )
 
 type DockerClient interface {
@@ -34,6 +37,8 @@ type DockerDiscovery struct {
 	advertiseIp    string                       // The address we'll advertise for services
 	containerCache *ContainerCache              // Stores full container data for fast lookups
 	sleepInterval  time.Duration                // The sleep interval for event processing and reconnection
+	TagLabelPrefix string                       // Labels with this prefix become service tags
+	NodeName       string                       // The node we announce our services for
 	sync.RWMutex                                // Reader/Writer lock
 }
 
@@ -45,8 +50,15 @@ func NewDockerDiscovery(endpoint string, svcNamer ServiceNamer, ip string) *Dock
 		serviceNamer:   svcNamer,
 		advertiseIp:    ip,
 		sleepInterval:  DefaultSleepInterval,
+		TagLabelPrefix: DefaultTagLabelPrefix,
 	}
 
+	hostname, err := os.Hostname()
+	if err != nil {
+		log.Errorf("Error getting hostname! %s", err.Error())
+	}
+	discovery.NodeName = hostname
+
 	// Default to our own method for returning this
 	discovery.ClientProvider = discovery.getDockerClient
 
@@ -81,6 +93,25 @@ func (d *DockerDiscovery) HealthCheck(svc *service.Service) (string, string) {
 	return container.Config.Labels["HealthCheck"], container.Config.Labels["HealthCheckArgs"]
 }
 
+// HealthCheckOptions looks up the optional health check scheduling settings
+// from the HealthCheckInterval, HealthCheckTimeout, HealthCheckRise and
+// HealthCheckFall container labels.
+func (d *DockerDiscovery) HealthCheckOptions(svc *service.Service) HealthCheckOptions {
+	container, err := d.inspectContainer(svc)
+	if err != nil {
+		return HealthCheckOptions{}
+	}
+
+	labels := container.Config.Labels
+
+	return HealthCheckOptions{
+		Interval: parseCheckDuration(labels["HealthCheckInterval"], "HealthCheckInterval", svc.ID),
+		Timeout:  parseCheckDuration(labels["HealthCheckTimeout"], "HealthCheckTimeout", svc.ID),
+		Rise:     parseCheckCount(labels["HealthCheckRise"], "HealthCheckRise", svc.ID),
+		Fall:     parseCheckCount(labels["HealthCheckFall"], "HealthCheckFall", svc.ID),
+	}.clampTimeout(svc.ID)
+}
+
 func (d *DockerDiscovery) inspectContainer(svc *service.Service) (*docker.Container, error) {
 	// If we have it cached, return it!
 	container := d.containerCache.Get(svc.ID)
@@ -271,8 +302,9 @@ func (d *DockerDiscovery) getContainers() {
 			continue
 		}
 
-		svc := service.ToService(&container, d.advertiseIp)
+		svc := service.ToService(&container, d.advertiseIp, d.NodeName)
 		svc.Name = d.serviceNamer.ServiceName(&container)
+		svc.Tags = tagsFromLabels(container.Labels, d.TagLabelPrefix)
 		d.services = append(d.services, &svc)
 		containerMap[svc.ID] = true
 	}
@@ -280,6 +312,28 @@ func (d *DockerDiscovery) getContainers() {
 	d.containerCache.Prune(containerMap)
 }
 
+// tagsFromLabels returns the labels that start with the prefix as service
+// tags, with the prefix removed
+func tagsFromLabels(labels map[string]string, prefix string) map[string]string {
+	if prefix == "" {
+		return nil
+	}
+
+	var tags map[string]string
+	for label, value := range labels {
+		if !strings.HasPrefix(label, prefix) || len(label) == len(prefix) {
+			continue
+		}
+
+		if tags == nil {
+			tags = make(map[string]string)
+		}
+		tags[strings.TrimPrefix(label, prefix)] = value
+	}
+
+	return tags
+}
+
 func (d *DockerDiscovery) configureDockerConnection() DockerClient {
 	client, err := d.ClientProvider()
 	if err != nil {
@@ -30,10 +30,14 @@ func (s *stubDockerClient) InspectContainer(id string) (*docker.Container, error
 			ID: "deadbeef1231",
 			Config: &docker.Config{
 				Labels: map[string]string{
-					"HealthCheck":     "HttpGet",
-					"HealthCheckArgs": "service1 check arguments",
-					"ServicePort_80":  "10000",
-					"SidecarListener": "10000",
+					"HealthCheck":         "HttpGet",
+					"HealthCheckArgs":     "service1 check arguments",
+					"HealthCheckInterval": "30s",
+					"HealthCheckTimeout":  "10s",
+					"HealthCheckRise":     "2",
+					"HealthCheckFall":     "junk",
+					"ServicePort_80":      "10000",
+					"SidecarListener":     "10000",
 				},
 			},
 		}, nil
@@ -172,6 +176,25 @@ func Test_DockerDiscovery(t *testing.T) {
 			})
 		})
 
+		Convey("HealthCheckOptions()", func() {
+			Convey("returns the options from the labels", func() {
+				opts := disco.HealthCheckOptions(&service1)
+				So(opts.Interval, ShouldEqual, 30*time.Second)
+				So(opts.Timeout, ShouldEqual, 10*time.Second)
+				So(opts.Rise, ShouldEqual, 2)
+			})
+
+			Convey("uses the default for invalid labels", func() {
+				opts := disco.HealthCheckOptions(&service1)
+				So(opts.Fall, ShouldEqual, 0)
+			})
+
+			Convey("returns empty options when undefined", func() {
+				opts := disco.HealthCheckOptions(&service2)
+				So(opts, ShouldResemble, HealthCheckOptions{})
+			})
+		})
+
 		Convey("inspectContainer()", func() {
 			Convey("looks in the cache first", func() {
 				disco.containerCache.Set(&service1, &docker.Container{Path: "cached"})
@@ -261,5 +284,30 @@ func Test_DockerDiscovery(t *testing.T) {
 				}
 			})
 		})
+
+		Convey("tagsFromLabels()", func() {
+			labels := map[string]string{
+				"SidecarTag_zone": "us-east-1a",
+				"SidecarTag_team": "octopus",
+				"SidecarTag_":     "junk",
+				"ServiceName":     "beowulf",
+			}
+
+			Convey("returns the labels with the prefix as tags", func() {
+				tags := tagsFromLabels(labels, DefaultTagLabelPrefix)
+				So(tags, ShouldResemble, map[string]string{
+					"zone": "us-east-1a",
+					"team": "octopus",
+				})
+			})
+
+			Convey("returns nil when there are no tags", func() {
+				So(tagsFromLabels(map[string]string{"ServiceName": "beowulf"}, DefaultTagLabelPrefix), ShouldBeNil)
+			})
+
+			Convey("returns nil when the prefix is empty", func() {
+				So(tagsFromLabels(labels, ""), ShouldBeNil)
+			})
+		})
 	})
 }
@@ -2,15 +2,22 @@ package discovery
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
+	metrics "github.com/armon/go-metrics"
 	"github.com/relistan/go-director"
 	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
 
 	"github.com/Nitro/sidecar/service"
 )
@@ -19,21 +26,42 @@ type Target struct {
 	Service    service.Service
 	Check      StaticCheck
 	ListenPort int64
+	Source     string `json:"-"` // The config file this target came from
+	generated  bool   // The ID came from stableID() rather than the config
 }
 
 // A StaticDiscovery is an instance of a configuration file based discovery
-// mechanism. It is read on startup and never again, currently, so there
-// is no need for any locking or synchronization mechanism.
+// mechanism. It reads either a single ConfigFile or, when ConfigDir is set,
+// every JSON and YAML file in that directory. The files are read on startup
+// and then watched for changes, so access to the Targets must be synchronized
+// with the RWMutex.
 type StaticDiscovery struct {
-	Targets    []*Target
-	ConfigFile string
-	Hostname   string
-	DefaultIP  string
+	Targets       []*Target
+	ConfigFile    string
+	ConfigDir     string
+	Hostname      string
+	NodeName      string // The node we announce our services for
+	DefaultIP     string
+	sleepInterval time.Duration            // How often we check the files for changes
+	sources       map[string]*configSource // The files we've read, by filename
+	sync.RWMutex
+}
+
+// A configSource tracks one config file and the targets we last read from it
+type configSource struct {
+	modified time.Time
+	size     int64
+	targets  []*Target
+	err      error
 }
 
 type StaticCheck struct {
-	Type string
-	Args string
+	Type     string
+	Args     string
+	Interval string // e.g. "30s"
+	Timeout  string // e.g. "10s"
+	Rise     int
+	Fall     int
 }
 
 func NewStaticDiscovery(filename string, defaultIP string) *StaticDiscovery {
@@ -42,13 +70,19 @@ func NewStaticDiscovery(filename string, defaultIP string) *StaticDiscovery {
 		log.Errorf("Error getting hostname! %s", err.Error())
 	}
 	return &StaticDiscovery{
-		ConfigFile: filename,
-		Hostname:   hostname,
-		DefaultIP:  defaultIP,
+		ConfigFile:    filename,
+		Hostname:      hostname,
+		NodeName:      hostname,
+		DefaultIP:     defaultIP,
+		sleepInterval: DefaultSleepInterval,
+		sources:       make(map[string]*configSource),
 	}
 }
 
 func (d *StaticDiscovery) HealthCheck(svc *service.Service) (string, string) {
+	d.RLock()
+	defer d.RUnlock()
+
 	for _, target := range d.Targets {
 		if svc.ID == target.Service.ID {
 			return target.Check.Type, target.Check.Args
@@ -57,9 +91,31 @@ func (d *StaticDiscovery) HealthCheck(svc *service.Service) (string, string) {
 	return "", ""
 }
 
+// HealthCheckOptions returns the scheduling settings from the Check block
+// of the matching target.
+func (d *StaticDiscovery) HealthCheckOptions(svc *service.Service) HealthCheckOptions {
+	d.RLock()
+	defer d.RUnlock()
+
+	for _, target := range d.Targets {
+		if svc.ID == target.Service.ID {
+			return HealthCheckOptions{
+				Interval: parseCheckDuration(target.Check.Interval, "Interval", svc.ID),
+				Timeout:  parseCheckDuration(target.Check.Timeout, "Timeout", svc.ID),
+				Rise:     target.Check.Rise,
+				Fall:     target.Check.Fall,
+			}.clampTimeout(svc.ID)
+		}
+	}
+	return HealthCheckOptions{}
+}
+
 // Returns the list of services derived from the targets that were parsed
 // out of the config file.
 func (d *StaticDiscovery) Services() []service.Service {
+	d.Lock()
+	defer d.Unlock()
+
 	var services []service.Service
 	for _, target := range d.Targets {
 		target.Service.Updated = time.Now().UTC()
@@ -70,6 +126,9 @@ func (d *StaticDiscovery) Services() []service.Service {
 
 // Listeners returns the list of services configured to be ChangeEvent listeners
 func (d *StaticDiscovery) Listeners() []ChangeListener {
+	d.RLock()
+	defer d.RUnlock()
+
 	var listeners []ChangeListener
 	for _, target := range d.Targets {
 		if target.ListenPort > 0 {
@@ -83,23 +142,218 @@ func (d *StaticDiscovery) Listeners() []ChangeListener {
 	return listeners
 }
 
-// Causes the configuration to be parsed and loaded. There is no background
-// processing needed on an ongoing basis.
+// Causes the configuration to be parsed and loaded, then watches the file
+// in the background and reloads it whenever it changes.
 func (d *StaticDiscovery) Run(looper director.Looper) {
-	var err error
+	d.reloadIfChanged()
 
-	d.Targets, err = d.ParseConfig(d.ConfigFile)
+	go looper.Loop(func() error {
+		time.Sleep(d.sleepInterval)
+		d.reloadIfChanged()
+		return nil
+	})
+}
+
+// reloadIfChanged parses any config files that were added or modified since
+// we last read them. When a file can't be parsed, we log it and keep the
+// last good config from that file. Files removed from the ConfigDir take
+// their targets with them.
+func (d *StaticDiscovery) reloadIfChanged() {
+	d.Lock()
+	defer d.Unlock()
+
+	filenames, err := d.configFiles()
 	if err != nil {
-		log.Errorf("StaticDiscovery cannot parse: %s", err.Error())
-		looper.Done(nil)
+		log.Errorf("StaticDiscovery cannot list config files: %s", err.Error())
+		return
+	}
+
+	changed := false
+	found := make(map[string]bool, len(filenames))
+	for _, filename := range filenames {
+		found[filename] = true
+
+		source, ok := d.sources[filename]
+		if !ok {
+			source = &configSource{}
+			d.sources[filename] = source
+		}
+
+		info, err := os.Stat(filename)
+		if err != nil {
+			source.setError(filename, err)
+			continue
+		}
+
+		if info.ModTime().Equal(source.modified) && info.Size() == source.size {
+			continue
+		}
+		source.modified = info.ModTime()
+		source.size = info.Size()
+
+		targets, err := d.ParseConfig(filename)
+		if err != nil {
+			source.setError(filename, err)
+			continue
+		}
+
+		source.err = nil
+		source.targets = targets
+		changed = true
+	}
+
+	for filename := range d.sources {
+		if !found[filename] {
+			log.Printf("Config file %s was removed", filename)
+			delete(d.sources, filename)
+			changed = true
+		}
 	}
+
+	if changed {
+		d.Targets = d.mergeTargets(d.allTargets())
+	}
+
+	metrics.SetGauge([]string{"static_discovery", "configErrors"}, float32(len(d.configErrors())))
+}
+
+// configFiles returns the sorted list of files we read targets from
+func (d *StaticDiscovery) configFiles() ([]string, error) {
+	if d.ConfigDir == "" {
+		return []string{d.ConfigFile}, nil
+	}
+
+	var filenames []string
+	for _, pattern := range []string{"*.json", "*.yaml", "*.yml"} {
+		matches, err := filepath.Glob(filepath.Join(d.ConfigDir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		filenames = append(filenames, matches...)
+	}
+	sort.Strings(filenames)
+
+	return filenames, nil
+}
+
+// allTargets returns the targets from all the config files, warning about
+// any IDs that are used in more than one of them. Generated IDs are counted
+// across all the files, in filename order, so identical targets in different
+// files still get distinct IDs.
+// Note: Not synchronized!
+func (d *StaticDiscovery) allTargets() []*Target {
+	filenames := make([]string, 0, len(d.sources))
+	for filename := range d.sources {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+
+	var targets []*Target
+	generated := make(map[string]int)
+	seenIDs := make(map[string]string)
+	for _, filename := range filenames {
+		for _, target := range d.sources[filename].targets {
+			if target.generated {
+				target.assignID(generated)
+			}
+
+			if other, ok := seenIDs[target.Service.ID]; ok && other != filename {
+				log.Warnf("Service ID %s in %s is also used in %s",
+					target.Service.ID, filename, other,
+				)
+			}
+			seenIDs[target.Service.ID] = filename
+			targets = append(targets, target)
+		}
+	}
+
+	return targets
+}
+
+// ConfigErrors returns the errors from the config files that we could not
+// read or parse the last time we tried, by filename.
+func (d *StaticDiscovery) ConfigErrors() map[string]error {
+	d.RLock()
+	defer d.RUnlock()
+
+	return d.configErrors()
+}
+
+// configErrors returns the errors from the config files, by filename
+// Note: Not synchronized!
+func (d *StaticDiscovery) configErrors() map[string]error {
+	result := make(map[string]error)
+	for filename, source := range d.sources {
+		if source.err != nil {
+			result[filename] = source.err
+		}
+	}
+
+	return result
 }
 
-// Parses a JSON config file containing an array of Targets. These are
-// then augmented with a random hex ID and stamped with the current
-// UTC time as the creation time. The same hex ID is applied to the Check
-// and the Service to make sure that they are matched by the healthy
-// package later on.
+// setError records an error for this source, logging it only when it
+// changes so that we don't log the same error on every pass.
+func (s *configSource) setError(filename string, err error) {
+	if s.err == nil || s.err.Error() != err.Error() {
+		log.Errorf("StaticDiscovery cannot load %s, keeping last good config: %s",
+			filename, err.Error(),
+		)
+	}
+	s.err = err
+}
+
+// mergeTargets compares newly parsed targets against the ones we already
+// have. Targets which are unchanged keep their creation time, and we log
+// the targets that were added or removed.
+// Note: Not synchronized!
+func (d *StaticDiscovery) mergeTargets(targets []*Target) []*Target {
+	existing := make(map[string][]*Target, len(d.Targets))
+	for _, target := range d.Targets {
+		key := target.key()
+		existing[key] = append(existing[key], target)
+	}
+
+	for _, target := range targets {
+		key := target.key()
+		if matches := existing[key]; len(matches) > 0 {
+			target.Service.Created = matches[0].Service.Created
+			existing[key] = matches[1:]
+			continue
+		}
+
+		log.Printf("Discovered service: %s, ID: %s",
+			target.Service.Name,
+			target.Service.ID,
+		)
+	}
+
+	for _, removed := range existing {
+		for _, target := range removed {
+			log.Printf("Removed service: %s, ID: %s",
+				target.Service.Name,
+				target.Service.ID,
+			)
+		}
+	}
+
+	return targets
+}
+
+// key identifies a target by its ID and configuration, leaving out the
+// creation time. Two targets with the same key are considered the same target.
+func (t *Target) key() string {
+	return fmt.Sprintf("%s|%s|%s|%s|%s|%v|%+v|%d",
+		t.Service.ID, t.Service.Hostname, t.Service.Name, t.Service.Image,
+		t.Service.ProxyMode, t.Service.Ports, t.Check, t.ListenPort,
+	)
+}
+
+// Parses a JSON or YAML config file containing an array of Targets. These are
+// then augmented with a stable hex ID, unless the config sets one, and
+// stamped with the current UTC time as the creation time. The same hex ID
+// is applied to the Check and the Service to make sure that they are
+// matched by the healthy package later on.
 func (d *StaticDiscovery) ParseConfig(filename string) ([]*Target, error) {
 	file, err := ioutil.ReadFile(filename)
 	if err != nil {
@@ -107,27 +361,45 @@ func (d *StaticDiscovery) ParseConfig(filename string) ([]*Target, error) {
 		return nil, err
 	}
 
+	if isYAML(filename) {
+		file, err = yamlToJSON(file)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse YAML: %s", err)
+		}
+	}
+
 	var targets []*Target
 	err = json.Unmarshal(file, &targets)
 	if err != nil {
 		return nil, fmt.Errorf("Unable to unmarshal Target: %s", err)
 	}
 
+	// Counts the generated IDs in this file. allTargets() counts them
+	// again across all the files.
+	generated := make(map[string]int, len(targets))
+	seenIDs := make(map[string]bool, len(targets))
+
 	// Have to loop with traditional 'for' loop so we can modify entries
 	for _, target := range targets {
-		idBytes, err := RandomHex(6)
-		if err != nil {
-			log.Errorf("ParseConfig(): Unable to get random bytes (%s)", err.Error())
-			return nil, err
-		}
-
-		target.Service.ID = string(idBytes)
+		target.Source = filename
 		target.Service.Created = time.Now().UTC()
 		// We _can_ export services for a 3rd party. If we don't specify
 		// the hostname, then it's for this host.
 		if target.Service.Hostname == "" {
-			target.Service.Hostname = d.Hostname
+			target.Service.Hostname = d.NodeName
+		}
+
+		if target.Service.ID == "" {
+			target.generated = true
+			target.assignID(generated)
+		}
+
+		if seenIDs[target.Service.ID] {
+			log.Warnf("ParseConfig(): Duplicate service ID %s for %s",
+				target.Service.ID, target.Service.Name,
+			)
 		}
+		seenIDs[target.Service.ID] = true
 
 		// Make sure we have an IP address on ports
 		for i, port := range target.Service.Ports {
@@ -135,15 +407,71 @@ func (d *StaticDiscovery) ParseConfig(filename string) ([]*Target, error) {
 				target.Service.Ports[i].IP = d.DefaultIP
 			}
 		}
-
-		log.Printf("Discovered service: %s, ID: %s",
-			target.Service.Name,
-			target.Service.ID,
-		)
 	}
 	return targets, nil
 }
 
+func isYAML(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// yamlToJSON converts a YAML document to JSON so that YAML configs are
+// decoded exactly like JSON ones, including the case insensitive keys.
+func yamlToJSON(data []byte) ([]byte, error) {
+	var parsed interface{}
+	err := yaml.Unmarshal(data, &parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(convertYAML(parsed))
+}
+
+// convertYAML replaces the map[interface{}]interface{} values produced by the
+// YAML decoder with map[string]interface{} values that JSON can encode.
+func convertYAML(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[fmt.Sprintf("%v", key)] = convertYAML(item)
+		}
+		return result
+	case []interface{}:
+		for i, item := range v {
+			v[i] = convertYAML(item)
+		}
+		return v
+	default:
+		return value
+	}
+}
+
+// assignID sets the ID of the target from stableID(). Identical targets
+// generate the same ID, so generated counts them to keep their IDs distinct.
+func (t *Target) assignID(generated map[string]int) {
+	baseID := t.stableID(0)
+	t.Service.ID = t.stableID(generated[baseID])
+	generated[baseID]++
+}
+
+// stableID derives an ID from the hostname, service name and ports of the
+// target so that it stays the same across reloads and restarts. Identical
+// targets are told apart by passing the number of earlier occurrences.
+func (t *Target) stableID(occurrence int) string {
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%s|%s", t.Service.Hostname, t.Service.Name)
+	for _, port := range t.Service.Ports {
+		fmt.Fprintf(hash, "|%s:%d:%d", port.Type, port.Port, port.ServicePort)
+	}
+	if occurrence > 0 {
+		fmt.Fprintf(hash, "|%d", occurrence)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))[:12]
+}
+
 // Return a defined number of random bytes as a slice
 func RandomHex(count int) ([]byte, error) {
 	raw := make([]byte, count)
@@ -1,6 +1,9 @@
 package discovery
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -18,7 +21,7 @@ func Test_ParseConfig(t *testing.T) {
 	Convey("ParseConfig()", t, func() {
 		ip := "127.0.0.1"
 		disco := NewStaticDiscovery(STATIC_JSON, ip)
-		disco.Hostname = hostname
+		disco.NodeName = hostname
 
 		Convey("Errors when there is a problem with the file", func() {
 			_, err := disco.ParseConfig("!!!!")
@@ -50,6 +53,45 @@ func Test_ParseConfig(t *testing.T) {
 			So(len(parsed), ShouldEqual, 1)
 			So(parsed[0].Service.Ports[0].IP, ShouldEqual, ip)
 		})
+
+		Convey("Assigns the same ID every time", func() {
+			first, _ := disco.ParseConfig(STATIC_JSON)
+			second, _ := disco.ParseConfig(STATIC_JSON)
+
+			So(len(first[0].Service.ID), ShouldEqual, 12)
+			So(first[0].Service.ID, ShouldEqual, second[0].Service.ID)
+		})
+
+		Convey("Assigns different IDs on different hosts", func() {
+			first, _ := disco.ParseConfig(STATIC_JSON)
+			disco.NodeName = "chaucer"
+			second, _ := disco.ParseConfig(STATIC_JSON)
+
+			So(first[0].Service.ID, ShouldNotEqual, second[0].Service.ID)
+		})
+
+		Convey("Respects explicit IDs and keeps duplicate targets apart", func() {
+			dir, err := ioutil.TempDir("", "static-discovery")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+
+			configFile := filepath.Join(dir, "static.json")
+			err = ioutil.WriteFile(configFile, []byte(`[
+				{"Service": {"ID": "beowulf123", "Name": "beowulf"}},
+				{"Service": {"Name": "grendel"}},
+				{"Service": {"Name": "grendel"}}
+			]`), 0644)
+			So(err, ShouldBeNil)
+
+			parsed, err := disco.ParseConfig(configFile)
+			So(err, ShouldBeNil)
+			So(len(parsed), ShouldEqual, 3)
+			So(parsed[0].Service.ID, ShouldEqual, "beowulf123")
+			So(parsed[1].Service.ID, ShouldNotEqual, parsed[2].Service.ID)
+
+			again, _ := disco.ParseConfig(configFile)
+			So(again[2].Service.ID, ShouldEqual, parsed[2].Service.ID)
+		})
 	})
 }
 
@@ -85,6 +127,45 @@ func Test_Services(t *testing.T) {
 	})
 }
 
+func Test_HealthCheckOptions(t *testing.T) {
+	Convey("HealthCheckOptions()", t, func() {
+		ip := "127.0.0.1"
+		disco := NewStaticDiscovery(STATIC_JSON, ip)
+		tgt := &Target{
+			Service: service.Service{ID: "asdf"},
+			Check: StaticCheck{
+				Type:     "HttpGet",
+				Interval: "30s",
+				Timeout:  "bad",
+				Rise:     2,
+				Fall:     3,
+			},
+		}
+		disco.Targets = []*Target{tgt}
+
+		Convey("Returns the options from the Check", func() {
+			opts := disco.HealthCheckOptions(&tgt.Service)
+
+			So(opts.Interval, ShouldEqual, 30*time.Second)
+			So(opts.Timeout, ShouldEqual, 0)
+			So(opts.Rise, ShouldEqual, 2)
+			So(opts.Fall, ShouldEqual, 3)
+		})
+
+		Convey("Cuts a timeout that is longer than the interval down to the interval", func() {
+			tgt.Check.Timeout = "1m"
+			opts := disco.HealthCheckOptions(&tgt.Service)
+
+			So(opts.Timeout, ShouldEqual, 30*time.Second)
+		})
+
+		Convey("Returns empty options for unknown services", func() {
+			opts := disco.HealthCheckOptions(&service.Service{ID: "foofoo"})
+			So(opts, ShouldResemble, HealthCheckOptions{})
+		})
+	})
+}
+
 func Test_Listeners(t *testing.T) {
 	Convey("Listeners()", t, func() {
 		ip := "127.0.0.1"
@@ -137,3 +218,162 @@ func Test_Run(t *testing.T) {
 		})
 	})
 }
+
+func Test_Reloading(t *testing.T) {
+	Convey("Reloading the config", t, func() {
+		dir, err := ioutil.TempDir("", "static-discovery")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		configFile := filepath.Join(dir, "static.json")
+		lastWrite := time.Now().Add(-1 * time.Hour)
+
+		// Bump the modification time on each write so we don't depend on
+		// the resolution of the filesystem timestamps.
+		writeConfig := func(contents string) {
+			err := ioutil.WriteFile(configFile, []byte(contents), 0644)
+			So(err, ShouldBeNil)
+			lastWrite = lastWrite.Add(1 * time.Second)
+			So(os.Chtimes(configFile, lastWrite, lastWrite), ShouldBeNil)
+		}
+
+		beowulf := `{"Service": {"Name": "beowulf", "Ports": [{"Type": "tcp", "Port": 10234}]}}`
+		grendel := `{"Service": {"Name": "grendel", "Ports": [{"Type": "tcp", "Port": 10235}]}}`
+
+		writeConfig("[" + beowulf + "]")
+
+		disco := NewStaticDiscovery(configFile, "127.0.0.1")
+		disco.reloadIfChanged()
+		So(len(disco.Targets), ShouldEqual, 1)
+		originalID := disco.Targets[0].Service.ID
+
+		Convey("Keeps the IDs of existing targets", func() {
+			writeConfig("[" + beowulf + "," + grendel + "]")
+			disco.reloadIfChanged()
+
+			So(len(disco.Targets), ShouldEqual, 2)
+			So(disco.Targets[0].Service.ID, ShouldEqual, originalID)
+			So(disco.Targets[1].Service.ID, ShouldNotEqual, originalID)
+		})
+
+		Convey("Drops targets that were removed", func() {
+			writeConfig("[" + grendel + "]")
+			disco.reloadIfChanged()
+
+			So(len(disco.Targets), ShouldEqual, 1)
+			So(disco.Targets[0].Service.Name, ShouldEqual, "grendel")
+			So(disco.Targets[0].Service.ID, ShouldNotEqual, originalID)
+		})
+
+		Convey("Treats a target with a changed config as a new one", func() {
+			writeConfig(`[{"Service": {"Name": "beowulf", "Ports": [{"Type": "tcp", "Port": 9999}]}}]`)
+			disco.reloadIfChanged()
+
+			So(len(disco.Targets), ShouldEqual, 1)
+			So(disco.Targets[0].Service.ID, ShouldNotEqual, originalID)
+		})
+
+		Convey("Keeps the last good config when the file is broken", func() {
+			writeConfig("[" + beowulf + ",")
+			disco.reloadIfChanged()
+
+			So(len(disco.Targets), ShouldEqual, 1)
+			So(disco.Targets[0].Service.ID, ShouldEqual, originalID)
+		})
+
+		Convey("Keeps the last good config when the file goes away", func() {
+			os.Remove(configFile)
+			disco.reloadIfChanged()
+
+			So(len(disco.Targets), ShouldEqual, 1)
+			So(disco.Targets[0].Service.ID, ShouldEqual, originalID)
+		})
+
+		Convey("Does nothing when the file has not changed", func() {
+			disco.Targets[0].Service.ID = "untouched"
+			disco.reloadIfChanged()
+
+			So(disco.Targets[0].Service.ID, ShouldEqual, "untouched")
+		})
+	})
+}
+
+func Test_ConfigDir(t *testing.T) {
+	Convey("Loading configs from a directory", t, func() {
+		dir, err := ioutil.TempDir("", "static-discovery")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		lastWrite := time.Now().Add(-1 * time.Hour)
+		writeConfig := func(name string, contents string) {
+			filename := filepath.Join(dir, name)
+			err := ioutil.WriteFile(filename, []byte(contents), 0644)
+			So(err, ShouldBeNil)
+			lastWrite = lastWrite.Add(1 * time.Second)
+			So(os.Chtimes(filename, lastWrite, lastWrite), ShouldBeNil)
+		}
+
+		writeConfig("beowulf.json", `[{"Service": {"Name": "beowulf"}}]`)
+		writeConfig("grendel.yaml", "- Service:\n    Name: grendel\n    Ports:\n      - Type: tcp\n        Port: 10234\n")
+		writeConfig("README.md", "Not a config file")
+
+		disco := NewStaticDiscovery("", "127.0.0.1")
+		disco.ConfigDir = dir
+		disco.reloadIfChanged()
+
+		Convey("Loads the JSON and YAML files", func() {
+			So(len(disco.Targets), ShouldEqual, 2)
+			So(disco.Targets[0].Service.Name, ShouldEqual, "beowulf")
+			So(disco.Targets[1].Service.Name, ShouldEqual, "grendel")
+			So(disco.Targets[1].Service.Ports[0].Port, ShouldEqual, 10234)
+			So(disco.Targets[1].Service.Ports[0].IP, ShouldEqual, "127.0.0.1")
+		})
+
+		Convey("Tracks which file each target came from", func() {
+			So(disco.Targets[0].Source, ShouldEqual, filepath.Join(dir, "beowulf.json"))
+			So(disco.Targets[1].Source, ShouldEqual, filepath.Join(dir, "grendel.yaml"))
+		})
+
+		Convey("Keeps generated IDs distinct across files", func() {
+			writeConfig("hrothgar.json", `[{"Service": {"Name": "beowulf"}}]`)
+			disco.reloadIfChanged()
+
+			So(len(disco.Targets), ShouldEqual, 3)
+			So(disco.Targets[0].Service.Name, ShouldEqual, "beowulf")
+			So(disco.Targets[2].Service.Name, ShouldEqual, "beowulf")
+			So(disco.Targets[0].Service.ID, ShouldEqual, disco.Targets[0].stableID(0))
+			So(disco.Targets[2].Service.ID, ShouldEqual, disco.Targets[2].stableID(1))
+		})
+
+		Convey("Reports errors per file and keeps the other files", func() {
+			writeConfig("beowulf.json", `[{"Service": `)
+			writeConfig("hrothgar.json", `[{"Service": {"Name": "hrothgar"}}]`)
+			disco.reloadIfChanged()
+
+			errors := disco.ConfigErrors()
+			So(len(errors), ShouldEqual, 1)
+			So(errors[filepath.Join(dir, "beowulf.json")], ShouldNotBeNil)
+
+			So(len(disco.Targets), ShouldEqual, 3)
+			So(disco.Targets[0].Service.Name, ShouldEqual, "beowulf")
+			So(disco.Targets[2].Service.Name, ShouldEqual, "hrothgar")
+		})
+
+		Convey("Clears the error once the file is fixed", func() {
+			writeConfig("beowulf.json", `[{"Service": `)
+			disco.reloadIfChanged()
+			writeConfig("beowulf.json", `[{"Service": {"Name": "beowulf"}}]`)
+			disco.reloadIfChanged()
+
+			So(disco.ConfigErrors(), ShouldBeEmpty)
+		})
+
+		Convey("Drops the targets from files that were removed", func() {
+			os.Remove(filepath.Join(dir, "beowulf.json"))
+			disco.reloadIfChanged()
+
+			So(len(disco.Targets), ShouldEqual, 1)
+			So(disco.Targets[0].Service.Name, ShouldEqual, "grendel")
+		})
+	})
+}
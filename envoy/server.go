@@ -118,7 +118,9 @@ func (s *Server) Run(ctx context.Context, looper director.Looper, grpcListener n
 		}
 	}()
 
-	// Currently, this will block forever
+	// Block until we're shutting down. The xDS server was created with the
+	// same context, so its streams are closed too and GracefulStop() won't
+	// wait on them.
 	<-ctx.Done()
 	grpcServer.GracefulStop()
 }
@@ -320,7 +320,7 @@ func Test_PortForServicePort(t *testing.T) {
 				envoyMock.ValidateResources(stream, httpSvc, state.Hostname)
 
 				Convey("and removes it after it gets tombstoned", func() {
-					httpSvc.Tombstone()
+					httpSvc.Tombstone(state.Clock)
 					httpSvc.Updated.Add(1 * time.Millisecond)
 					state.AddServiceEntry(httpSvc)
 					<-snapshotCache.Waiter
@@ -368,7 +368,7 @@ func Test_PortForServicePort(t *testing.T) {
 			})
 
 			Convey("and skips tombstones", func() {
-				httpSvc.Tombstone()
+				httpSvc.Tombstone(state.Clock)
 				state.AddServiceEntry(httpSvc)
 				<-snapshotCache.Waiter
 
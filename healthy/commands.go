@@ -4,32 +4,179 @@
 package healthy
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"os/exec"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	// The longest we'll wait to connect to a service. The Monitor will
+	// usually time the check out long before this.
+	CONNECT_TIMEOUT = 30 * time.Second
+
+	// The most of a response body we'll read when matching against it
+	MAX_BODY_SIZE = 64 * 1024
 )
 
 // A Checker that makes an HTTP get call and expects to get
 // a 200-299 back as success. Anything else is considered
 // a failure. The URL to hit is passed as the args to the
-// Run method.
+// Run method. Alternatively the args can be a JSON encoded
+// HttpCheckArgs to control the request and the expected
+// response.
 type HttpGetCmd struct{}
 
 func (h *HttpGetCmd) Run(args string) (int, error) {
-	resp, err := http.Get(args)
+	checkArgs, err := ParseHttpCheckArgs(args)
+	if err != nil {
+		return UNKNOWN, err
+	}
+
+	client, err := checkArgs.newClient(nil)
+	if err != nil {
+		return UNKNOWN, err
+	}
+
+	return checkArgs.run(client, checkArgs.URL)
+}
+
+// HttpCheckArgs are the structured arguments for HTTP checks. Only
+// the URL is required.
+type HttpCheckArgs struct {
+	URL                string
+	Method             string            // Defaults to GET
+	Headers            map[string]string // Extra request headers
+	Body               string            // Request body to send
+	ExpectStatus       int               // Defaults to any 2xx status
+	BodyContains       string            // The response body must contain this
+	InsecureSkipVerify bool              // Don't verify TLS certificates
+	CAFile             string            // PEM file of CAs to verify TLS against
+	Timeout            string            // Request timeout, e.g. "5s"
+}
+
+// ParseHttpCheckArgs takes either a bare URL or a JSON encoded
+// HttpCheckArgs and returns the parsed arguments.
+func ParseHttpCheckArgs(args string) (*HttpCheckArgs, error) {
+	args = strings.TrimSpace(args)
+
+	if !strings.HasPrefix(args, "{") {
+		return &HttpCheckArgs{URL: args}, nil
+	}
+
+	var checkArgs HttpCheckArgs
+	err := json.Unmarshal([]byte(args), &checkArgs)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to parse HTTP check args: %s", err)
+	}
+
+	return &checkArgs, nil
+}
+
+// newClient returns an HTTP client configured from the args. If dial is
+// not nil, it is used to make all connections.
+func (a *HttpCheckArgs) newClient(dial func(context.Context, string, string) (net.Conn, error)) (*http.Client, error) {
+	timeout := CONNECT_TIMEOUT
+	if a.Timeout != "" {
+		var err error
+		timeout, err = time.ParseDuration(a.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid HTTP check timeout '%s': %s", a.Timeout, err)
+		}
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: a.InsecureSkipVerify}
+	if a.CAFile != "" {
+		pem, err := ioutil.ReadFile(a.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read CA file: %s", err)
+		}
+
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificates found in CA file '%s'", a.CAFile)
+		}
+	}
+
+	// We make a new transport for each run, so don't leave connections open
+	transport := &http.Transport{
+		TLSClientConfig:   tlsConfig,
+		DisableKeepAlives: true,
+	}
+	if dial != nil {
+		transport.DialContext = dial
+	}
+
+	return &http.Client{Timeout: timeout, Transport: transport}, nil
+}
+
+// run makes the request to the URL and validates the response
+func (a *HttpCheckArgs) run(client *http.Client, url string) (int, error) {
+	method := a.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	var body io.Reader
+	if a.Body != "" {
+		body = strings.NewReader(a.Body)
+	}
+
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return UNKNOWN, err
+	}
+
+	for header, value := range a.Headers {
+		req.Header.Set(header, value)
+	}
+
+	resp, err := client.Do(req)
 	if resp == nil {
-		return UNKNOWN, errors.New("No body from HTTP response!")
+		if err == nil {
+			err = errors.New("No body from HTTP response!")
+		}
+		return UNKNOWN, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
-		return HEALTHY, nil
+	if a.ExpectStatus != 0 {
+		if resp.StatusCode != a.ExpectStatus {
+			return SICKLY, &CheckFailure{
+				Reason: fmt.Sprintf("HTTP status %d, expected %d", resp.StatusCode, a.ExpectStatus),
+			}
+		}
+	} else if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return SICKLY, &CheckFailure{Reason: fmt.Sprintf("HTTP status %d", resp.StatusCode)}
 	}
 
-	return SICKLY, err
+	if a.BodyContains != "" {
+		respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, MAX_BODY_SIZE))
+		if err != nil {
+			return UNKNOWN, err
+		}
+
+		if !strings.Contains(string(respBody), a.BodyContains) {
+			return SICKLY, &CheckFailure{
+				Reason: fmt.Sprintf("HTTP response body did not contain %q", a.BodyContains),
+			}
+		}
+	}
+
+	return HEALTHY, nil
 }
 
 // A Checker that works with Nagios checks or other simple
@@ -62,3 +209,106 @@ type AlwaysSuccessfulCmd struct{}
 func (a *AlwaysSuccessfulCmd) Run(args string) (int, error) {
 	return HEALTHY, nil
 }
+
+// A Checker that opens a TCP connection and considers the service
+// healthy if the connection succeeds. Useful for services that don't
+// speak HTTP. The address to connect to is passed as the args to the
+// Run method in the form "host:port".
+type TcpConnectCmd struct{}
+
+func (t *TcpConnectCmd) Run(args string) (int, error) {
+	conn, err := net.DialTimeout("tcp", strings.TrimPrefix(args, "tcp://"), CONNECT_TIMEOUT)
+	if err != nil {
+		return SICKLY, &CheckFailure{Reason: err.Error()}
+	}
+	conn.Close()
+
+	return HEALTHY, nil
+}
+
+// A Checker that uses the standard gRPC health checking protocol
+// (grpc.health.v1). The service must report SERVING to be considered
+// healthy. Args are the address of the gRPC server in the form
+// "host:port", optionally followed by "/" and the name of the gRPC
+// service to check. Without a service name, the server's overall
+// health is checked.
+type GrpcHealthCmd struct{}
+
+func (g *GrpcHealthCmd) Run(args string) (int, error) {
+	addr := args
+	var svcName string
+	if idx := strings.Index(args, "/"); idx >= 0 {
+		addr = args[:idx]
+		svcName = args[idx+1:]
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), CONNECT_TIMEOUT)
+	defer cancel()
+
+	conn, err := grpc.DialContext(ctx, addr, grpc.WithInsecure(), grpc.WithBlock())
+	if err != nil {
+		return UNKNOWN, err
+	}
+	defer conn.Close()
+
+	resp, err := grpc_health_v1.NewHealthClient(conn).Check(
+		ctx, &grpc_health_v1.HealthCheckRequest{Service: svcName},
+	)
+	if err != nil {
+		return UNKNOWN, err
+	}
+
+	if resp.GetStatus() != grpc_health_v1.HealthCheckResponse_SERVING {
+		return SICKLY, &CheckFailure{Reason: fmt.Sprintf("gRPC health status is %s", resp.GetStatus())}
+	}
+
+	return HEALTHY, nil
+}
+
+// A Checker that makes an HTTP get call over a Unix domain socket and
+// expects a 200-299 back as success. Args are the path to the socket,
+// optionally followed by a space and either the path to request, which
+// defaults to "/", or a JSON encoded HttpCheckArgs whose URL is the
+// path. e.g. "/var/run/app.sock /status"
+type HttpGetUnixCmd struct{}
+
+func (h *HttpGetUnixCmd) Run(args string) (int, error) {
+	fields := strings.SplitN(strings.TrimSpace(args), " ", 2)
+	socket := fields[0]
+	if socket == "" {
+		return UNKNOWN, errors.New("No socket path provided!")
+	}
+
+	checkArgs := &HttpCheckArgs{URL: "/"}
+	if len(fields) > 1 && strings.TrimSpace(fields[1]) != "" {
+		var err error
+		checkArgs, err = ParseHttpCheckArgs(fields[1])
+		if err != nil {
+			return UNKNOWN, err
+		}
+	}
+
+	client, err := checkArgs.newClient(
+		func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		},
+	)
+	if err != nil {
+		return UNKNOWN, err
+	}
+
+	// The host is ignored because we always dial the socket
+	return checkArgs.run(client, "http://unix"+checkArgs.URL)
+}
+
+// A Checker that always fails. It is used in place of check types that
+// we don't know about so that the error is reported on the check rather
+// than silently running some other kind of check.
+type InvalidCmd struct {
+	Err error
+}
+
+func (i *InvalidCmd) Run(args string) (int, error) {
+	return UNKNOWN, i.Err
+}
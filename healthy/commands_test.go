@@ -0,0 +1,257 @@
+package healthy
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func Test_TcpConnectCmd(t *testing.T) {
+	Convey("TcpConnectCmd", t, func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		addr := listener.Addr().String()
+
+		cmd := &TcpConnectCmd{}
+
+		Convey("is healthy when the port accepts connections", func() {
+			status, err := cmd.Run(addr)
+			listener.Close()
+
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, HEALTHY)
+		})
+
+		Convey("accepts a tcp:// prefix", func() {
+			status, err := cmd.Run("tcp://" + addr)
+			listener.Close()
+
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, HEALTHY)
+		})
+
+		Convey("is sickly when nothing is listening", func() {
+			listener.Close()
+			status, err := cmd.Run(addr)
+
+			So(err, ShouldNotBeNil)
+			So(status, ShouldEqual, SICKLY)
+		})
+	})
+}
+
+func Test_HttpGetCmd(t *testing.T) {
+	Convey("HttpGetCmd", t, func() {
+		var lastReq *http.Request
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			lastReq = r
+			switch r.URL.Path {
+			case "/status":
+				w.Write([]byte(`{"status":"ok"}`))
+			case "/created":
+				w.WriteHeader(204)
+			default:
+				w.WriteHeader(503)
+			}
+		})
+		server := httptest.NewServer(handler)
+		defer server.Close()
+
+		cmd := &HttpGetCmd{}
+
+		Convey("accepts a bare URL", func() {
+			status, err := cmd.Run(server.URL + "/status")
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, HEALTHY)
+			So(lastReq.Method, ShouldEqual, "GET")
+		})
+
+		Convey("is sickly when the status isn't a 2xx", func() {
+			status, err := cmd.Run(server.URL + "/broken")
+			So(err, ShouldHaveSameTypeAs, &CheckFailure{})
+			So(err.Error(), ShouldContainSubstring, "HTTP status 5")
+			So(status, ShouldEqual, SICKLY)
+		})
+
+		Convey("is unknown when the server can't be reached", func() {
+			status, err := cmd.Run("http://127.0.0.1:1/status")
+			So(err, ShouldNotBeNil)
+			So(status, ShouldEqual, UNKNOWN)
+		})
+
+		Convey("sends the method, headers and body", func() {
+			status, err := cmd.Run(`{
+				"URL": "` + server.URL + `/created",
+				"Method": "POST",
+				"Headers": {"X-Token": "beowulf"},
+				"Body": "hello",
+				"ExpectStatus": 204
+			}`)
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, HEALTHY)
+			So(lastReq.Method, ShouldEqual, "POST")
+			So(lastReq.Header.Get("X-Token"), ShouldEqual, "beowulf")
+		})
+
+		Convey("is sickly when the status doesn't match the expected one", func() {
+			status, err := cmd.Run(`{"URL": "` + server.URL + `/status", "ExpectStatus": 204}`)
+			So(err, ShouldHaveSameTypeAs, &CheckFailure{})
+			So(err.Error(), ShouldEqual, "HTTP status 200, expected 204")
+			So(status, ShouldEqual, SICKLY)
+		})
+
+		Convey("matches against the body", func() {
+			status, err := cmd.Run(`{"URL": "` + server.URL + `/status", "BodyContains": "\"status\":\"ok\""}`)
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, HEALTHY)
+
+			status, err = cmd.Run(`{"URL": "` + server.URL + `/status", "BodyContains": "borked"}`)
+			So(err, ShouldHaveSameTypeAs, &CheckFailure{})
+			So(status, ShouldEqual, SICKLY)
+		})
+
+		Convey("returns an error for invalid args", func() {
+			status, err := cmd.Run(`{"URL": `)
+			So(err, ShouldNotBeNil)
+			So(status, ShouldEqual, UNKNOWN)
+
+			status, err = cmd.Run(`{"URL": "` + server.URL + `", "Timeout": "junk"}`)
+			So(err, ShouldNotBeNil)
+			So(status, ShouldEqual, UNKNOWN)
+		})
+
+		Convey("with TLS", func() {
+			tlsServer := httptest.NewTLSServer(handler)
+			defer tlsServer.Close()
+
+			Convey("rejects self-signed certificates by default", func() {
+				status, err := cmd.Run(tlsServer.URL + "/status")
+				So(err, ShouldNotBeNil)
+				So(status, ShouldEqual, UNKNOWN)
+			})
+
+			Convey("can skip certificate verification", func() {
+				status, err := cmd.Run(`{"URL": "` + tlsServer.URL + `/status", "InsecureSkipVerify": true}`)
+				So(err, ShouldBeNil)
+				So(status, ShouldEqual, HEALTHY)
+			})
+		})
+	})
+}
+
+func Test_HttpGetUnixCmd(t *testing.T) {
+	Convey("HttpGetUnixCmd", t, func() {
+		dir, err := ioutil.TempDir("", "sidecar-healthy")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		socket := filepath.Join(dir, "app.sock")
+		listener, err := net.Listen("unix", socket)
+		So(err, ShouldBeNil)
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(200)
+		})
+		mux.HandleFunc("/broken", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(503)
+		})
+		server := &http.Server{Handler: mux}
+		go server.Serve(listener)
+		defer server.Close()
+
+		cmd := &HttpGetUnixCmd{}
+
+		Convey("is healthy when the endpoint returns a 2xx", func() {
+			status, err := cmd.Run(socket + " /status")
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, HEALTHY)
+		})
+
+		Convey("is sickly when the endpoint returns an error", func() {
+			status, err := cmd.Run(socket + " /broken")
+			So(err, ShouldHaveSameTypeAs, &CheckFailure{})
+			So(status, ShouldEqual, SICKLY)
+		})
+
+		Convey("accepts structured args", func() {
+			status, err := cmd.Run(socket + ` {"URL": "/broken", "ExpectStatus": 503}`)
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, HEALTHY)
+		})
+
+		Convey("is unknown when the socket doesn't exist", func() {
+			status, err := cmd.Run(filepath.Join(dir, "missing.sock"))
+			So(err, ShouldNotBeNil)
+			So(status, ShouldEqual, UNKNOWN)
+		})
+
+		Convey("requires a socket path", func() {
+			status, err := cmd.Run("")
+			So(err, ShouldNotBeNil)
+			So(status, ShouldEqual, UNKNOWN)
+		})
+	})
+}
+
+func Test_GrpcHealthCmd(t *testing.T) {
+	Convey("GrpcHealthCmd", t, func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		addr := listener.Addr().String()
+
+		healthServer := health.NewServer()
+		healthServer.SetServingStatus("good.Service", grpc_health_v1.HealthCheckResponse_SERVING)
+		healthServer.SetServingStatus("bad.Service", grpc_health_v1.HealthCheckResponse_NOT_SERVING)
+
+		server := grpc.NewServer()
+		grpc_health_v1.RegisterHealthServer(server, healthServer)
+		go server.Serve(listener)
+		defer server.Stop()
+
+		cmd := &GrpcHealthCmd{}
+
+		Convey("is healthy when the server is serving", func() {
+			status, err := cmd.Run(addr)
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, HEALTHY)
+		})
+
+		Convey("checks the named service", func() {
+			status, err := cmd.Run(addr + "/good.Service")
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, HEALTHY)
+		})
+
+		Convey("is sickly when the service is not serving", func() {
+			status, err := cmd.Run(addr + "/bad.Service")
+			So(err, ShouldNotBeNil)
+			So(status, ShouldEqual, SICKLY)
+		})
+
+		Convey("is unknown when the service is not registered", func() {
+			status, err := cmd.Run(addr + "/missing.Service")
+			So(err, ShouldNotBeNil)
+			So(status, ShouldEqual, UNKNOWN)
+		})
+	})
+}
+
+func Test_InvalidCmd(t *testing.T) {
+	Convey("InvalidCmd always returns its error", t, func() {
+		cmd := &InvalidCmd{Err: os.ErrInvalid}
+		status, err := cmd.Run("anything")
+
+		So(status, ShouldEqual, UNKNOWN)
+		So(err, ShouldEqual, os.ErrInvalid)
+	})
+}
@@ -1,7 +1,8 @@
 // A lightweight health-checking module so we can make
 // sure that services are running and healthy before
-// we announce them to our peers. Has a standard check
-// interval for all checks, not configurable per check.
+// we announce them to our peers. Each check runs on its
+// own interval, falling back to a standard interval for
+// checks which don't configure one.
 
 package healthy
 
@@ -9,6 +10,7 @@ import (
 	"errors"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/Nitro/sidecar/service"
 	"github.com/relistan/go-director"
@@ -23,21 +25,36 @@ const (
 )
 
 const (
-	FOREVER         = -1
-	WATCH_INTERVAL  = 500 * time.Millisecond
-	HEALTH_INTERVAL = 3 * time.Second
+	FOREVER            = -1
+	WATCH_INTERVAL     = 500 * time.Millisecond
+	HEALTH_INTERVAL    = 3 * time.Second
+	SCHEDULER_INTERVAL = 250 * time.Millisecond // How often we look for checks to run
+	MAX_SUMMARY_ERROR  = 128                    // Longest error we gossip in a HealthSummary
+	MIN_CHECK_TIMEOUT  = 10 * time.Millisecond  // Shortest default timeout, for very short intervals
 )
 
+// A CheckFailure is returned by a Checker when the check ran fine but the
+// service did not pass it. Unlike other errors it does not make the check
+// UNKNOWN, it just records why the service is unhealthy.
+type CheckFailure struct {
+	Reason string
+}
+
+func (f *CheckFailure) Error() string {
+	return f.Reason
+}
+
 // The Monitor is responsible for managing and running Checks.
-// It has a fixed check interval that is used for all checks.
-// Access must be synchronized so direct access to struct
-// members is possible but requires use of the RWMutex.
+// CheckInterval is used for all checks that don't have their
+// own Interval. Access must be synchronized so direct access
+// to struct members is possible but requires use of the RWMutex.
 type Monitor struct {
 	Checks               map[string]*Check
 	CheckInterval        time.Duration
 	DefaultCheckHost     string
 	DiscoveryFn          func() []service.Service
 	DefaultCheckEndpoint string
+	nowFunc              func() time.Time
 	sync.RWMutex
 }
 
@@ -60,6 +77,19 @@ type Check struct {
 	// The maximum number before we declare that it failed
 	MaxCount int
 
+	// The number of consecutive successful runs
+	SuccessCount int
+
+	// The number of successes before a failing check is healthy again
+	Rise int
+
+	// How often to run this check. Uses the Monitor's default when zero.
+	Interval time.Duration
+
+	// How long to wait for the check to complete. Defaults to just
+	// under the Interval when zero, or longer than the Interval.
+	Timeout time.Duration
+
 	// String describing the kind of check
 	Type string
 
@@ -71,6 +101,13 @@ type Check struct {
 
 	// The last recorded error on this check
 	LastError error
+
+	// The most recent results of running this check
+	History *CheckHistory
+
+	// Scheduling state, protected by the Monitor's lock
+	nextRun time.Time
+	running bool
 }
 
 type Checker interface {
@@ -93,7 +130,13 @@ func NewCheck(id string) *Check {
 // UpdateStatus take the status integer and error and applies them to the status
 // of the current Check.
 func (check *Check) UpdateStatus(status int, err error) {
-	if err != nil {
+	previousStatus := check.Status
+
+	if failure, ok := err.(*CheckFailure); ok {
+		log.Debugf("Check failed: %s (id %s)", failure.Reason, check.ID)
+		check.Status = status
+		check.LastError = err
+	} else if err != nil {
 		log.Debugf("Error executing check, status UNKNOWN: (id %s)", check.ID)
 		check.Status = UNKNOWN
 		check.LastError = err
@@ -103,9 +146,17 @@ func (check *Check) UpdateStatus(status int, err error) {
 
 	if status == HEALTHY {
 		check.Count = 0
+		check.SuccessCount = check.SuccessCount + 1
+
+		// Checks that were failing have to pass Rise times in a row
+		// before we consider them healthy again.
+		if previousStatus != HEALTHY && check.SuccessCount < check.Rise {
+			check.Status = previousStatus
+		}
 		return
 	}
 
+	check.SuccessCount = 0
 	check.Count = check.Count + 1
 
 	if check.Count >= check.MaxCount {
@@ -126,6 +177,34 @@ func (check *Check) ServiceStatus() int {
 	}
 }
 
+// HealthSummary returns a compact summary of this check that can be
+// gossiped along with the service. Healthy checks have nothing to report
+// so they return nil.
+func (check *Check) HealthSummary() *service.HealthSummary {
+	if check.Status == HEALTHY {
+		return nil
+	}
+
+	summary := &service.HealthSummary{
+		Type:      check.Type,
+		FailCount: check.Count,
+	}
+
+	if check.LastError != nil {
+		summary.LastError = check.LastError.Error()
+		if len(summary.LastError) > MAX_SUMMARY_ERROR {
+			// Back up to the start of a rune so we don't split it
+			cut := MAX_SUMMARY_ERROR
+			for cut > 0 && !utf8.RuneStart(summary.LastError[cut]) {
+				cut--
+			}
+			summary.LastError = summary.LastError[:cut]
+		}
+	}
+
+	return summary
+}
+
 // NewMonitor returns a properly configured default configuration of a Monitor.
 func NewMonitor(defaultCheckHost string, defaultCheckEndpoint string) *Monitor {
 	monitor := Monitor{
@@ -133,6 +212,7 @@ func NewMonitor(defaultCheckHost string, defaultCheckEndpoint string) *Monitor {
 		CheckInterval:        HEALTH_INTERVAL,
 		DefaultCheckHost:     defaultCheckHost,
 		DefaultCheckEndpoint: defaultCheckEndpoint,
+		nowFunc:              time.Now,
 	}
 	return &monitor
 }
@@ -146,7 +226,8 @@ func (m *Monitor) AddCheck(check *Check) {
 }
 
 // MarkService takes a service and mark its Status appropriately based on the
-// current check we have configured.
+// current check we have configured. It also attaches a summary of the check
+// so that peers can see why a service is not healthy.
 func (m *Monitor) MarkService(svc *service.Service) {
 	// We remove checks when encountering a Tombstone record. This
 	// prevents us from storing up checks forever. The discovery
@@ -154,62 +235,129 @@ func (m *Monitor) MarkService(svc *service.Service) {
 	// this is the best signal we'll get that a check is no longer
 	// needed. Assumes we're only health checking _our own_ services.
 	m.RLock()
-	if _, ok := m.Checks[svc.ID]; ok {
-		svc.Status = m.Checks[svc.ID].ServiceStatus()
+	if check, ok := m.Checks[svc.ID]; ok {
+		svc.Status = check.ServiceStatus()
+		svc.Health = check.HealthSummary()
 	} else {
 		svc.Status = service.UNKNOWN
+		svc.Health = nil
 	}
 	m.RUnlock()
 }
 
-// Run runs the main monitoring loop. The looper controls the actual run behavior.
+// Run runs the main monitoring loop. The looper controls how often we look
+// for checks to run, and each check is only run once its own interval has
+// passed. Checks run in their own goroutines so that a slow check does not
+// hold up the others.
 func (m *Monitor) Run(looper director.Looper) {
+	var wg sync.WaitGroup
+
 	looper.Loop(func() error {
 		log.Debugf("Running checks")
 
-		var wg sync.WaitGroup
-
-		// Make immutable copy of m.Checks (checks are still mutable)
-		m.RLock()
-		checks := make(map[string]*Check, len(m.Checks))
-		for k, v := range m.Checks {
-			checks[k] = v
-		}
-		m.RUnlock()
+		now := m.nowFunc().UTC()
 
-		wg.Add(len(checks))
-		for _, check := range checks {
-			// Run all checks in parallel in goroutines
-			resultChan := make(chan checkResult, 1)
+		// Find the checks that are due and mark them as running so
+		// that we don't start them again before they complete.
+		var due []*Check
+		m.Lock()
+		for _, check := range m.Checks {
+			if check.running || now.Before(check.nextRun) {
+				continue
+			}
 
-			go func(check *Check, resultChan chan checkResult) {
-				result, err := check.Command.Run(check.Args)
-				resultChan <- checkResult{result, err}
-			}(check, resultChan) // copy check pointer for the goroutine
+			check.running = true
+			check.nextRun = now.Add(m.intervalFor(check))
+			due = append(due, check)
+		}
+		m.Unlock()
 
-			go func(check *Check, resultChan chan checkResult) {
+		var pass sync.WaitGroup
+		wg.Add(len(due))
+		pass.Add(len(due))
+		for _, check := range due {
+			go func(check *Check) { // copy check pointer for the goroutine
 				defer wg.Done()
-
-				// We make the call but we time out if it gets too close to the
-				// m.CheckInterval.
-				select {
-				case result := <-resultChan:
-					check.UpdateStatus(result.status, result.err)
-				case <-time.After(m.CheckInterval - 1*time.Millisecond):
-					log.Errorf("Error, check %s timed out! (%v)", check.ID, check.Args)
-					check.UpdateStatus(UNKNOWN, errors.New("Timed out!"))
-				}
-			}(check, resultChan) // copy check pointer for the goroutine
+				defer pass.Done()
+				m.runCheck(check)
+			}(check)
 		}
 
-		// Let's make sure we don't continue to spool up
-		// huge quantities of goroutines. Wait on all of them
-		// to complete before moving on. This could slow down
-		// our check loop if something doesn't time out properly.
-		wg.Wait()
+		// Give the checks until the next pass to complete, so that quick
+		// checks are done before we look for due checks again. Slow ones
+		// carry on in the background.
+		passDone := make(chan struct{})
+		go func() {
+			pass.Wait()
+			close(passDone)
+		}()
+
+		select {
+		case <-passDone:
+		case <-time.After(SCHEDULER_INTERVAL):
+		}
 
 		return nil
 	})
+
+	// Let's make sure we don't leave checks running behind us
+	// when the looper exits.
+	wg.Wait()
+}
+
+// runCheck invokes the Command for a single check and applies the result.
+// We make the call but we time out if it takes longer than the check's
+// timeout.
+func (m *Monitor) runCheck(check *Check) {
+	resultChan := make(chan checkResult, 1)
+	started := time.Now().UTC()
+
+	go func() {
+		result, err := check.Command.Run(check.Args)
+		resultChan <- checkResult{result, err}
+	}()
+
+	var result checkResult
+	select {
+	case result = <-resultChan:
+	case <-time.After(m.timeoutFor(check)):
+		log.Errorf("Error, check %s timed out! (%v)", check.ID, check.Args)
+		result = checkResult{UNKNOWN, errors.New("Timed out!")}
+	}
+
+	m.Lock()
+	check.UpdateStatus(result.status, result.err)
+	check.recordResult(started, time.Since(started), result.status, result.err)
+	check.running = false
+	m.Unlock()
+}
+
+// intervalFor returns the interval for a check, or the Monitor's default
+// when the check doesn't have one.
+func (m *Monitor) intervalFor(check *Check) time.Duration {
+	if check.Interval > 0 {
+		return check.Interval
+	}
+
+	return m.CheckInterval
+}
+
+// timeoutFor returns the timeout for a check. By default, and when the
+// check's own timeout is longer than its interval, we time out just before
+// the check would be due to run again, but never sooner than
+// MIN_CHECK_TIMEOUT.
+func (m *Monitor) timeoutFor(check *Check) time.Duration {
+	interval := m.intervalFor(check)
+	if check.Timeout > 0 && check.Timeout <= interval {
+		return check.Timeout
+	}
+
+	timeout := interval - 1*time.Millisecond
+	if timeout < MIN_CHECK_TIMEOUT {
+		return MIN_CHECK_TIMEOUT
+	}
+
+	return timeout
 }
 
 type checkResult struct {
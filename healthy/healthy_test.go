@@ -2,8 +2,10 @@ package healthy
 
 import (
 	"errors"
+	"strings"
 	"testing"
 	"time"
+	"unicode/utf8"
 
 	"github.com/Nitro/sidecar/service"
 	"github.com/relistan/go-director"
@@ -58,7 +60,7 @@ func (m *mockCommand) Run(args string) (int, error) {
 type slowCommand struct{}
 
 func (s *slowCommand) Run(args string) (int, error) {
-	time.Sleep(10 * time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
 	return HEALTHY, nil
 }
 
@@ -73,6 +75,14 @@ func Test_RunningChecks(t *testing.T) {
 		}
 		monitor.AddCheck(check)
 
+		// Each pass of the looper happens a check interval after the last
+		// one, like it does with the timed looper in main
+		now := time.Now().UTC()
+		monitor.nowFunc = func() time.Time {
+			now = now.Add(monitor.CheckInterval)
+			return now
+		}
+
 		looper := director.NewFreeLooper(director.ONCE, nil)
 
 		Convey("The Check Command gets evaluated", func() {
@@ -168,6 +178,133 @@ func Test_RunningChecks(t *testing.T) {
 			So(check.LastError.Error(), ShouldEqual, "Timed out!")
 		})
 
+		Convey("Checks use their own timeout when they have one", func() {
+			check := &Check{
+				ID:       "test",
+				Type:     "mock",
+				Status:   FAILED,
+				Args:     "testing123",
+				Command:  &slowCommand{},
+				MaxCount: 3,
+				Timeout:  1 * time.Millisecond,
+			}
+			monitor.AddCheck(check)
+			monitor.Run(looper)
+
+			So(check.Status, ShouldEqual, UNKNOWN)
+			So(check.LastError.Error(), ShouldEqual, "Timed out!")
+		})
+
+		Convey("Checks are not run again until their interval has passed", func() {
+			cmd := mockCommand{DesiredResult: HEALTHY}
+			check := &Check{
+				ID:       "test",
+				Type:     "mock",
+				Args:     "testing123",
+				Command:  &cmd,
+				Interval: 1 * time.Hour,
+			}
+			monitor.AddCheck(check)
+			monitor.Run(director.NewFreeLooper(3, nil))
+
+			So(cmd.CallCount, ShouldEqual, 1)
+		})
+
+		Convey("Checks run again once their interval has passed", func() {
+			cmd := mockCommand{DesiredResult: HEALTHY}
+			check := &Check{
+				ID:       "test",
+				Type:     "mock",
+				Args:     "testing123",
+				Command:  &cmd,
+				Interval: 10 * time.Second,
+			}
+			monitor.AddCheck(check)
+			monitor.Run(director.NewFreeLooper(5, nil))
+
+			// Passes are 3 seconds apart, so it runs on the 1st and 5th
+			So(cmd.CallCount, ShouldEqual, 2)
+		})
+
+		Convey("Checks time out just before their next run by default", func() {
+			So(monitor.timeoutFor(&Check{}), ShouldEqual, HEALTH_INTERVAL-time.Millisecond)
+			So(monitor.timeoutFor(&Check{Interval: 30 * time.Second}), ShouldEqual, 30*time.Second-time.Millisecond)
+		})
+
+		Convey("Checks with a very short interval still get a usable timeout", func() {
+			So(monitor.timeoutFor(&Check{Interval: time.Millisecond}), ShouldEqual, MIN_CHECK_TIMEOUT)
+			So(monitor.timeoutFor(&Check{Interval: time.Nanosecond}), ShouldEqual, MIN_CHECK_TIMEOUT)
+		})
+
+		Convey("Check timeouts longer than the interval are cut down", func() {
+			check := &Check{Interval: 10 * time.Second, Timeout: 30 * time.Second}
+			So(monitor.timeoutFor(check), ShouldEqual, 10*time.Second-time.Millisecond)
+
+			check.Timeout = 5 * time.Second
+			So(monitor.timeoutFor(check), ShouldEqual, 5*time.Second)
+		})
+
+		Convey("Failed checks must pass Rise times before they are healthy", func() {
+			check := NewCheck("test")
+			check.Status = FAILED
+			check.Rise = 2
+
+			check.UpdateStatus(HEALTHY, nil)
+			So(check.Status, ShouldEqual, FAILED)
+
+			check.UpdateStatus(HEALTHY, nil)
+			So(check.Status, ShouldEqual, HEALTHY)
+		})
+
+		Convey("A failure resets the Rise count", func() {
+			check := NewCheck("test")
+			check.Status = FAILED
+			check.Rise = 2
+			check.MaxCount = 3
+
+			check.UpdateStatus(HEALTHY, nil)
+			check.UpdateStatus(SICKLY, nil)
+			check.UpdateStatus(HEALTHY, nil)
+
+			So(check.SuccessCount, ShouldEqual, 1)
+			So(check.Status, ShouldEqual, SICKLY)
+		})
+
+		Convey("Check failures keep their status and record the reason", func() {
+			check := NewCheck("test")
+			check.MaxCount = 3
+			check.UpdateStatus(SICKLY, &CheckFailure{Reason: "HTTP status 503"})
+
+			So(check.Status, ShouldEqual, SICKLY)
+			So(check.LastError.Error(), ShouldEqual, "HTTP status 503")
+		})
+
+		Convey("Summarizes checks that are not healthy", func() {
+			check := NewCheck("test")
+			check.Type = "HttpGet"
+			check.MaxCount = 3
+			check.UpdateStatus(SICKLY, &CheckFailure{Reason: "HTTP status 503"})
+			check.UpdateStatus(SICKLY, &CheckFailure{Reason: strings.Repeat("x", 500)})
+
+			summary := check.HealthSummary()
+			So(summary.Type, ShouldEqual, "HttpGet")
+			So(summary.FailCount, ShouldEqual, 2)
+			So(len(summary.LastError), ShouldEqual, MAX_SUMMARY_ERROR)
+
+			check.UpdateStatus(HEALTHY, nil)
+			So(check.HealthSummary(), ShouldBeNil)
+		})
+
+		Convey("Summaries don't split a character in a long error", func() {
+			check := NewCheck("test")
+			check.MaxCount = 3
+			check.UpdateStatus(SICKLY, &CheckFailure{Reason: "x" + strings.Repeat("é", 100)})
+
+			summary := check.HealthSummary()
+			So(len(summary.LastError), ShouldEqual, MAX_SUMMARY_ERROR-1)
+			So(utf8.ValidString(summary.LastError), ShouldBeTrue)
+		})
+
 		Convey("Checks that had an error become UNKNOWN on first pass", func() {
 			check := NewCheck("test")
 			check.Command = &slowCommand{}
@@ -256,5 +393,13 @@ func Test_MarkingServices(t *testing.T) {
 		Convey("Transitions services to healthy when they are", func() {
 			So(svcList[4].Status, ShouldEqual, service.ALIVE)
 		})
+
+		Convey("Attaches a health summary to services that are not healthy", func() {
+			So(svcList[0].Health, ShouldBeNil)
+			So(svcList[1].Health, ShouldNotBeNil)
+			So(svcList[1].Health.Type, ShouldEqual, "mock")
+			So(svcList[1].Health.FailCount, ShouldEqual, 1)
+			So(svcList[2].Health, ShouldBeNil)
+		})
 	})
 }
@@ -0,0 +1,162 @@
+package healthy
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	HISTORY_SIZE = 20 // The number of recent results we keep for each check
+)
+
+// A HistoryEntry records the outcome of one run of a Check. Result is what
+// the check itself returned. Status is the status of the Check after the
+// run, once Rise and Fall have been applied, so a failed check that passes
+// once can still be FAILED.
+type HistoryEntry struct {
+	Time    time.Time
+	Latency time.Duration
+	Result  string
+	Status  string
+	Error   string `json:",omitempty"`
+}
+
+// CheckHistory is a fixed size ring buffer of the most recent results for
+// a Check. It is not synchronized; the Monitor's lock protects it.
+type CheckHistory struct {
+	entries []HistoryEntry
+	start   int
+	count   int
+}
+
+// NewCheckHistory returns a CheckHistory that holds up to size entries
+func NewCheckHistory(size int) *CheckHistory {
+	return &CheckHistory{entries: make([]HistoryEntry, size)}
+}
+
+// Add records an entry, replacing the oldest one when the buffer is full
+func (h *CheckHistory) Add(entry HistoryEntry) {
+	if len(h.entries) == 0 {
+		return
+	}
+
+	if h.count < len(h.entries) {
+		h.entries[(h.start+h.count)%len(h.entries)] = entry
+		h.count++
+		return
+	}
+
+	h.entries[h.start] = entry
+	h.start = (h.start + 1) % len(h.entries)
+}
+
+// Entries returns a copy of the entries, oldest first
+func (h *CheckHistory) Entries() []HistoryEntry {
+	if h == nil {
+		return []HistoryEntry{}
+	}
+
+	result := make([]HistoryEntry, h.count)
+	for i := 0; i < h.count; i++ {
+		result[i] = h.entries[(h.start+i)%len(h.entries)]
+	}
+
+	return result
+}
+
+// A CheckStatus is a point in time report on a Check and its recent
+// history. The check Args are left out because they may contain secrets.
+type CheckStatus struct {
+	ID        string
+	Type      string
+	Status    string
+	Count     int
+	LastError string `json:",omitempty"`
+	History   []HistoryEntry
+}
+
+// StatusString returns the name of a check status
+func StatusString(status int) string {
+	switch status {
+	case HEALTHY:
+		return "HEALTHY"
+	case SICKLY:
+		return "SICKLY"
+	case FAILED:
+		return "FAILED"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// recordResult adds the outcome of a run to the check's history. Call it
+// after UpdateStatus().
+// Note: Not synchronized!
+func (check *Check) recordResult(started time.Time, latency time.Duration, status int, err error) {
+	if check.History == nil {
+		check.History = NewCheckHistory(HISTORY_SIZE)
+	}
+
+	// Errors other than CheckFailures mean we don't know how the check went
+	if _, ok := err.(*CheckFailure); err != nil && !ok {
+		status = UNKNOWN
+	}
+
+	entry := HistoryEntry{
+		Time:    started,
+		Latency: latency,
+		Result:  StatusString(status),
+		Status:  StatusString(check.Status),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	check.History.Add(entry)
+}
+
+// report returns a CheckStatus for this check.
+// Note: Not synchronized!
+func (check *Check) report() *CheckStatus {
+	status := &CheckStatus{
+		ID:      check.ID,
+		Type:    check.Type,
+		Status:  StatusString(check.Status),
+		Count:   check.Count,
+		History: check.History.Entries(),
+	}
+	if check.LastError != nil {
+		status.LastError = check.LastError.Error()
+	}
+
+	return status
+}
+
+// CheckStatuses returns a report on all the checks, sorted by ID
+func (m *Monitor) CheckStatuses() []*CheckStatus {
+	m.RLock()
+	defer m.RUnlock()
+
+	statuses := make([]*CheckStatus, 0, len(m.Checks))
+	for _, check := range m.Checks {
+		statuses = append(statuses, check.report())
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].ID < statuses[j].ID })
+
+	return statuses
+}
+
+// CheckStatus returns a report on a single check, or nil if there is no
+// check with that ID.
+func (m *Monitor) CheckStatus(id string) *CheckStatus {
+	m.RLock()
+	defer m.RUnlock()
+
+	check, ok := m.Checks[id]
+	if !ok {
+		return nil
+	}
+
+	return check.report()
+}
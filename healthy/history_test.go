@@ -0,0 +1,163 @@
+package healthy
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	director "github.com/relistan/go-director"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_CheckHistory(t *testing.T) {
+	Convey("CheckHistory", t, func() {
+		history := NewCheckHistory(3)
+
+		Convey("starts out empty", func() {
+			So(history.Entries(), ShouldBeEmpty)
+		})
+
+		Convey("returns entries oldest first", func() {
+			history.Add(HistoryEntry{Status: "HEALTHY"})
+			history.Add(HistoryEntry{Status: "SICKLY"})
+
+			entries := history.Entries()
+			So(len(entries), ShouldEqual, 2)
+			So(entries[0].Status, ShouldEqual, "HEALTHY")
+			So(entries[1].Status, ShouldEqual, "SICKLY")
+		})
+
+		Convey("drops the oldest entries when full", func() {
+			for _, status := range []string{"1", "2", "3", "4", "5"} {
+				history.Add(HistoryEntry{Status: status})
+			}
+
+			entries := history.Entries()
+			So(len(entries), ShouldEqual, 3)
+			So(entries[0].Status, ShouldEqual, "3")
+			So(entries[2].Status, ShouldEqual, "5")
+		})
+
+		Convey("handles a nil history", func() {
+			var empty *CheckHistory
+			So(empty.Entries(), ShouldBeEmpty)
+		})
+	})
+}
+
+func Test_CheckStatuses(t *testing.T) {
+	Convey("Reporting on checks", t, func() {
+		monitor := NewMonitor(hostname, "/")
+		looper := director.NewFreeLooper(director.ONCE, nil)
+
+		// Each pass of the looper happens a check interval after the last one
+		now := time.Now().UTC()
+		monitor.nowFunc = func() time.Time {
+			now = now.Add(monitor.CheckInterval)
+			return now
+		}
+
+		good := &Check{
+			ID:      "beowulf",
+			Type:    "mock",
+			Args:    "secret-args",
+			Command: &mockCommand{DesiredResult: HEALTHY},
+		}
+		bad := &Check{
+			ID:       "grendel",
+			Type:     "mock",
+			Command:  &mockCommand{DesiredResult: SICKLY, Error: errors.New("Uh oh!")},
+			MaxCount: 3,
+		}
+		monitor.AddCheck(bad)
+		monitor.AddCheck(good)
+
+		Convey("records the result of each run", func() {
+			monitor.Run(looper)
+			monitor.Run(looper)
+
+			history := good.History.Entries()
+			So(len(history), ShouldEqual, 2)
+			So(history[0].Result, ShouldEqual, "HEALTHY")
+			So(history[0].Status, ShouldEqual, "HEALTHY")
+			So(history[0].Error, ShouldBeEmpty)
+			So(history[0].Time.IsZero(), ShouldBeFalse)
+
+			history = bad.History.Entries()
+			So(len(history), ShouldEqual, 2)
+			So(history[1].Result, ShouldEqual, "UNKNOWN")
+			So(history[1].Status, ShouldEqual, "UNKNOWN")
+			So(history[1].Error, ShouldEqual, "Uh oh!")
+		})
+
+		Convey("records the status after Rise apart from the result", func() {
+			rising := &Check{
+				ID:       "wiglaf",
+				Type:     "mock",
+				Command:  &mockCommand{DesiredResult: HEALTHY},
+				Status:   FAILED,
+				MaxCount: 1,
+				Rise:     2,
+			}
+			monitor.AddCheck(rising)
+
+			monitor.Run(looper)
+			monitor.Run(looper)
+
+			history := rising.History.Entries()
+			So(len(history), ShouldEqual, 2)
+			So(history[0].Result, ShouldEqual, "HEALTHY")
+			So(history[0].Status, ShouldEqual, "FAILED")
+			So(history[1].Result, ShouldEqual, "HEALTHY")
+			So(history[1].Status, ShouldEqual, "HEALTHY")
+		})
+
+		Convey("records the status after Fall apart from the result", func() {
+			falling := &Check{
+				ID:       "hrothgar",
+				Type:     "mock",
+				Command:  &mockCommand{DesiredResult: SICKLY, Error: &CheckFailure{Reason: "Uh oh!"}},
+				MaxCount: 2,
+			}
+			monitor.AddCheck(falling)
+
+			monitor.Run(looper)
+			monitor.Run(looper)
+
+			history := falling.History.Entries()
+			So(len(history), ShouldEqual, 2)
+			So(history[0].Result, ShouldEqual, "SICKLY")
+			So(history[0].Status, ShouldEqual, "SICKLY")
+			So(history[1].Result, ShouldEqual, "SICKLY")
+			So(history[1].Status, ShouldEqual, "FAILED")
+		})
+
+		Convey("returns all the checks sorted by ID", func() {
+			monitor.Run(looper)
+
+			statuses := monitor.CheckStatuses()
+			So(len(statuses), ShouldEqual, 2)
+			So(statuses[0].ID, ShouldEqual, "beowulf")
+			So(statuses[0].Status, ShouldEqual, "HEALTHY")
+			So(statuses[0].LastError, ShouldBeEmpty)
+			So(statuses[1].ID, ShouldEqual, "grendel")
+			So(statuses[1].LastError, ShouldEqual, "Uh oh!")
+			So(len(statuses[1].History), ShouldEqual, 1)
+		})
+
+		Convey("returns a single check by ID", func() {
+			So(monitor.CheckStatus("beowulf").Type, ShouldEqual, "mock")
+			So(monitor.CheckStatus("beowulf").History, ShouldBeEmpty)
+			So(monitor.CheckStatus("missing"), ShouldBeNil)
+		})
+	})
+}
+
+func Test_StatusString(t *testing.T) {
+	Convey("StatusString names the statuses", t, func() {
+		So(StatusString(HEALTHY), ShouldEqual, "HEALTHY")
+		So(StatusString(SICKLY), ShouldEqual, "SICKLY")
+		So(StatusString(FAILED), ShouldEqual, "FAILED")
+		So(StatusString(UNKNOWN), ShouldEqual, "UNKNOWN")
+	})
+}
@@ -69,16 +69,25 @@ func (m *Monitor) defaultCheckForService(svc *service.Service) *Check {
 	}
 }
 
+// GetCommandNamed returns the Checker for a check type. Types we don't know
+// about get an InvalidCmd, which reports the problem on the check.
 func (m *Monitor) GetCommandNamed(name string) Checker {
 	switch name {
 	case "HttpGet":
 		return &HttpGetCmd{}
+	case "HttpGetUnix":
+		return &HttpGetUnixCmd{}
+	case "TcpConnect":
+		return &TcpConnectCmd{}
+	case "GrpcHealth":
+		return &GrpcHealthCmd{}
 	case "External":
 		return &ExternalCmd{}
 	case "AlwaysSuccessful":
 		return &AlwaysSuccessfulCmd{}
 	default:
-		return &HttpGetCmd{}
+		log.Errorf("Unknown check type '%s'", name)
+		return &InvalidCmd{Err: fmt.Errorf("Unknown check type '%s'", name)}
 	}
 }
 
@@ -88,15 +97,24 @@ func (m *Monitor) fetchCheckForService(svc *service.Service, disco discovery.Dis
 	check := &Check{}
 	check.Type, check.Args = disco.HealthCheck(svc)
 	if check.Type == "" {
-		log.Warnf("Got empty check type for service %s (id: %s) with args: %s!", svc.Name, svc.ID, check.Args)
 		return nil
 	}
 
 	// Setup some other parts of the check that don't come from discovery
 	check.ID = svc.ID
-	check.Command = m.GetCommandNamed(check.Type)
 	check.Status = FAILED
 
+	// Apply any per-check scheduling settings the discoverer knows about
+	if optioner, ok := disco.(discovery.HealthCheckOptioner); ok {
+		opts := optioner.HealthCheckOptions(svc)
+		check.Interval = opts.Interval
+		check.Timeout = opts.Timeout
+		check.Rise = opts.Rise
+		if opts.Fall > 0 {
+			check.MaxCount = opts.Fall
+		}
+	}
+
 	return check
 }
 
@@ -132,6 +150,22 @@ func (m *Monitor) CheckForService(svc *service.Service, disco discovery.Discover
 	check := m.fetchCheckForService(svc, disco)
 	if check == nil { // We got nothing
 		log.Warnf("Using default check for service %s (id: %s).", svc.Name, svc.ID)
+	}
+
+	check = m.buildCheck(svc, check)
+	if check.Command == nil {
+		check.Command = m.GetCommandNamed(check.Type)
+	}
+
+	return check
+}
+
+// buildCheck falls back to the default check when discovery didn't give us
+// one, and templates in the arguments. It leaves the Command for checks from
+// discovery to the caller, and doesn't log, so that Watch can call it on
+// every pass.
+func (m *Monitor) buildCheck(svc *service.Service, check *Check) *Check {
+	if check == nil {
 		check = m.defaultCheckForService(svc)
 	}
 
@@ -140,9 +174,45 @@ func (m *Monitor) CheckForService(svc *service.Service, disco discovery.Discover
 	return check
 }
 
+// sameDefinition tells us whether two checks were configured the same way,
+// ignoring the state that running them builds up.
+func sameDefinition(a *Check, b *Check) bool {
+	return a.Type == b.Type &&
+		a.Args == b.Args &&
+		a.Interval == b.Interval &&
+		a.Timeout == b.Timeout &&
+		a.Rise == b.Rise &&
+		a.MaxCount == b.MaxCount
+}
+
+// checkChanged returns a freshly built check for the service when discovery
+// now describes it differently than the check we are running, and nil when
+// the running check is still current.
+func (m *Monitor) checkChanged(svc *service.Service, disco discovery.Discoverer) *Check {
+	m.RLock()
+	running := m.Checks[svc.ID]
+	m.RUnlock()
+
+	if running == nil {
+		return nil
+	}
+
+	check := m.buildCheck(svc, m.fetchCheckForService(svc, disco))
+	if sameDefinition(running, check) {
+		return nil
+	}
+
+	if check.Command == nil {
+		check.Command = m.GetCommandNamed(check.Type)
+	}
+
+	return check
+}
+
 // Watch loops over a list of services and adds checks for services we don't already
-// know about. It then removes any checks for services which have gone away. All
-// services are expected to be local to this node.
+// know about. Checks whose definition has changed are replaced with new ones. It
+// then removes any checks for services which have gone away. All services are
+// expected to be local to this node.
 func (m *Monitor) Watch(disco discovery.Discoverer, looper director.Looper) {
 	m.DiscoveryFn = disco.Services // Store this so we can use it from Services()
 
@@ -151,7 +221,11 @@ func (m *Monitor) Watch(disco discovery.Discoverer, looper director.Looper) {
 
 		// Add checks when new services are found
 		for _, svc := range services {
-			if m.Checks[svc.ID] == nil {
+			m.RLock()
+			known := m.Checks[svc.ID] != nil
+			m.RUnlock()
+
+			if !known {
 				check := m.CheckForService(&svc, disco)
 				if check.Command == nil {
 					log.Errorf(
@@ -161,6 +235,13 @@ func (m *Monitor) Watch(disco discovery.Discoverer, looper director.Looper) {
 				} else {
 					m.AddCheck(check)
 				}
+				continue
+			}
+
+			// Replace the check when the service's check was reconfigured
+			if check := m.checkChanged(&svc, disco); check != nil {
+				log.Printf("Check for %s (id: %s) changed, replacing it", svc.Name, svc.ID)
+				m.AddCheck(check)
 			}
 		}
 
@@ -1,6 +1,10 @@
 package healthy
 
 import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -29,6 +33,10 @@ func (m *mockDiscoverer) HealthCheck(svc *service.Service) (string, string) {
 		return "HttpGet", "http://{{ host }}:{{ tcp 8081 }}/status/check"
 	}
 
+	if svc.Name == "invalidCheck" {
+		return "Awesome-sauce", "whatever"
+	}
+
 	if svc.Name == "containerCheck" {
 		return "HttpGet", "http://{{ container }}:{{ tcp 8081 }}/status/check"
 	}
@@ -36,6 +44,19 @@ func (m *mockDiscoverer) HealthCheck(svc *service.Service) (string, string) {
 	return "", ""
 }
 
+func (m *mockDiscoverer) HealthCheckOptions(svc *service.Service) discovery.HealthCheckOptions {
+	if svc.Name == "hasCheck" {
+		return discovery.HealthCheckOptions{
+			Interval: 30 * time.Second,
+			Timeout:  10 * time.Second,
+			Rise:     2,
+			Fall:     3,
+		}
+	}
+
+	return discovery.HealthCheckOptions{}
+}
+
 func (m *mockDiscoverer) Run(director.Looper) {}
 
 func Test_ServicesBridge(t *testing.T) {
@@ -193,6 +214,16 @@ func Test_CheckForService(t *testing.T) {
 			So(check.Args, ShouldEqual, "http://indefatigable:1234/status/check")
 		})
 
+		Convey("Applies the check options from the discoverer", func() {
+			monitor := NewMonitor(hostname, "/")
+			service1.Name = "hasCheck"
+			check := monitor.CheckForService(&service1, &mockDiscoverer{})
+			So(check.Interval, ShouldEqual, 30*time.Second)
+			So(check.Timeout, ShouldEqual, 10*time.Second)
+			So(check.Rise, ShouldEqual, 2)
+			So(check.MaxCount, ShouldEqual, 3)
+		})
+
 		Convey("Supports container hostname", func() {
 			monitor := NewMonitor(hostname, "/")
 			service1.Name = "containerCheck"
@@ -224,10 +255,147 @@ func Test_GetCommandNamed(t *testing.T) {
 			)
 		})
 
+		Convey("When asked for a TcpConnect", func() {
+			So(monitor.GetCommandNamed("TcpConnect"), ShouldResemble,
+				&TcpConnectCmd{},
+			)
+		})
+
+		Convey("When asked for a GrpcHealth", func() {
+			So(monitor.GetCommandNamed("GrpcHealth"), ShouldResemble,
+				&GrpcHealthCmd{},
+			)
+		})
+
+		Convey("When asked for an HttpGetUnix", func() {
+			So(monitor.GetCommandNamed("HttpGetUnix"), ShouldResemble,
+				&HttpGetUnixCmd{},
+			)
+		})
+
 		Convey("When asked for an invalid type", func() {
 			So(monitor.GetCommandNamed("Awesome-sauce"), ShouldResemble,
-				&HttpGetCmd{},
+				&InvalidCmd{Err: errors.New("Unknown check type 'Awesome-sauce'")},
 			)
 		})
 	})
+
+	Convey("Checks with an invalid type report an error", t, func() {
+		monitor := NewMonitor("localhost", "/")
+		svc := service.Service{ID: "deadbeef123", Name: "invalidCheck"}
+
+		check := monitor.CheckForService(&svc, &mockDiscoverer{})
+		status, err := check.Command.Run(check.Args)
+
+		So(status, ShouldEqual, UNKNOWN)
+		So(err.Error(), ShouldContainSubstring, "Unknown check type")
+	})
+}
+
+func Test_WatchReloadedChecks(t *testing.T) {
+	Convey("When the static config changes only a service's check", t, func() {
+		dir, err := ioutil.TempDir("", "service-bridge")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		configFile := filepath.Join(dir, "static.json")
+		lastWrite := time.Now().Add(-1 * time.Hour)
+
+		writeConfig := func(args string, interval string) {
+			contents := `[{
+				"Service": {"Name": "heorot", "Ports": [{"Type": "tcp", "Port": 10234}]},
+				"Check": {"Type": "HttpGet", "Args": "` + args + `", "Interval": "` + interval + `"}
+			}]`
+			So(ioutil.WriteFile(configFile, []byte(contents), 0644), ShouldBeNil)
+			lastWrite = lastWrite.Add(1 * time.Second)
+			So(os.Chtimes(configFile, lastWrite, lastWrite), ShouldBeNil)
+		}
+
+		writeConfig("http://{{ host }}:10234/", "5s")
+
+		disco := discovery.NewStaticDiscovery(configFile, "127.0.0.1")
+		disco.Run(director.NewFreeLooper(director.ONCE, nil))
+
+		monitor := NewMonitor(hostname, "/")
+		monitor.Watch(disco, director.NewFreeLooper(director.ONCE, nil))
+
+		So(len(monitor.Checks), ShouldEqual, 1)
+		svcID := disco.Services()[0].ID
+		So(monitor.Checks[svcID].Args, ShouldEqual, "http://indefatigable:10234/")
+		So(monitor.Checks[svcID].Interval, ShouldEqual, 5*time.Second)
+
+		Convey("replaces the check with the new Args and Interval", func() {
+			writeConfig("http://{{ host }}:10234/health", "20s")
+			disco.Run(director.NewFreeLooper(director.ONCE, nil))
+
+			So(disco.Services()[0].ID, ShouldEqual, svcID)
+
+			monitor.Watch(disco, director.NewFreeLooper(director.ONCE, nil))
+
+			So(len(monitor.Checks), ShouldEqual, 1)
+			So(monitor.Checks[svcID].Args, ShouldEqual, "http://indefatigable:10234/health")
+			So(monitor.Checks[svcID].Interval, ShouldEqual, 20*time.Second)
+		})
+
+		Convey("keeps the running check when nothing changed", func() {
+			running := monitor.Checks[svcID]
+			monitor.Watch(disco, director.NewFreeLooper(director.ONCE, nil))
+
+			So(monitor.Checks[svcID], ShouldPointTo, running)
+		})
+	})
+}
+
+func Test_WatchReregisteredChecks(t *testing.T) {
+	Convey("When a service is registered again with a different check", t, func() {
+		disco := discovery.NewApiDiscovery("127.0.0.1")
+		disco.NodeName = hostname
+
+		registration := &discovery.ApiRegistration{
+			Service: service.Service{
+				Name:  "wealhtheow",
+				Ports: []service.Port{{Type: "tcp", Port: 10234, ServicePort: 9999}},
+			},
+			Check: discovery.StaticCheck{
+				Type:     "HttpGet",
+				Args:     "http://{{ host }}:10234/",
+				Interval: "5s",
+			},
+		}
+
+		_, err := disco.Register("deadbeef123", registration)
+		So(err, ShouldBeNil)
+
+		monitor := NewMonitor(hostname, "/")
+		monitor.Watch(disco, director.NewFreeLooper(director.ONCE, nil))
+		So(monitor.Checks["deadbeef123"].Type, ShouldEqual, "HttpGet")
+
+		Convey("replaces the running check", func() {
+			registration.Check = discovery.StaticCheck{
+				Type:     "TcpConnect",
+				Args:     "{{ host }}:10234",
+				Interval: "20s",
+			}
+			_, err := disco.Register("deadbeef123", registration)
+			So(err, ShouldBeNil)
+
+			monitor.Watch(disco, director.NewFreeLooper(director.ONCE, nil))
+
+			check := monitor.Checks["deadbeef123"]
+			So(check.Type, ShouldEqual, "TcpConnect")
+			So(check.Args, ShouldEqual, "indefatigable:10234")
+			So(check.Interval, ShouldEqual, 20*time.Second)
+			So(check.Command, ShouldHaveSameTypeAs, &TcpConnectCmd{})
+		})
+
+		Convey("keeps the running check when only refreshed", func() {
+			running := monitor.Checks["deadbeef123"]
+			_, err := disco.Register("deadbeef123", registration)
+			So(err, ShouldBeNil)
+
+			monitor.Watch(disco, director.NewFreeLooper(director.ONCE, nil))
+
+			So(monitor.Checks["deadbeef123"], ShouldPointTo, running)
+		})
+	})
 }
@@ -0,0 +1,320 @@
+package keyring
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Nitro/memberlist"
+	log "github.com/sirupsen/logrus"
+)
+
+// Key requests are passed between the members of the cluster over
+// memberlist's reliable transport rather than over HTTP. Memberlist encrypts
+// them with the gossip keys, so neither the keys nor the token ever go over
+// the network in the clear. We only pass on changes when gossip encryption
+// is enforced both ways, otherwise a change could go out unencrypted, or be
+// accepted from a peer that doesn't have the keys.
+
+const (
+	KEY_REQUEST_MSG  = 0x83 // Asks a peer to list or change its keys
+	KEY_RESPONSE_MSG = 0x84 // The peer's keys once it has handled the request
+
+	EXCHANGE_TIMEOUT = 5 * time.Second // How long we wait on each peer
+)
+
+// The operations a peer can be asked to do
+const (
+	LIST_KEYS   = "list"
+	INSTALL_KEY = "install"
+	USE_KEY     = "use"
+	REMOVE_KEY  = "remove"
+)
+
+type keyRequest struct {
+	ID    uint64
+	Node  string // Who to send the response to
+	Op    string
+	Key   string `json:",omitempty"`
+	Token string `json:",omitempty"`
+}
+
+// A KeyResponse holds the fingerprints of the keys on one peer, or the
+// error it ran into
+type KeyResponse struct {
+	ID         uint64
+	Node       string
+	PrimaryKey string   `json:",omitempty"`
+	Keys       []string `json:",omitempty"`
+	Error      string   `json:",omitempty"`
+}
+
+// An Exchange passes key requests to the other members of the cluster and
+// handles the ones they send us.
+type Exchange struct {
+	sync.Mutex
+	Manager   *Manager
+	Token     string        // Needed by our peers to change their keys
+	Encrypted bool          // Gossip is encrypted and verified both ways
+	Timeout   time.Duration // How long we wait on each peer
+
+	list    *memberlist.Memberlist
+	lastID  uint64
+	pending map[uint64]chan *KeyResponse
+}
+
+func NewExchange(manager *Manager, token string, encrypted bool) *Exchange {
+	return &Exchange{
+		Manager:   manager,
+		Token:     token,
+		Encrypted: encrypted,
+		Timeout:   EXCHANGE_TIMEOUT,
+		pending:   make(map[uint64]chan *KeyResponse),
+	}
+}
+
+// SetMemberlist gives us the memberlist to send requests over, once it has
+// been created
+func (e *Exchange) SetMemberlist(list *memberlist.Memberlist) {
+	e.Lock()
+	defer e.Unlock()
+	e.list = list
+}
+
+func (e *Exchange) getMemberlist() *memberlist.Memberlist {
+	e.Lock()
+	defer e.Unlock()
+	return e.list
+}
+
+// CanChange tells us whether we may pass key changes on to our peers
+func (e *Exchange) CanChange() bool {
+	return e != nil && e.Encrypted
+}
+
+// Request sends the request to every other member of the cluster and waits
+// for their responses. Peers that we couldn't reach, or that didn't answer
+// in time, get a response with the error.
+func (e *Exchange) Request(op string, key string) []*KeyResponse {
+	if e == nil {
+		return nil
+	}
+
+	list := e.getMemberlist()
+	if list == nil {
+		return nil
+	}
+
+	if op != LIST_KEYS && !e.CanChange() {
+		log.Errorf("Refusing to send key change %q without gossip encryption", op)
+		return nil
+	}
+
+	localName := list.LocalNode().Name
+	var peers []*memberlist.Node
+	for _, node := range list.Members() {
+		if node.Name != localName {
+			peers = append(peers, node)
+		}
+	}
+
+	if len(peers) == 0 {
+		return nil
+	}
+
+	id, responses := e.expect(len(peers))
+	defer e.forget(id)
+
+	request := keyRequest{ID: id, Node: localName, Op: op}
+	if op != LIST_KEYS {
+		request.Key = key
+		request.Token = e.Token
+	}
+	message := encodeMsg(KEY_REQUEST_MSG, &request)
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]*KeyResponse, len(peers))
+
+	for _, node := range peers {
+		wg.Add(1)
+		go func(node *memberlist.Node) {
+			defer wg.Done()
+			err := list.SendReliable(node, message)
+			if err != nil {
+				lock.Lock()
+				results[node.Name] = &KeyResponse{ID: id, Node: node.Name, Error: err.Error()}
+				lock.Unlock()
+			}
+		}(node)
+	}
+	wg.Wait()
+
+	timeout := time.After(e.Timeout)
+WAIT:
+	for len(results) < len(peers) {
+		select {
+		case response := <-responses:
+			if _, ok := results[response.Node]; !ok {
+				results[response.Node] = response
+			}
+		case <-timeout:
+			break WAIT
+		}
+	}
+
+	collected := make([]*KeyResponse, 0, len(peers))
+	for _, node := range peers {
+		response, ok := results[node.Name]
+		if !ok {
+			response = &KeyResponse{ID: id, Node: node.Name, Error: "timed out waiting for a response"}
+		}
+		collected = append(collected, response)
+	}
+
+	return collected
+}
+
+// expect sets up a new request ID and the channel its responses arrive on
+func (e *Exchange) expect(count int) (uint64, chan *KeyResponse) {
+	e.Lock()
+	defer e.Unlock()
+
+	e.lastID++
+	responses := make(chan *KeyResponse, count)
+	e.pending[e.lastID] = responses
+
+	return e.lastID, responses
+}
+
+func (e *Exchange) forget(id uint64) {
+	e.Lock()
+	defer e.Unlock()
+	delete(e.pending, id)
+}
+
+// HandleRequest handles a request from a peer and sends it our response
+func (e *Exchange) HandleRequest(message []byte) {
+	var request keyRequest
+	err := decodeMsg(KEY_REQUEST_MSG, message, &request)
+	if err != nil {
+		log.Errorf("Failed to decode key request: %s", err)
+		return
+	}
+
+	response := e.handle(&request)
+
+	list := e.getMemberlist()
+	if list == nil {
+		return
+	}
+
+	for _, node := range list.Members() {
+		if node.Name != request.Node {
+			continue
+		}
+
+		err := list.SendReliable(node, encodeMsg(KEY_RESPONSE_MSG, response))
+		if err != nil {
+			log.Warnf("Unable to send key response to %s: %s", request.Node, err)
+		}
+		return
+	}
+
+	log.Warnf("Unable to send key response to %s: not a member", request.Node)
+}
+
+// handle checks the request and applies it to our keyring
+func (e *Exchange) handle(request *keyRequest) *KeyResponse {
+	response := &KeyResponse{ID: request.ID}
+	if list := e.getMemberlist(); list != nil {
+		response.Node = list.LocalNode().Name
+	}
+
+	fail := func(format string, args ...interface{}) *KeyResponse {
+		response.Error = fmt.Sprintf(format, args...)
+		return response
+	}
+
+	var change func(string) error
+	switch request.Op {
+	case LIST_KEYS:
+	case INSTALL_KEY:
+		change = e.Manager.Install
+	case USE_KEY:
+		change = e.Manager.Use
+	case REMOVE_KEY:
+		change = e.Manager.Remove
+	default:
+		return fail("unknown operation %q", request.Op)
+	}
+
+	if change != nil {
+		if !e.CanChange() {
+			return fail("refusing key changes, gossip encryption is not enforced on %s", response.Node)
+		}
+
+		if len(e.Token) == 0 {
+			return fail("refusing key changes, SIDECAR_KEYS_TOKEN is not set on %s", response.Node)
+		}
+
+		if subtle.ConstantTimeCompare([]byte(request.Token), []byte(e.Token)) != 1 {
+			return fail("invalid keys token")
+		}
+
+		err := change(request.Key)
+		if err != nil {
+			return fail("%s", err)
+		}
+		log.Warnf("Gossip keyring changed by %s: %s", request.Node, request.Op)
+	}
+
+	response.PrimaryKey, response.Keys = e.Manager.Fingerprints()
+
+	return response
+}
+
+// HandleResponse passes a response from a peer to the request waiting on it
+func (e *Exchange) HandleResponse(message []byte) {
+	var response KeyResponse
+	err := decodeMsg(KEY_RESPONSE_MSG, message, &response)
+	if err != nil {
+		log.Errorf("Failed to decode key response: %s", err)
+		return
+	}
+
+	e.Lock()
+	defer e.Unlock()
+
+	responses, ok := e.pending[response.ID]
+	if !ok {
+		log.Warnf("Got a late key response from %s", response.Node)
+		return
+	}
+
+	select {
+	case responses <- &response:
+	default:
+		log.Warnf("Dropping an unexpected key response from %s", response.Node)
+	}
+}
+
+func encodeMsg(msgType byte, value interface{}) []byte {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		log.Errorf("ERROR: Failed to encode message type %d: %s", msgType, err)
+		return []byte{}
+	}
+
+	return append([]byte{msgType}, encoded...)
+}
+
+func decodeMsg(msgType byte, data []byte, value interface{}) error {
+	if len(data) < 1 || data[0] != msgType {
+		return fmt.Errorf("not a message of type %d", msgType)
+	}
+
+	return json.Unmarshal(data[1:], value)
+}
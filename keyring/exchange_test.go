@@ -0,0 +1,96 @@
+package keyring
+
+import (
+	"testing"
+
+	"github.com/Nitro/memberlist"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Exchange(t *testing.T) {
+	Convey("Exchanging keys with peers", t, func() {
+		first, _ := GenerateKey()
+		second, _ := GenerateKey()
+		firstKey, _ := DecodeKey(first)
+		secondKey, _ := DecodeKey(second)
+
+		ring, err := memberlist.NewKeyring(nil, firstKey)
+		So(err, ShouldBeNil)
+
+		exchange := NewExchange(NewManager(ring, ""), "beowulf", true)
+
+		install := &keyRequest{ID: 12, Node: "heorot", Op: INSTALL_KEY, Key: second, Token: "beowulf"}
+
+		Convey("applies changes with the token", func() {
+			response := exchange.handle(install)
+
+			So(response.Error, ShouldBeEmpty)
+			So(response.ID, ShouldEqual, 12)
+			So(response.Keys, ShouldContain, Fingerprint(secondKey))
+			So(len(ring.GetKeys()), ShouldEqual, 2)
+		})
+
+		Convey("lists the keys without a token", func() {
+			response := exchange.handle(&keyRequest{ID: 13, Node: "heorot", Op: LIST_KEYS})
+
+			So(response.Error, ShouldBeEmpty)
+			So(response.PrimaryKey, ShouldEqual, Fingerprint(firstKey))
+		})
+
+		Convey("refuses changes with the wrong token", func() {
+			install.Token = "grendel"
+			response := exchange.handle(install)
+
+			So(response.Error, ShouldContainSubstring, "invalid keys token")
+			So(len(ring.GetKeys()), ShouldEqual, 1)
+		})
+
+		Convey("refuses changes when we have no token", func() {
+			exchange.Token = ""
+			install.Token = ""
+			response := exchange.handle(install)
+
+			So(response.Error, ShouldContainSubstring, "SIDECAR_KEYS_TOKEN")
+			So(len(ring.GetKeys()), ShouldEqual, 1)
+		})
+
+		Convey("refuses changes when gossip isn't encrypted both ways", func() {
+			exchange.Encrypted = false
+			response := exchange.handle(install)
+
+			So(response.Error, ShouldContainSubstring, "not enforced")
+			So(len(ring.GetKeys()), ShouldEqual, 1)
+		})
+
+		Convey("rejects unknown operations", func() {
+			install.Op = "shred"
+			response := exchange.handle(install)
+
+			So(response.Error, ShouldContainSubstring, "unknown operation")
+		})
+
+		Convey("passes responses to the request waiting on them", func() {
+			id, responses := exchange.expect(1)
+			exchange.HandleResponse(encodeMsg(KEY_RESPONSE_MSG, &KeyResponse{ID: id, Node: "heorot"}))
+
+			So(len(responses), ShouldEqual, 1)
+			So((<-responses).Node, ShouldEqual, "heorot")
+
+			exchange.forget(id)
+			exchange.HandleResponse(encodeMsg(KEY_RESPONSE_MSG, &KeyResponse{ID: id, Node: "heorot"}))
+			So(len(responses), ShouldEqual, 0)
+		})
+
+		Convey("decodes the requests it encodes", func() {
+			var decoded keyRequest
+			So(decodeMsg(KEY_REQUEST_MSG, encodeMsg(KEY_REQUEST_MSG, install), &decoded), ShouldBeNil)
+			So(decoded, ShouldResemble, *install)
+
+			So(decodeMsg(KEY_RESPONSE_MSG, encodeMsg(KEY_REQUEST_MSG, install), &decoded), ShouldNotBeNil)
+		})
+
+		Convey("doesn't send anything without a memberlist", func() {
+			So(exchange.Request(INSTALL_KEY, second), ShouldBeEmpty)
+		})
+	})
+}
@@ -0,0 +1,250 @@
+package keyring
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/Nitro/memberlist"
+	log "github.com/sirupsen/logrus"
+)
+
+// Gossip encryption keys are 16, 24, or 32 bytes for AES-128, AES-192, or
+// AES-256. We pass them around base64 encoded, like Serf and Consul do. The
+// keyring file is a JSON array of encoded keys with the primary key first.
+
+const (
+	GENERATED_KEY_SIZE = 32 // Generate AES-256 keys
+)
+
+// DecodeKey decodes a base64 encoded key and makes sure it is a valid size
+func DecodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key encoding: %s", err)
+	}
+
+	err = memberlist.ValidateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// EncodeKey base64 encodes a key
+func EncodeKey(key []byte) string {
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// GenerateKey returns a new random key, base64 encoded
+func GenerateKey() (string, error) {
+	key := make([]byte, GENERATED_KEY_SIZE)
+	_, err := rand.Read(key)
+	if err != nil {
+		return "", err
+	}
+
+	return EncodeKey(key), nil
+}
+
+// Fingerprint identifies a key without giving it away, so that we can show
+// which keys are installed where
+func Fingerprint(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Load builds the keyring from the keyring file, if there is one, or from
+// the primary key. Once the file has keys in it, it wins over the primary key
+// because it reflects any rotation we have done since. It returns nil when
+// encryption is not configured.
+func Load(primary string, filename string) (*memberlist.Keyring, error) {
+	var keys [][]byte
+
+	if len(filename) > 0 {
+		var err error
+		keys, err = readKeyFile(filename)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(keys) > 0 {
+		if len(primary) > 0 {
+			log.Warnf("Keyring file %s has keys, ignoring the gossip key", filename)
+		}
+		return memberlist.NewKeyring(keys[1:], keys[0])
+	}
+
+	if len(primary) == 0 {
+		return nil, nil
+	}
+
+	key, err := DecodeKey(primary)
+	if err != nil {
+		return nil, err
+	}
+
+	ring, err := memberlist.NewKeyring(nil, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Seed the file so that keys we install later are kept alongside it
+	if len(filename) > 0 {
+		err = writeKeyFile(filename, ring.GetKeys())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ring, nil
+}
+
+func readKeyFile(filename string) ([][]byte, error) {
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var encodedKeys []string
+	err = json.Unmarshal(data, &encodedKeys)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse keyring file %s: %s", filename, err)
+	}
+
+	var keys [][]byte
+	for _, encoded := range encodedKeys {
+		key, err := DecodeKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("bad key in keyring file %s: %s", filename, err)
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// writeKeyFile replaces the keyring file atomically. The keys are secret so
+// the file is only readable by us.
+func writeKeyFile(filename string, keys [][]byte) error {
+	encodedKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		encodedKeys = append(encodedKeys, EncodeKey(key))
+	}
+
+	data, err := json.MarshalIndent(encodedKeys, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name()) // Fails harmlessly after the rename
+
+	_, err = tmpFile.Write(data)
+	if err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	err = tmpFile.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), filename)
+}
+
+// A Manager changes the keys on a running keyring and keeps the keyring file
+// up to date so the changes survive a restart.
+type Manager struct {
+	sync.Mutex
+	Keyring *memberlist.Keyring // nil when encryption is not enabled
+	File    string              // Optional keyring file
+}
+
+func NewManager(ring *memberlist.Keyring, filename string) *Manager {
+	return &Manager{Keyring: ring, File: filename}
+}
+
+// Enabled tells us if gossip encryption is turned on
+func (m *Manager) Enabled() bool {
+	return m != nil && m.Keyring != nil
+}
+
+// Fingerprints returns the fingerprint of the primary key and of all the
+// installed keys, primary first
+func (m *Manager) Fingerprints() (string, []string) {
+	if !m.Enabled() {
+		return "", []string{}
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	keys := m.Keyring.GetKeys()
+	fingerprints := make([]string, 0, len(keys))
+	for _, key := range keys {
+		fingerprints = append(fingerprints, Fingerprint(key))
+	}
+
+	return Fingerprint(m.Keyring.GetPrimaryKey()), fingerprints
+}
+
+// Install adds a key to the keyring. We can decrypt with it right away, but
+// we only encrypt with it once it is made the primary key with Use.
+func (m *Manager) Install(encoded string) error {
+	return m.change(encoded, (*memberlist.Keyring).AddKey)
+}
+
+// Use makes an installed key the primary key
+func (m *Manager) Use(encoded string) error {
+	return m.change(encoded, (*memberlist.Keyring).UseKey)
+}
+
+// Remove takes a key off the keyring. The primary key can't be removed.
+func (m *Manager) Remove(encoded string) error {
+	return m.change(encoded, (*memberlist.Keyring).RemoveKey)
+}
+
+func (m *Manager) change(encoded string, fn func(*memberlist.Keyring, []byte) error) error {
+	if !m.Enabled() {
+		return fmt.Errorf("gossip encryption is not enabled")
+	}
+
+	key, err := DecodeKey(encoded)
+	if err != nil {
+		return err
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	err = fn(m.Keyring, key)
+	if err != nil {
+		return err
+	}
+
+	if len(m.File) > 0 {
+		err = writeKeyFile(m.File, m.Keyring.GetKeys())
+		if err != nil {
+			return fmt.Errorf("keyring changed but unable to save it: %s", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package keyring
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Keys(t *testing.T) {
+	Convey("Working with keys", t, func() {
+		Convey("GenerateKey() returns a valid key", func() {
+			encoded, err := GenerateKey()
+			So(err, ShouldBeNil)
+
+			key, err := DecodeKey(encoded)
+			So(err, ShouldBeNil)
+			So(len(key), ShouldEqual, GENERATED_KEY_SIZE)
+		})
+
+		Convey("DecodeKey() rejects bad keys", func() {
+			_, err := DecodeKey("not base64!")
+			So(err, ShouldNotBeNil)
+
+			_, err = DecodeKey(EncodeKey([]byte("too short")))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Fingerprint() is stable and doesn't give the key away", func() {
+			key := []byte("0123456789abcdef")
+			So(Fingerprint(key), ShouldEqual, Fingerprint(key))
+			So(Fingerprint(key), ShouldNotContainSubstring, EncodeKey(key))
+			So(len(Fingerprint(key)), ShouldEqual, 16)
+		})
+	})
+}
+
+func Test_Keyring(t *testing.T) {
+	Convey("Managing the keyring", t, func() {
+		dir, err := ioutil.TempDir("", "sidecar-keyring")
+		So(err, ShouldBeNil)
+		Reset(func() { os.RemoveAll(dir) })
+
+		filename := filepath.Join(dir, "keyring.json")
+
+		first, _ := GenerateKey()
+		second, _ := GenerateKey()
+		firstKey, _ := DecodeKey(first)
+		secondKey, _ := DecodeKey(second)
+
+		Convey("Load() returns nil without any keys", func() {
+			ring, err := Load("", filename)
+			So(err, ShouldBeNil)
+			So(ring, ShouldBeNil)
+		})
+
+		Convey("Load() seeds the keyring file from the primary key", func() {
+			ring, err := Load(first, filename)
+			So(err, ShouldBeNil)
+			So(ring.GetPrimaryKey(), ShouldResemble, firstKey)
+
+			keys, err := readKeyFile(filename)
+			So(err, ShouldBeNil)
+			So(keys, ShouldResemble, [][]byte{firstKey})
+		})
+
+		Convey("Load() prefers the keyring file over the primary key", func() {
+			So(writeKeyFile(filename, [][]byte{secondKey, firstKey}), ShouldBeNil)
+
+			ring, err := Load(first, filename)
+			So(err, ShouldBeNil)
+			So(ring.GetPrimaryKey(), ShouldResemble, secondKey)
+			So(len(ring.GetKeys()), ShouldEqual, 2)
+		})
+
+		Convey("Load() fails on a bad keyring file", func() {
+			So(ioutil.WriteFile(filename, []byte(`["nope"]`), 0600), ShouldBeNil)
+
+			_, err := Load("", filename)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("The Manager", func() {
+			ring, err := Load(first, filename)
+			So(err, ShouldBeNil)
+			manager := NewManager(ring, filename)
+
+			Convey("rotates keys and saves them", func() {
+				So(manager.Install(second), ShouldBeNil)
+				So(manager.Use(second), ShouldBeNil)
+				So(manager.Remove(first), ShouldBeNil)
+
+				primary, fingerprints := manager.Fingerprints()
+				So(primary, ShouldEqual, Fingerprint(secondKey))
+				So(fingerprints, ShouldResemble, []string{Fingerprint(secondKey)})
+
+				keys, err := readKeyFile(filename)
+				So(err, ShouldBeNil)
+				So(keys, ShouldResemble, [][]byte{secondKey})
+			})
+
+			Convey("won't use a key that isn't installed", func() {
+				So(manager.Use(second), ShouldNotBeNil)
+			})
+
+			Convey("won't remove the primary key", func() {
+				So(manager.Remove(first), ShouldNotBeNil)
+			})
+
+			Convey("refuses changes when encryption is off", func() {
+				manager := NewManager(nil, "")
+				So(manager.Enabled(), ShouldBeFalse)
+				So(manager.Install(second), ShouldNotBeNil)
+			})
+		})
+	})
+}
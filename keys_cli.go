@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Nitro/sidecar/keyring"
+	"github.com/Nitro/sidecar/sidecarhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+// runKeysCommand runs one of the "keys" subcommands against the HTTP API of
+// a running Sidecar, which passes it on to the rest of the cluster
+func runKeysCommand(opts *CliOpts) {
+	var method, path string
+	var body []byte
+
+	switch opts.Command {
+	case "keys generate":
+		key, err := keyring.GenerateKey()
+		exitWithError(err, "Failed to generate a key")
+		fmt.Println(key)
+		return
+	case "keys list":
+		method, path = http.MethodGet, "/api/keys.json"
+	default:
+		op := strings.TrimPrefix(opts.Command, "keys ")
+		method, path = http.MethodPost, "/api/keys/"+op
+
+		var err error
+		body, err = json.Marshal(&sidecarhttp.KeyRequest{Key: *opts.Key})
+		exitWithError(err, "Failed to encode the key")
+	}
+
+	req, err := http.NewRequest(method, "http://"+*opts.KeysAddress+path, bytes.NewReader(body))
+	exitWithError(err, "Failed to build the request")
+	req.Header.Set("Content-Type", "application/json")
+	if len(*opts.KeysToken) > 0 {
+		req.Header.Set("Authorization", "Bearer "+*opts.KeysToken)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	exitWithError(err, "Failed to reach Sidecar")
+	defer resp.Body.Close()
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	exitWithError(err, "Failed to read the response")
+
+	var result sidecarhttp.ClusterKeys
+	err = json.Unmarshal(respBody, &result)
+	if err != nil || (len(result.Nodes) == 0 && len(result.Errors) == 0) {
+		log.Fatalf("Request failed: %s %s", resp.Status, string(respBody))
+	}
+
+	printClusterKeys(&result)
+
+	if resp.StatusCode != 200 {
+		os.Exit(1)
+	}
+}
+
+// printClusterKeys shows how many nodes have each key installed, and how many
+// of those use it as the primary key, followed by any errors
+func printClusterKeys(result *sidecarhttp.ClusterKeys) {
+	installed := make(map[string]int)
+	primary := make(map[string]int)
+	for _, node := range result.Nodes {
+		for _, key := range node.Keys {
+			installed[key]++
+		}
+		primary[node.PrimaryKey]++
+	}
+
+	fingerprints := make([]string, 0, len(installed))
+	for key := range installed {
+		fingerprints = append(fingerprints, key)
+	}
+	sort.Strings(fingerprints)
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(writer, "Key\tInstalled\tPrimary\n")
+	for _, key := range fingerprints {
+		fmt.Fprintf(writer, "%s\t%d/%d\t%d/%d\n",
+			key, installed[key], len(result.Nodes), primary[key], len(result.Nodes),
+		)
+	}
+	writer.Flush()
+
+	if len(result.Errors) > 0 {
+		var nodes []string
+		for node := range result.Errors {
+			nodes = append(nodes, node)
+		}
+		sort.Strings(nodes)
+
+		fmt.Printf("\nErrors from %d nodes:\n", len(nodes))
+		for _, node := range nodes {
+			fmt.Printf("  %s: %s\n", node, result.Errors[node])
+		}
+	}
+}
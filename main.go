@@ -4,8 +4,8 @@ import (
 	"context"
 	"net"
 	"os"
-	"os/signal"
 	"runtime/pprof"
+	"sync"
 	"time"
 
 	"github.com/Nitro/memberlist"
@@ -15,6 +15,10 @@ import (
 	"github.com/Nitro/sidecar/envoy"
 	"github.com/Nitro/sidecar/haproxy"
 	"github.com/Nitro/sidecar/healthy"
+	"github.com/Nitro/sidecar/keyring"
+	"github.com/Nitro/sidecar/members"
+	"github.com/Nitro/sidecar/nodeid"
+	"github.com/Nitro/sidecar/seeds"
 	"github.com/Nitro/sidecar/service"
 	"github.com/Nitro/sidecar/sidecarhttp"
 	"github.com/armon/go-metrics"
@@ -23,6 +27,10 @@ import (
 	"gopkg.in/relistan/rubberneck.v1"
 )
 
+// Version is the version of Sidecar we're running. It is set at build time
+// with -ldflags "-X main.Version=..."
+var Version = "dev"
+
 func announceMembers(list *memberlist.Memberlist, state *catalog.ServicesState) {
 	for {
 		// Ask for members of the cluster
@@ -91,7 +99,7 @@ func configureHAproxy(config *config.Config) *haproxy.HAproxy {
 	return proxy
 }
 
-func configureDiscovery(config *config.Config, publishedIP string) discovery.Discoverer {
+func configureDiscovery(config *config.Config, publishedIP string, nodeName string) *discovery.MultiDiscovery {
 	disco := new(discovery.MultiDiscovery)
 
 	var svcNamer discovery.ServiceNamer
@@ -127,15 +135,19 @@ func configureDiscovery(config *config.Config, publishedIP string) discovery.Dis
 	for _, method := range config.Sidecar.Discovery {
 		switch method {
 		case "docker":
-			disco.Discoverers = append(
-				disco.Discoverers,
-				discovery.NewDockerDiscovery(config.DockerDiscovery.DockerURL, svcNamer, publishedIP),
-			)
+			dockerDisco := discovery.NewDockerDiscovery(config.DockerDiscovery.DockerURL, svcNamer, publishedIP)
+			dockerDisco.TagLabelPrefix = config.DockerDiscovery.TagLabelPrefix
+			dockerDisco.NodeName = nodeName
+			disco.Discoverers = append(disco.Discoverers, dockerDisco)
 		case "static":
-			disco.Discoverers = append(
-				disco.Discoverers,
-				discovery.NewStaticDiscovery(config.StaticDiscovery.ConfigFile, publishedIP),
-			)
+			staticDisco := discovery.NewStaticDiscovery(config.StaticDiscovery.ConfigFile, publishedIP)
+			staticDisco.ConfigDir = config.StaticDiscovery.ConfigDir
+			staticDisco.NodeName = nodeName
+			disco.Discoverers = append(disco.Discoverers, staticDisco)
+		case "api":
+			apiDisco := discovery.NewApiDiscovery(publishedIP)
+			apiDisco.NodeName = nodeName
+			disco.Discoverers = append(disco.Discoverers, apiDisco)
 		default:
 		}
 	}
@@ -158,44 +170,41 @@ func configureMetrics(config *config.Config) {
 // configureDelegate sets up the Memberlist delegate we'll use
 func configureDelegate(state *catalog.ServicesState, config *config.Config) *servicesDelegate {
 	delegate := NewServicesDelegate(state)
-	delegate.Metadata = NodeMetadata{
+	delegate.Metadata = catalog.NodeMetadata{
 		ClusterName: config.Sidecar.ClusterName,
 		State:       "Running",
+		Version:     Version,
+		Region:      config.Sidecar.Region,
+		Zone:        config.Sidecar.Zone,
+		Rack:        config.Sidecar.Rack,
+		Roles:       config.Sidecar.Roles,
+		Labels:      config.Sidecar.Labels,
 	}
+	delegate.DigestSync = config.Sidecar.DigestSync
+	delegate.Members = members.NewTracker(state.Hostname, config.Sidecar.Coordinates)
 
 	delegate.Start()
 
 	return delegate
 }
 
-// configureCpuProfiler sets of the CPU profiler and a signal handler to
-// stop it if we have been told to run the CPU profiler.
-func configureCpuProfiler(opts *CliOpts) {
+// configureCpuProfiler starts the CPU profiler if we have been told to run
+// it, and returns a function that stops it.
+func configureCpuProfiler(opts *CliOpts) func() {
 	if !*opts.CpuProfile {
-		return
+		return func() {}
 	}
 
-	var profilerFile os.File
+	profilerFile, err := os.Create("sidecar.cpu.prof")
+	exitWithError(err, "Can't write profiling file")
+	err = pprof.StartCPUProfile(profilerFile)
+	exitWithError(err, "Can't start the CPU profiler")
+	log.Debug("Profiling!")
 
-	// Capture CTRL-C and stop the CPU profiler
-	sigChannel := make(chan os.Signal, 1)
-	signal.Notify(sigChannel, os.Interrupt)
-	go func() {
-		for sig := range sigChannel {
-			log.Printf("Captured %v, stopping profiler and exiting..", sig)
-			pprof.StopCPUProfile()
-			profilerFile.Close()
-			os.Exit(0)
-		}
-	}()
-
-	// Enable CPU profiling support if requested
-	if *opts.CpuProfile {
-		profilerFile, err := os.Create("sidecar.cpu.prof")
-		exitWithError(err, "Can't write profiling file")
-		err = pprof.StartCPUProfile(profilerFile)
-		exitWithError(err, "Can't start the CPU profiler")
-		log.Debug("Profiling!")
+	return func() {
+		log.Info("Stopping profiler")
+		pprof.StopCPUProfile()
+		profilerFile.Close()
 	}
 }
 
@@ -226,13 +235,30 @@ func configureLoggingFormat(config *config.Config) {
 	}
 }
 
-func configureMemberlist(config *config.Config, state *catalog.ServicesState) *memberlist.Config {
-	delegate := configureDelegate(state, config)
+// configureNodeName works out the name this node goes by in the cluster. It
+// keys our services in the state, and is our memberlist node name.
+func configureNodeName(config *config.Config) string {
+	var id string
+	if len(config.Sidecar.NodeIDFile) > 0 && len(config.Sidecar.Hostname) == 0 {
+		var err error
+		id, err = nodeid.Load(config.Sidecar.NodeIDFile)
+		exitWithError(err, "Failed to load the node ID")
+	}
+
+	nodeName, err := nodeid.Name(config.Sidecar.Hostname, id)
+	exitWithError(err, "Failed to find our hostname")
+
+	return nodeName
+}
 
+func configureMemberlist(config *config.Config, delegate *servicesDelegate, nodeName string) *memberlist.Config {
 	// Use a LAN config but add our delegate
 	mlConfig := memberlist.DefaultLANConfig()
+	mlConfig.Name = nodeName
 	mlConfig.Delegate = delegate
 	mlConfig.Events = delegate
+	mlConfig.Ping = delegate.Members
+	delegate.Members.ProbeInterval = mlConfig.ProbeInterval
 
 	// Set some memberlist settings
 	mlConfig.LogOutput = &LoggingBridge{} // Use logrus as backend for Memberlist
@@ -251,6 +277,17 @@ func configureMemberlist(config *config.Config, state *catalog.ServicesState) *m
 	// Make sure we pass on the cluster name to Memberlist
 	mlConfig.ClusterName = config.Sidecar.ClusterName
 
+	// Encrypt gossip if we have any keys
+	ring, err := keyring.Load(string(config.Sidecar.GossipKey), config.Sidecar.KeyringFile)
+	exitWithError(err, "Failed to load gossip encryption keys")
+	if ring != nil {
+		mlConfig.Keyring = ring
+		mlConfig.GossipVerifyIncoming = config.Sidecar.GossipVerifyIncoming
+		mlConfig.GossipVerifyOutgoing = config.Sidecar.GossipVerifyOutgoing
+	} else {
+		log.Warn("Gossip encryption is not enabled")
+	}
+
 	// Figure out our IP address from the CLI or by inspecting the network interfaces
 	publishedIP, err := getPublishedIP(config.Sidecar.ExcludeIPs, config.Sidecar.AdvertiseIP)
 	exitWithError(err, "Failed to find private IP address")
@@ -272,15 +309,47 @@ func configureListeners(config *config.Config, state *catalog.ServicesState) {
 func main() {
 	config := config.ParseConfig()
 	opts := parseCommandLine()
+
+	if opts.Command != "run" {
+		runKeysCommand(opts)
+		return
+	}
+
 	configureOverrides(config, opts)
-	configureCpuProfiler(opts)
+	stopProfiler := configureCpuProfiler(opts)
 	configureLoggingLevel(config)
 	configureLoggingFormat(config)
 	configureMetrics(config)
 
+	nodeName := configureNodeName(config)
+	log.Infof("Node name: %s", nodeName)
+
 	// Create a new state instance and fire up the processor. We need
 	// this to happen early in the startup.
 	state := catalog.NewServicesState()
+	state.ClusterName = config.Sidecar.ClusterName
+	state.Hostname = nodeName
+
+	// Keep a record of what changed, and maybe write it down too
+	state.Journal = catalog.NewJournal(config.Sidecar.JournalSize)
+	if len(config.Sidecar.JournalFile) > 0 {
+		err := state.Journal.AppendTo(config.Sidecar.JournalFile)
+		exitWithError(err, "Failed to open the journal file")
+	}
+
+	wireFormat, err := service.ParseWireFormat(config.Sidecar.GossipFormat)
+	exitWithError(err, "Invalid gossip format")
+	state.WireFormat = wireFormat
+
+	// Load what we knew before the restart so the proxies don't start
+	// out with an empty config
+	if len(config.Sidecar.SnapshotFile) > 0 {
+		err := state.LoadSnapshot(config.Sidecar.SnapshotFile)
+		if err != nil {
+			log.Warnf("Unable to load state snapshot: %s", err)
+		}
+	}
+
 	svcMsgLooper := director.NewFreeLooper(
 		director.FOREVER, make(chan error),
 	)
@@ -288,28 +357,49 @@ func main() {
 
 	configureListeners(config, state)
 
-	mlConfig := configureMemberlist(config, state)
+	delegate := configureDelegate(state, config)
+	mlConfig := configureMemberlist(config, delegate, nodeName)
+
+	// Key changes are passed to our peers over memberlist, so that they're
+	// encrypted. We only do that when encryption is enforced both ways.
+	keys := keyring.NewManager(mlConfig.Keyring, config.Sidecar.KeyringFile)
+	delegate.Keys = keyring.NewExchange(
+		keys, string(config.Sidecar.KeysToken),
+		mlConfig.Keyring != nil && mlConfig.GossipVerifyIncoming && mlConfig.GossipVerifyOutgoing,
+	)
 
 	printer := rubberneck.NewPrinter(log.Infof, rubberneck.NoAddLineFeed)
 	printer.PrintWithLabel("Sidecar", config)
 
 	list, err := memberlist.Create(mlConfig)
 	exitWithError(err, "Failed to create memberlist")
+	delegate.SetMemberlist(list)
+	delegate.Keys.SetMemberlist(list)
 
 	// Join an existing cluster by specifying at least one known member.
-	_, err = list.Join(config.Sidecar.Seeds)
-	exitWithError(err, "Failed to join cluster")
+	// Unless rejoining is turned off, we keep trying if that fails.
+	resolver := seeds.NewResolver(
+		config.Sidecar.Seeds, config.Sidecar.SeedsSRV, config.Sidecar.SeedsFile, config.Sidecar.BindPort,
+	)
+	rejoiner := seeds.NewRejoiner(list, resolver, config.Sidecar.RejoinInterval)
+	_, err = rejoiner.Join()
+	if err != nil && config.Sidecar.RejoinInterval == 0 {
+		exitWithError(err, "Failed to join cluster")
+	}
+	if err != nil {
+		log.Errorf("Failed to join cluster, will keep trying: %s", err)
+	}
 
 	// Set up a bunch of go-director Loopers to run our
 	// background goroutines
 	servicesLooper := director.NewTimedLooper(
-		director.FOREVER, catalog.ALIVE_SLEEP_INTERVAL, nil,
+		director.FOREVER, catalog.ALIVE_SLEEP_INTERVAL, make(chan error, 1),
 	)
 	tombstoneLooper := director.NewTimedLooper(
-		director.FOREVER, catalog.TOMBSTONE_SLEEP_INTERVAL, nil,
+		director.FOREVER, catalog.TOMBSTONE_SLEEP_INTERVAL, make(chan error, 1),
 	)
 	trackingLooper := director.NewTimedLooper(
-		director.FOREVER, catalog.ALIVE_SLEEP_INTERVAL, nil,
+		director.FOREVER, catalog.ALIVE_SLEEP_INTERVAL, make(chan error, 1),
 	)
 	discoLooper := director.NewTimedLooper(
 		director.FOREVER, discovery.DefaultSleepInterval, make(chan error),
@@ -321,13 +411,10 @@ func main() {
 		director.FOREVER, healthy.WATCH_INTERVAL, make(chan error),
 	)
 	healthLooper := director.NewTimedLooper(
-		director.FOREVER, healthy.HEALTH_INTERVAL, make(chan error),
+		director.FOREVER, healthy.SCHEDULER_INTERVAL, make(chan error),
 	)
 
-	// Register the cluster name with the state object
-	state.ClusterName = config.Sidecar.ClusterName
-
-	disco := configureDiscovery(config, mlConfig.AdvertiseAddr)
+	disco := configureDiscovery(config, mlConfig.AdvertiseAddr, nodeName)
 	go disco.Run(discoLooper)
 
 	// Configure the monitor and use the public address as the default
@@ -366,10 +453,47 @@ func main() {
 	go monitor.Watch(disco, healthWatchLooper)
 	go monitor.Run(healthLooper)
 
-	go sidecarhttp.ServeHttp(list, state, &sidecarhttp.HttpConfig{
-		BindIP:       config.HAproxy.BindIP,
-		UseHostnames: config.HAproxy.UseHostnames,
-	})
+	// These stop on shutdown, before we leave the cluster
+	stopLoopers := []director.Looper{servicesLooper, tombstoneLooper, trackingLooper}
+
+	if config.Sidecar.RejoinInterval > 0 {
+		rejoinLooper := director.NewTimedLooper(
+			director.FOREVER, seeds.REJOIN_TICK, make(chan error, 1),
+		)
+		go rejoiner.Run(rejoinLooper)
+		stopLoopers = append(stopLoopers, rejoinLooper)
+	}
+
+	// The snapshot looper is stopped on shutdown before we tombstone our services
+	var snapshotLooper director.Looper
+	if len(config.Sidecar.SnapshotFile) > 0 {
+		snapshotLooper = director.NewTimedLooper(
+			director.FOREVER, catalog.SNAPSHOT_INTERVAL, make(chan error, 1),
+		)
+		go state.WriteSnapshots(config.Sidecar.SnapshotFile, snapshotLooper)
+	}
+
+	// Services registered over the HTTP API need the API discoverer
+	var registrar *discovery.ApiDiscovery
+	for _, discoverer := range disco.Discoverers {
+		if apiDisco, ok := discoverer.(*discovery.ApiDiscovery); ok {
+			registrar = apiDisco
+		}
+	}
+
+	// Cancelling this stops the HTTP and gRPC servers on shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	var servers sync.WaitGroup
+
+	servers.Add(1)
+	go func() {
+		defer servers.Done()
+		sidecarhttp.ServeHttp(ctx, list, state, monitor, registrar, delegate.Keys, delegate.Members, &sidecarhttp.HttpConfig{
+			BindIP:       config.HAproxy.BindIP,
+			UseHostnames: config.HAproxy.UseHostnames,
+			KeysToken:    string(config.Sidecar.KeysToken),
+		})
+	}()
 
 	if !config.HAproxy.Disable {
 		err := proxy.WriteAndReload(state)
@@ -377,7 +501,6 @@ func main() {
 	}
 
 	if config.Envoy.UseGRPCAPI {
-		ctx := context.Background()
 		envoyServer := envoy.NewServer(ctx, state, config.Envoy)
 		envoyServerLooper := director.NewTimedLooper(
 			director.FOREVER, envoy.LooperUpdateInterval, make(chan error),
@@ -389,8 +512,26 @@ func main() {
 			log.Fatalf("Failed to listen on port %q: %s", config.Envoy.GRPCPort, err)
 		}
 
-		go envoyServer.Run(ctx, envoyServerLooper, grpcListener)
+		servers.Add(1)
+		go func() {
+			defer servers.Done()
+			envoyServer.Run(ctx, envoyServerLooper, grpcListener)
+		}()
 	}
 
-	select {}
+	waitForSignal()
+
+	shutdown := &shutdownSequence{
+		State:        state,
+		List:         list,
+		Delegate:     delegate,
+		GracePeriod:  config.Sidecar.DrainGracePeriod,
+		Loopers:      stopLoopers,
+		Snapshots:    snapshotLooper,
+		SnapshotFile: config.Sidecar.SnapshotFile,
+		Cancel:       cancel,
+		Servers:      &servers,
+		StopProfiler: stopProfiler,
+	}
+	shutdown.Run()
 }
@@ -0,0 +1,165 @@
+package members
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Vivaldi network coordinates, as described in "Vivaldi: A Decentralized
+// Network Coordinate System" by Dabek et al, with the height vector from
+// "Network Coordinates in the Wild" by Ledlie et al. Each node places itself
+// in a Euclidean space so that the distance between two nodes estimates the
+// round trip time between them. Every time we probe a peer, it sends us its
+// coordinate and we move ours a little toward or away from it, depending on
+// whether the coordinates over or underestimated the RTT we measured.
+
+const (
+	COORDINATE_DIMENSIONS = 8      // Dimensions of the Euclidean space
+	VIVALDI_ERROR_MAX     = 1.5    // The error of a brand new coordinate
+	VIVALDI_CE            = 0.25   // How fast the error estimate adapts
+	VIVALDI_CC            = 0.25   // How far each sample moves the coordinate
+	HEIGHT_MIN            = 10e-6  // Smallest height, in seconds
+	ZERO_THRESHOLD        = 1.0e-6 // Distances smaller than this are zero
+)
+
+// A Coordinate is a node's position in the network. Distances are in seconds.
+type Coordinate struct {
+	Vec    []float64
+	Error  float64 // How confident we are in the coordinate, lower is better
+	Height float64 // The node's distance from the core of the network
+}
+
+// NewCoordinate returns a coordinate at the origin, that we have no
+// confidence in yet
+func NewCoordinate() *Coordinate {
+	return &Coordinate{
+		Vec:    make([]float64, COORDINATE_DIMENSIONS),
+		Error:  VIVALDI_ERROR_MAX,
+		Height: HEIGHT_MIN,
+	}
+}
+
+// Clone returns a copy of the coordinate that doesn't share its vector
+func (c *Coordinate) Clone() *Coordinate {
+	vec := make([]float64, len(c.Vec))
+	copy(vec, c.Vec)
+
+	return &Coordinate{Vec: vec, Error: c.Error, Height: c.Height}
+}
+
+// IsValid tells us whether a coordinate we were sent is safe to use
+func (c *Coordinate) IsValid() bool {
+	if len(c.Vec) != COORDINATE_DIMENSIONS {
+		return false
+	}
+
+	for _, component := range c.Vec {
+		if !isFinite(component) {
+			return false
+		}
+	}
+
+	return isFinite(c.Error) && isFinite(c.Height)
+}
+
+// DistanceTo estimates the round trip time to the other coordinate
+func (c *Coordinate) DistanceTo(other *Coordinate) time.Duration {
+	return time.Duration(c.rawDistanceTo(other) * float64(time.Second))
+}
+
+// Update moves the coordinate based on the RTT we measured to the node at
+// the other coordinate
+func (c *Coordinate) Update(other *Coordinate, rtt time.Duration) {
+	rttSeconds := rtt.Seconds()
+	if rttSeconds < ZERO_THRESHOLD {
+		rttSeconds = ZERO_THRESHOLD
+	}
+
+	dist := c.rawDistanceTo(other)
+
+	totalError := c.Error + other.Error
+	if totalError < ZERO_THRESHOLD {
+		totalError = ZERO_THRESHOLD
+	}
+	weight := c.Error / totalError
+
+	wrongness := math.Abs(dist-rttSeconds) / rttSeconds
+	c.Error = VIVALDI_CE*weight*wrongness + c.Error*(1.0-VIVALDI_CE*weight)
+	if c.Error > VIVALDI_ERROR_MAX {
+		c.Error = VIVALDI_ERROR_MAX
+	}
+
+	c.applyForce(VIVALDI_CC*weight*(rttSeconds-dist), other)
+}
+
+// applyForce pushes the coordinate directly away from the other one, or
+// pulls it toward it when the force is negative
+func (c *Coordinate) applyForce(force float64, other *Coordinate) {
+	unit, mag := unitVectorAt(c.Vec, other.Vec)
+	for i := range c.Vec {
+		c.Vec[i] += unit[i] * force
+	}
+
+	if mag > ZERO_THRESHOLD {
+		c.Height = (c.Height+other.Height)*force/mag + c.Height
+	}
+	if c.Height < HEIGHT_MIN {
+		c.Height = HEIGHT_MIN
+	}
+}
+
+func (c *Coordinate) rawDistanceTo(other *Coordinate) float64 {
+	return magnitude(diff(c.Vec, other.Vec)) + c.Height + other.Height
+}
+
+// unitVectorAt returns the unit vector pointing from b to a, and the distance
+// between them. When they are in the same place, it picks a random direction
+// so the two can move apart.
+func unitVectorAt(a []float64, b []float64) ([]float64, float64) {
+	vec := diff(a, b)
+
+	if mag := magnitude(vec); mag > ZERO_THRESHOLD {
+		return scale(vec, 1.0/mag), mag
+	}
+
+	for i := range vec {
+		vec[i] = rand.Float64() - 0.5
+	}
+	if mag := magnitude(vec); mag > ZERO_THRESHOLD {
+		return scale(vec, 1.0/mag), 0.0
+	}
+
+	// Vanishingly unlikely, but any direction will do
+	vec = make([]float64, len(vec))
+	vec[0] = 1.0
+	return vec, 0.0
+}
+
+func diff(a []float64, b []float64) []float64 {
+	result := make([]float64, len(a))
+	for i := range a {
+		result[i] = a[i] - b[i]
+	}
+	return result
+}
+
+func scale(vec []float64, factor float64) []float64 {
+	result := make([]float64, len(vec))
+	for i := range vec {
+		result[i] = vec[i] * factor
+	}
+	return result
+}
+
+func magnitude(vec []float64) float64 {
+	sum := 0.0
+	for _, component := range vec {
+		sum += component * component
+	}
+	return math.Sqrt(sum)
+}
+
+func isFinite(f float64) bool {
+	return !math.IsInf(f, 0) && !math.IsNaN(f)
+}
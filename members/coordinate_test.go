@@ -0,0 +1,68 @@
+package members
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Coordinate(t *testing.T) {
+	Convey("Vivaldi coordinates", t, func() {
+		Convey("converge on the measured RTT", func() {
+			rtt := 10 * time.Millisecond
+			a := NewCoordinate()
+			b := NewCoordinate()
+
+			for i := 0; i < 200; i++ {
+				a.Update(b.Clone(), rtt)
+				b.Update(a.Clone(), rtt)
+			}
+
+			estimate := a.DistanceTo(b)
+			So(math.Abs(float64(estimate-rtt)), ShouldBeLessThan, float64(time.Millisecond))
+			So(a.Error, ShouldBeLessThan, VIVALDI_ERROR_MAX)
+		})
+
+		Convey("move apart when they start in the same place", func() {
+			a := NewCoordinate()
+			a.Update(NewCoordinate(), 5*time.Millisecond)
+
+			So(magnitude(a.Vec), ShouldBeGreaterThan, 0)
+		})
+
+		Convey("are the same distance apart both ways", func() {
+			a := NewCoordinate()
+			a.Vec[0] = 0.003
+			b := NewCoordinate()
+			b.Vec[1] = 0.004
+
+			So(a.DistanceTo(b), ShouldEqual, b.DistanceTo(a))
+		})
+
+		Convey("Clone() doesn't share the vector", func() {
+			a := NewCoordinate()
+			b := a.Clone()
+			b.Vec[0] = 1.0
+
+			So(a.Vec[0], ShouldEqual, 0.0)
+		})
+
+		Convey("IsValid()", func() {
+			So(NewCoordinate().IsValid(), ShouldBeTrue)
+
+			wrongSize := NewCoordinate()
+			wrongSize.Vec = wrongSize.Vec[1:]
+			So(wrongSize.IsValid(), ShouldBeFalse)
+
+			notANumber := NewCoordinate()
+			notANumber.Vec[3] = math.NaN()
+			So(notANumber.IsValid(), ShouldBeFalse)
+
+			infinite := NewCoordinate()
+			infinite.Height = math.Inf(1)
+			So(infinite.IsValid(), ShouldBeFalse)
+		})
+	})
+}
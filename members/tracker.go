@@ -0,0 +1,245 @@
+package members
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Nitro/memberlist"
+	log "github.com/sirupsen/logrus"
+)
+
+// Memberlist only lists the members it thinks are alive or suspect, and
+// doesn't tell us which of those it suspects. The Tracker follows the
+// membership events and the results of memberlist's probes, so that we
+// can tell how each member looks from here, including the ones that left.
+
+const (
+	ALIVE   = "alive"
+	SUSPECT = "suspect"
+	DEAD    = "dead"
+
+	SUSPECT_ROUNDS = 3             // Probe rounds a member can miss before we suspect it
+	DEAD_LIFESPAN  = 1 * time.Hour // How long we remember members that left or died
+)
+
+// A Member is what we know about one member of the cluster
+type Member struct {
+	Node       memberlist.Node
+	State      string
+	LastHeard  time.Time     // The last time it answered a probe, joined, or changed
+	RTT        time.Duration // The round trip time of the last probe, zero if none
+	Coordinate *Coordinate   // Only when coordinates are enabled
+	left       time.Time
+}
+
+type Tracker struct {
+	sync.RWMutex
+	ProbeInterval time.Duration // How often memberlist probes one of the members
+	localName     string
+	coordinate    *Coordinate // Ours, nil unless coordinates are enabled
+	members       map[string]*Member
+	nowFunc       func() time.Time
+}
+
+// NewTracker returns a Tracker for the node called localName. When
+// coordinates is true, the members exchange Vivaldi coordinates on each
+// probe, so we can estimate the RTT to members we haven't probed.
+func NewTracker(localName string, coordinates bool) *Tracker {
+	tracker := &Tracker{
+		ProbeInterval: memberlist.DefaultLANConfig().ProbeInterval,
+		localName:     localName,
+		members:       make(map[string]*Member),
+		nowFunc:       func() time.Time { return time.Now().UTC() },
+	}
+
+	if coordinates {
+		tracker.coordinate = NewCoordinate()
+	}
+
+	return tracker
+}
+
+// NotifyJoin is called by the memberlist delegate when a node joins,
+// including when a node we thought was dead comes back
+func (t *Tracker) NotifyJoin(node *memberlist.Node) {
+	t.Lock()
+	defer t.Unlock()
+
+	member := t.updateNode(node)
+	member.left = time.Time{}
+}
+
+// NotifyLeave is called by the memberlist delegate when a node leaves the
+// cluster or memberlist decides it is dead
+func (t *Tracker) NotifyLeave(node *memberlist.Node) {
+	t.Lock()
+	defer t.Unlock()
+
+	member := t.updateNode(node)
+	member.left = member.LastHeard
+	member.RTT = 0
+
+	t.pruneDead()
+}
+
+// NotifyUpdate is called by the memberlist delegate when a node changes its
+// address or metadata
+func (t *Tracker) NotifyUpdate(node *memberlist.Node) {
+	t.Lock()
+	defer t.Unlock()
+
+	t.updateNode(node)
+}
+
+// AckPayload implements memberlist.PingDelegate. We send our coordinate
+// with each ack when coordinates are enabled.
+func (t *Tracker) AckPayload() []byte {
+	t.RLock()
+	defer t.RUnlock()
+
+	if t.coordinate == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(t.coordinate)
+	if err != nil {
+		log.Errorf("Unable to encode our coordinate: %s", err)
+		return nil
+	}
+
+	return data
+}
+
+// NotifyPingComplete implements memberlist.PingDelegate. Memberlist calls
+// it each time a member answers a probe directly.
+func (t *Tracker) NotifyPingComplete(other *memberlist.Node, rtt time.Duration, payload []byte) {
+	t.Lock()
+	defer t.Unlock()
+
+	member, ok := t.members[other.Name]
+	if !ok || !member.left.IsZero() {
+		member = t.updateNode(other)
+		member.left = time.Time{}
+	}
+
+	member.LastHeard = t.nowFunc()
+	member.RTT = rtt
+
+	// Coordinates are off, or the member doesn't send one
+	if t.coordinate == nil || len(payload) == 0 {
+		return
+	}
+
+	var coord Coordinate
+	err := json.Unmarshal(payload, &coord)
+	if err != nil || !coord.IsValid() {
+		log.Debugf("Ignoring invalid coordinate from %s", other.Name)
+		return
+	}
+
+	member.Coordinate = &coord
+	t.coordinate.Update(&coord, rtt)
+}
+
+// Coordinate returns a copy of our own coordinate, or nil when coordinates
+// are not enabled
+func (t *Tracker) Coordinate() *Coordinate {
+	t.RLock()
+	defer t.RUnlock()
+
+	if t.coordinate == nil {
+		return nil
+	}
+
+	return t.coordinate.Clone()
+}
+
+// Members returns a copy of what we know about each member, sorted by name
+func (t *Tracker) Members() []*Member {
+	t.RLock()
+	defer t.RUnlock()
+
+	now := t.nowFunc()
+	suspectAfter := t.suspectAfter()
+
+	result := make([]*Member, 0, len(t.members))
+	for _, member := range t.members {
+		if !member.left.IsZero() && now.Sub(member.left) > DEAD_LIFESPAN {
+			continue
+		}
+
+		copied := *member
+		copied.Node.Meta = append([]byte(nil), member.Node.Meta...)
+		if member.Coordinate != nil {
+			copied.Coordinate = member.Coordinate.Clone()
+		}
+
+		switch {
+		case !member.left.IsZero():
+			copied.State = DEAD
+		case member.Node.Name == t.localName:
+			copied.State = ALIVE
+			copied.Coordinate = nil
+			if t.coordinate != nil {
+				copied.Coordinate = t.coordinate.Clone()
+			}
+		case now.Sub(member.LastHeard) > suspectAfter:
+			copied.State = SUSPECT
+		default:
+			copied.State = ALIVE
+		}
+
+		result = append(result, &copied)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Node.Name < result[j].Node.Name })
+
+	return result
+}
+
+// updateNode stores the latest version of the node, and returns the member
+// Note: Not synchronized!
+func (t *Tracker) updateNode(node *memberlist.Node) *Member {
+	member, ok := t.members[node.Name]
+	if !ok {
+		member = &Member{}
+		t.members[node.Name] = member
+	}
+
+	member.Node = *node
+	member.Node.Meta = append([]byte(nil), node.Meta...)
+	member.LastHeard = t.nowFunc()
+
+	return member
+}
+
+// suspectAfter is how long a member can go without answering a probe before
+// we suspect it. Memberlist probes one member at a time, so the more members
+// there are, the longer it takes to get around to each one.
+// Note: Not synchronized!
+func (t *Tracker) suspectAfter() time.Duration {
+	alive := 0
+	for _, member := range t.members {
+		if member.left.IsZero() {
+			alive++
+		}
+	}
+	if alive < 1 {
+		alive = 1
+	}
+
+	return time.Duration(SUSPECT_ROUNDS*alive) * t.ProbeInterval
+}
+
+// pruneDead forgets about members that left a long time ago
+// Note: Not synchronized!
+func (t *Tracker) pruneDead() {
+	now := t.nowFunc()
+	for name, member := range t.members {
+		if !member.left.IsZero() && now.Sub(member.left) > DEAD_LIFESPAN {
+			delete(t.members, name)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package members
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Nitro/memberlist"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Tracker(t *testing.T) {
+	Convey("The member tracker", t, func() {
+		baseTime := time.Now().UTC()
+		now := baseTime
+
+		tracker := NewTracker("chaucer", false)
+		tracker.ProbeInterval = time.Second
+		tracker.nowFunc = func() time.Time { return now }
+
+		local := &memberlist.Node{Name: "chaucer", Addr: net.ParseIP("10.0.0.1"), Port: 7946}
+		remote := &memberlist.Node{
+			Name: "shakespeare", Addr: net.ParseIP("10.0.0.2"), Port: 7946, Meta: []byte(`{}`),
+		}
+
+		tracker.NotifyJoin(local)
+		tracker.NotifyJoin(remote)
+
+		Convey("lists the members that joined, by name", func() {
+			members := tracker.Members()
+			So(len(members), ShouldEqual, 2)
+			So(members[0].Node.Name, ShouldEqual, "chaucer")
+			So(members[1].Node.Name, ShouldEqual, "shakespeare")
+			So(members[1].State, ShouldEqual, ALIVE)
+			So(members[1].LastHeard, ShouldEqual, baseTime)
+			So(members[1].Node.Addr.String(), ShouldEqual, "10.0.0.2")
+		})
+
+		Convey("records the RTT of each probe", func() {
+			now = baseTime.Add(time.Second)
+			tracker.NotifyPingComplete(remote, 3*time.Millisecond, nil)
+
+			member := findMember(tracker, "shakespeare")
+			So(member.RTT, ShouldEqual, 3*time.Millisecond)
+			So(member.LastHeard, ShouldEqual, now)
+			So(member.Coordinate, ShouldBeNil)
+		})
+
+		Convey("suspects members that stop answering probes", func() {
+			now = baseTime.Add(SUSPECT_ROUNDS * 2 * time.Second)
+			So(findMember(tracker, "shakespeare").State, ShouldEqual, ALIVE)
+
+			now = now.Add(time.Millisecond)
+			So(findMember(tracker, "shakespeare").State, ShouldEqual, SUSPECT)
+
+			tracker.NotifyPingComplete(remote, time.Millisecond, nil)
+			So(findMember(tracker, "shakespeare").State, ShouldEqual, ALIVE)
+		})
+
+		Convey("never suspects the local node", func() {
+			now = baseTime.Add(time.Hour)
+			So(findMember(tracker, "chaucer").State, ShouldEqual, ALIVE)
+		})
+
+		Convey("remembers members that left for a while", func() {
+			tracker.NotifyLeave(remote)
+			So(findMember(tracker, "shakespeare").State, ShouldEqual, DEAD)
+
+			now = baseTime.Add(DEAD_LIFESPAN + time.Second)
+			So(findMember(tracker, "shakespeare"), ShouldBeNil)
+		})
+
+		Convey("brings members back when they rejoin", func() {
+			tracker.NotifyLeave(remote)
+			tracker.NotifyJoin(remote)
+			So(findMember(tracker, "shakespeare").State, ShouldEqual, ALIVE)
+		})
+
+		Convey("keeps a copy of the node", func() {
+			remote.Meta[0] = 'x'
+			So(string(findMember(tracker, "shakespeare").Node.Meta), ShouldEqual, "{}")
+		})
+
+		Convey("without coordinates", func() {
+			So(tracker.AckPayload(), ShouldBeNil)
+			So(tracker.Coordinate(), ShouldBeNil)
+		})
+
+		Convey("with coordinates", func() {
+			tracker := NewTracker("chaucer", true)
+			tracker.NotifyJoin(local)
+			tracker.NotifyJoin(remote)
+
+			Convey("sends our coordinate with each ack", func() {
+				var coord Coordinate
+				err := json.Unmarshal(tracker.AckPayload(), &coord)
+				So(err, ShouldBeNil)
+				So(coord.IsValid(), ShouldBeTrue)
+			})
+
+			Convey("moves our coordinate with each probe", func() {
+				theirs := NewCoordinate()
+				theirs.Vec[0] = 0.01
+				payload, _ := json.Marshal(theirs)
+
+				tracker.NotifyPingComplete(remote, 20*time.Millisecond, payload)
+
+				So(tracker.Coordinate().Vec, ShouldNotResemble, NewCoordinate().Vec)
+				So(findMember(tracker, "shakespeare").Coordinate, ShouldResemble, theirs)
+			})
+
+			Convey("ignores invalid coordinates", func() {
+				tracker.NotifyPingComplete(remote, 20*time.Millisecond, []byte(`{"Vec": [1]}`))
+				So(tracker.Coordinate(), ShouldResemble, NewCoordinate())
+			})
+		})
+	})
+}
+
+func findMember(tracker *Tracker, name string) *Member {
+	for _, member := range tracker.Members() {
+		if member.Node.Name == name {
+			return member
+		}
+	}
+	return nil
+}
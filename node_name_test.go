@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Nitro/sidecar/config"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_configureNodeName(t *testing.T) {
+	Convey("Working out the node name", t, func() {
+		dir, err := ioutil.TempDir("", "sidecar-node-name")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		idFile := filepath.Join(dir, "node-id")
+		So(ioutil.WriteFile(idFile, []byte("deadbeef1234\n"), 0644), ShouldBeNil)
+
+		hostname, err := os.Hostname()
+		So(err, ShouldBeNil)
+
+		// Restore whatever the environment had once we're done
+		for _, name := range []string{"HOSTNAME", "SIDECAR_HOSTNAME", "SIDECAR_NODE_ID_FILE"} {
+			name := name
+			value, ok := os.LookupEnv(name)
+			Reset(func() {
+				if ok {
+					os.Setenv(name, value)
+				} else {
+					os.Unsetenv(name)
+				}
+			})
+		}
+
+		os.Setenv("SIDECAR_NODE_ID_FILE", idFile)
+		os.Unsetenv("SIDECAR_HOSTNAME")
+
+		Convey("uses the node ID when Docker sets HOSTNAME", func() {
+			os.Setenv("HOSTNAME", "0123456789ab")
+
+			So(configureNodeName(config.ParseConfig()), ShouldEqual, hostname+"-deadbeef1234")
+		})
+
+		Convey("uses SIDECAR_HOSTNAME when it is set", func() {
+			os.Setenv("SIDECAR_HOSTNAME", "chaucer")
+
+			So(configureNodeName(config.ParseConfig()), ShouldEqual, "chaucer")
+		})
+	})
+}
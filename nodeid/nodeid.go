@@ -0,0 +1,103 @@
+package nodeid
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Every node in the cluster goes by a name, which keys its services in the
+// state and is its memberlist node name. By default that is the hostname,
+// but hostnames are not always unique: containers and cloned VMs often share
+// them. The node ID tells those nodes apart. It is generated the first time
+// we start, and stored on disk so that we keep the same name across restarts.
+
+const (
+	ID_SIZE = 6 // Bytes of randomness in a node ID, 12 hex characters
+)
+
+// GenerateID returns a new random node ID
+func GenerateID() (string, error) {
+	id := make([]byte, ID_SIZE)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(id), nil
+}
+
+// Load returns the node ID stored in the file. The first time, when there
+// is no file, it generates an ID and stores it there.
+func Load(filename string) (string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err == nil {
+		id := strings.TrimSpace(string(data))
+		if len(id) == 0 {
+			return "", fmt.Errorf("node ID file %s is empty", filename)
+		}
+		return id, nil
+	}
+
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	id, err := GenerateID()
+	if err != nil {
+		return "", err
+	}
+
+	err = writeIDFile(filename, id)
+	if err != nil {
+		return "", fmt.Errorf("unable to store node ID in %s: %s", filename, err)
+	}
+
+	return id, nil
+}
+
+// Name returns the name this node goes by. An override wins, otherwise it is
+// the hostname, followed by the node ID when we have one.
+func Name(override string, id string) (string, error) {
+	if len(override) > 0 {
+		return override, nil
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+
+	if len(id) == 0 {
+		return hostname, nil
+	}
+
+	return hostname + "-" + id, nil
+}
+
+// writeIDFile writes the file atomically, so a crash can't leave us with a
+// partial ID that would change our name on the next start
+func writeIDFile(filename string, id string) error {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name()) // Fails harmlessly after the rename
+
+	_, err = tmpFile.WriteString(id + "\n")
+	if err != nil {
+		tmpFile.Close()
+		return err
+	}
+
+	err = tmpFile.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), filename)
+}
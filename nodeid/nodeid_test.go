@@ -0,0 +1,80 @@
+package nodeid
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_NodeID(t *testing.T) {
+	Convey("Working with node IDs", t, func() {
+		dir, err := ioutil.TempDir("", "sidecar-nodeid")
+		So(err, ShouldBeNil)
+		Reset(func() { os.RemoveAll(dir) })
+
+		filename := filepath.Join(dir, "node-id")
+
+		Convey("Load() generates an ID the first time and keeps it", func() {
+			id, err := Load(filename)
+			So(err, ShouldBeNil)
+			So(len(id), ShouldEqual, ID_SIZE*2)
+
+			again, err := Load(filename)
+			So(err, ShouldBeNil)
+			So(again, ShouldEqual, id)
+		})
+
+		Convey("Load() uses an ID that someone else wrote", func() {
+			err := ioutil.WriteFile(filename, []byte("  beowulf\n"), 0644)
+			So(err, ShouldBeNil)
+
+			id, err := Load(filename)
+			So(err, ShouldBeNil)
+			So(id, ShouldEqual, "beowulf")
+		})
+
+		Convey("Load() rejects an empty file", func() {
+			err := ioutil.WriteFile(filename, []byte("\n"), 0644)
+			So(err, ShouldBeNil)
+
+			_, err = Load(filename)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Load() returns an error when it can't store the ID", func() {
+			_, err := Load(filepath.Join(dir, "missing", "node-id"))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("GenerateID() doesn't repeat itself", func() {
+			id1, _ := GenerateID()
+			id2, _ := GenerateID()
+			So(id1, ShouldNotEqual, id2)
+		})
+
+		Convey("Name()", func() {
+			hostname, _ := os.Hostname()
+
+			Convey("prefers the override", func() {
+				name, err := Name("chaucer", "abba123")
+				So(err, ShouldBeNil)
+				So(name, ShouldEqual, "chaucer")
+			})
+
+			Convey("adds the ID to the hostname", func() {
+				name, err := Name("", "abba123")
+				So(err, ShouldBeNil)
+				So(name, ShouldEqual, hostname+"-abba123")
+			})
+
+			Convey("is just the hostname without an ID", func() {
+				name, err := Name("", "")
+				So(err, ShouldBeNil)
+				So(name, ShouldEqual, hostname)
+			})
+		})
+	})
+}
@@ -0,0 +1,163 @@
+package seeds
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/Nitro/memberlist"
+	"github.com/armon/go-metrics"
+	"github.com/relistan/go-director"
+	log "github.com/sirupsen/logrus"
+)
+
+// Memberlist only joins the seeds once, when we start. If the cluster splits,
+// or we start before any of the seeds are reachable, nothing brings the two
+// sides back together. So we keep checking that we are connected to at least
+// one of the seeds, and join them again when we aren't. Seeds can't tell
+// whether they are on the right side of a split, so they check the other
+// seeds too.
+
+const (
+	REJOIN_TICK = 1 * time.Second // How often the looper wakes us up
+	MIN_BACKOFF = 1 * time.Second // The first retry after a failed rejoin
+	MAX_BACKOFF = 2 * time.Minute // The longest we wait between retries
+)
+
+// The parts of the memberlist that we use
+type cluster interface {
+	Join(existing []string) (int, error)
+	Members() []*memberlist.Node
+	LocalNode() *memberlist.Node
+}
+
+type Rejoiner struct {
+	Interval    time.Duration // How often we check the seeds when all is well
+	resolver    *Resolver
+	list        cluster
+	partitioned bool
+	backoff     time.Duration
+	nowFunc     func() time.Time
+}
+
+func NewRejoiner(list cluster, resolver *Resolver, interval time.Duration) *Rejoiner {
+	return &Rejoiner{
+		Interval: interval,
+		resolver: resolver,
+		list:     list,
+		nowFunc:  time.Now,
+	}
+}
+
+// Join joins the cluster through all of the seeds. It is not an error to
+// have no seeds, that's how the first node of a cluster starts.
+func (r *Rejoiner) Join() (int, error) {
+	seeds := r.otherSeeds()
+	if len(seeds) == 0 {
+		return 0, nil
+	}
+
+	return r.list.Join(seeds)
+}
+
+// Check makes sure we are connected to at least one of the seeds, and tries
+// to join them again if we are not. It returns how long to wait before the
+// next check.
+func (r *Rejoiner) Check() time.Duration {
+	seeds := r.otherSeeds()
+	if len(seeds) == 0 || r.connectedTo(seeds) {
+		if r.partitioned {
+			log.Info("Connected to the seeds again, partition healed")
+			r.healed()
+		}
+		return r.Interval
+	}
+
+	if !r.partitioned {
+		log.Warnf("Partition detected: none of the %d seeds are members of the cluster, rejoining them",
+			len(seeds),
+		)
+		metrics.IncrCounter([]string{"rejoin", "partitions"}, 1)
+		metrics.SetGauge([]string{"rejoin", "partitioned"}, 1)
+		r.partitioned = true
+	}
+
+	joined, err := r.list.Join(seeds)
+	if err != nil {
+		metrics.IncrCounter([]string{"rejoin", "failures"}, 1)
+		r.backoff = r.nextBackoff()
+		log.Warnf("Unable to rejoin the cluster, retrying in %s: %s", r.backoff, err)
+		return r.backoff
+	}
+
+	log.Infof("Rejoined the cluster through %d seeds", joined)
+	r.healed()
+
+	return r.Interval
+}
+
+// Run checks the seeds until the looper is stopped
+func (r *Rejoiner) Run(looper director.Looper) {
+	next := r.nowFunc().Add(r.Interval)
+
+	looper.Loop(func() error {
+		if r.nowFunc().Before(next) {
+			return nil
+		}
+
+		next = r.nowFunc().Add(r.Check())
+		return nil
+	})
+}
+
+func (r *Rejoiner) healed() {
+	metrics.SetGauge([]string{"rejoin", "partitioned"}, 0)
+	r.partitioned = false
+	r.backoff = 0
+}
+
+func (r *Rejoiner) nextBackoff() time.Duration {
+	if r.backoff < MIN_BACKOFF {
+		return MIN_BACKOFF
+	}
+
+	if r.backoff*2 > MAX_BACKOFF {
+		return MAX_BACKOFF
+	}
+
+	return r.backoff * 2
+}
+
+// otherSeeds returns the addresses of the seeds, leaving out our own
+func (r *Rejoiner) otherSeeds() []string {
+	ourAddr := nodeAddr(r.list.LocalNode())
+
+	var seeds []string
+	for _, addr := range r.resolver.Resolve() {
+		if addr != ourAddr {
+			seeds = append(seeds, addr)
+		}
+	}
+
+	return seeds
+}
+
+// connectedTo tells us whether any of the seeds are members of the cluster
+func (r *Rejoiner) connectedTo(seeds []string) bool {
+	members := make(map[string]bool)
+	for _, node := range r.list.Members() {
+		members[nodeAddr(node)] = true
+	}
+
+	for _, addr := range seeds {
+		if members[addr] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func nodeAddr(node *memberlist.Node) string {
+	return net.JoinHostPort(node.Addr.String(), strconv.Itoa(int(node.Port)))
+}
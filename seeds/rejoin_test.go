@@ -0,0 +1,95 @@
+package seeds
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Nitro/memberlist"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type mockCluster struct {
+	local   *memberlist.Node
+	members []*memberlist.Node
+	joined  [][]string
+	joinErr error
+}
+
+func (m *mockCluster) Join(existing []string) (int, error) {
+	m.joined = append(m.joined, existing)
+	if m.joinErr != nil {
+		return 0, m.joinErr
+	}
+	return len(existing), nil
+}
+
+func (m *mockCluster) Members() []*memberlist.Node {
+	return append([]*memberlist.Node{m.local}, m.members...)
+}
+
+func (m *mockCluster) LocalNode() *memberlist.Node {
+	return m.local
+}
+
+func Test_Rejoiner(t *testing.T) {
+	Convey("The rejoiner", t, func() {
+		list := &mockCluster{
+			local: &memberlist.Node{Name: "chaucer", Addr: net.ParseIP("10.0.0.1"), Port: 7946},
+		}
+		resolver := NewResolver([]string{"10.0.0.1", "10.0.0.2"}, nil, "", 7946)
+		rejoiner := NewRejoiner(list, resolver, 30*time.Second)
+
+		seed := &memberlist.Node{Name: "shakespeare", Addr: net.ParseIP("10.0.0.2"), Port: 7946}
+
+		Convey("joins all the seeds except us", func() {
+			_, err := rejoiner.Join()
+			So(err, ShouldBeNil)
+			So(list.joined, ShouldResemble, [][]string{{"10.0.0.2:7946"}})
+		})
+
+		Convey("doesn't join when we are the only seed", func() {
+			resolver.Seeds = []string{"10.0.0.1"}
+
+			_, err := rejoiner.Join()
+			So(err, ShouldBeNil)
+			So(rejoiner.Check(), ShouldEqual, 30*time.Second)
+			So(list.joined, ShouldBeEmpty)
+		})
+
+		Convey("does nothing while a seed is a member", func() {
+			list.members = []*memberlist.Node{seed}
+
+			So(rejoiner.Check(), ShouldEqual, 30*time.Second)
+			So(list.joined, ShouldBeEmpty)
+			So(rejoiner.partitioned, ShouldBeFalse)
+		})
+
+		Convey("rejoins the seeds when none of them are members", func() {
+			So(rejoiner.Check(), ShouldEqual, 30*time.Second)
+			So(list.joined, ShouldResemble, [][]string{{"10.0.0.2:7946"}})
+			So(rejoiner.partitioned, ShouldBeFalse)
+		})
+
+		Convey("backs off while it can't rejoin", func() {
+			list.joinErr = errors.New("connection refused")
+
+			So(rejoiner.Check(), ShouldEqual, MIN_BACKOFF)
+			So(rejoiner.partitioned, ShouldBeTrue)
+			So(rejoiner.Check(), ShouldEqual, 2*MIN_BACKOFF)
+			So(rejoiner.Check(), ShouldEqual, 4*MIN_BACKOFF)
+
+			rejoiner.backoff = MAX_BACKOFF
+			So(rejoiner.Check(), ShouldEqual, MAX_BACKOFF)
+
+			Convey("and goes back to normal when the partition heals", func() {
+				list.members = []*memberlist.Node{seed}
+
+				So(rejoiner.Check(), ShouldEqual, 30*time.Second)
+				So(rejoiner.partitioned, ShouldBeFalse)
+				So(rejoiner.backoff, ShouldEqual, 0)
+			})
+		})
+	})
+}
@@ -0,0 +1,168 @@
+package seeds
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// The seeds are the addresses a node uses to join the cluster. They can be
+// IP addresses or hostnames, from the config or a file, and SRV records.
+// Hostnames and SRV records are looked up each time we need the seeds, so
+// that we follow changes in DNS.
+
+// A Resolver turns the seeds into the addresses of the nodes behind them
+// Note: Not synchronized!
+type Resolver struct {
+	Seeds       []string // IPs or hostnames, with an optional port
+	SRVNames    []string // SRV records, e.g. _sidecar._tcp.example.com
+	File        string   // A file with one seed per line
+	DefaultPort int      // Used for seeds without a port
+
+	fileSeeds   []string
+	fileModTime time.Time
+
+	lookupIP  func(host string) ([]net.IP, error)
+	lookupSRV func(name string) ([]*net.SRV, error)
+}
+
+func NewResolver(seeds []string, srvNames []string, file string, defaultPort int) *Resolver {
+	return &Resolver{
+		Seeds:       seeds,
+		SRVNames:    srvNames,
+		File:        file,
+		DefaultPort: defaultPort,
+		lookupIP:    net.LookupIP,
+		lookupSRV: func(name string) ([]*net.SRV, error) {
+			_, addrs, err := net.LookupSRV("", "", name)
+			return addrs, err
+		},
+	}
+}
+
+// Resolve returns the addresses of all the seeds, as ip:port. Seeds that
+// don't resolve are logged and left out.
+func (r *Resolver) Resolve() []string {
+	var addrs []string
+	seen := make(map[string]bool)
+
+	add := func(ip net.IP, port int) {
+		addr := net.JoinHostPort(ip.String(), strconv.Itoa(port))
+		if !seen[addr] {
+			seen[addr] = true
+			addrs = append(addrs, addr)
+		}
+	}
+
+	allSeeds := append(append([]string{}, r.Seeds...), r.readFile()...)
+	for _, seed := range allSeeds {
+		host, port, err := r.splitSeed(seed)
+		if err != nil {
+			log.Warnf("Invalid seed %s: %s", seed, err)
+			continue
+		}
+
+		for _, ip := range r.lookupHost(host) {
+			add(ip, port)
+		}
+	}
+
+	for _, name := range r.SRVNames {
+		records, err := r.lookupSRV(name)
+		if err != nil {
+			log.Warnf("Unable to look up seed SRV record %s: %s", name, err)
+			continue
+		}
+
+		for _, record := range records {
+			for _, ip := range r.lookupHost(record.Target) {
+				add(ip, int(record.Port))
+			}
+		}
+	}
+
+	return addrs
+}
+
+// splitSeed returns the host and port of the seed, using the default port
+// when it doesn't have one
+func (r *Resolver) splitSeed(seed string) (string, int, error) {
+	host, portStr, err := net.SplitHostPort(seed)
+	if err != nil {
+		// No port, which is fine. Unwrap IPv6 addresses like [::1].
+		return strings.Trim(seed, "[]"), r.DefaultPort, nil
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return host, port, nil
+}
+
+func (r *Resolver) lookupHost(host string) []net.IP {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}
+	}
+
+	ips, err := r.lookupIP(strings.TrimSuffix(host, "."))
+	if err != nil {
+		log.Warnf("Unable to look up seed %s: %s", host, err)
+		return nil
+	}
+
+	return ips
+}
+
+// readFile returns the seeds in the seeds file. It only reads the file again
+// when it has changed. When it can't be read, we keep using what we read
+// last time.
+func (r *Resolver) readFile() []string {
+	if len(r.File) == 0 {
+		return nil
+	}
+
+	info, err := os.Stat(r.File)
+	if err != nil {
+		log.Warnf("Unable to read seeds file %s: %s", r.File, err)
+		return r.fileSeeds
+	}
+
+	if info.ModTime().Equal(r.fileModTime) {
+		return r.fileSeeds
+	}
+
+	file, err := os.Open(r.File)
+	if err != nil {
+		log.Warnf("Unable to read seeds file %s: %s", r.File, err)
+		return r.fileSeeds
+	}
+	defer file.Close()
+
+	var fileSeeds []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fileSeeds = append(fileSeeds, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		log.Warnf("Unable to read seeds file %s: %s", r.File, err)
+		return r.fileSeeds
+	}
+
+	log.Infof("Loaded %d seeds from %s", len(fileSeeds), r.File)
+	r.fileSeeds = fileSeeds
+	r.fileModTime = info.ModTime()
+
+	return r.fileSeeds
+}
@@ -0,0 +1,97 @@
+package seeds
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Resolver(t *testing.T) {
+	Convey("Resolving seeds", t, func() {
+		resolver := NewResolver(nil, nil, "", 7946)
+		resolver.lookupIP = func(host string) ([]net.IP, error) {
+			switch host {
+			case "seeds.example.com":
+				return []net.IP{net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.3")}, nil
+			case "web1.example.com":
+				return []net.IP{net.ParseIP("10.0.1.1")}, nil
+			}
+			return nil, errors.New("no such host")
+		}
+		resolver.lookupSRV = func(name string) ([]*net.SRV, error) {
+			if name == "_sidecar._tcp.example.com" {
+				return []*net.SRV{{Target: "web1.example.com.", Port: 8000}}, nil
+			}
+			return nil, errors.New("no such host")
+		}
+
+		Convey("uses IPs as they are, with the default port", func() {
+			resolver.Seeds = []string{"10.0.0.1", "10.0.0.9:8000", "::1"}
+			So(resolver.Resolve(), ShouldResemble, []string{"10.0.0.1:7946", "10.0.0.9:8000", "[::1]:7946"})
+		})
+
+		Convey("looks up hostnames", func() {
+			resolver.Seeds = []string{"seeds.example.com"}
+			So(resolver.Resolve(), ShouldResemble, []string{"10.0.0.2:7946", "10.0.0.3:7946"})
+		})
+
+		Convey("looks up SRV records", func() {
+			resolver.SRVNames = []string{"_sidecar._tcp.example.com"}
+			So(resolver.Resolve(), ShouldResemble, []string{"10.0.1.1:8000"})
+		})
+
+		Convey("leaves out seeds that don't resolve, and duplicates", func() {
+			resolver.Seeds = []string{"missing.example.com", "10.0.0.2", "seeds.example.com", "10.0.0.1:junk"}
+			resolver.SRVNames = []string{"_missing._tcp.example.com"}
+			So(resolver.Resolve(), ShouldResemble, []string{"10.0.0.2:7946", "10.0.0.3:7946"})
+		})
+
+		Convey("with a seeds file", func() {
+			dir, err := ioutil.TempDir("", "sidecar-seeds")
+			So(err, ShouldBeNil)
+			Reset(func() { os.RemoveAll(dir) })
+
+			resolver.File = filepath.Join(dir, "seeds")
+			resolver.Seeds = []string{"10.0.0.1"}
+
+			Convey("adds the seeds in the file", func() {
+				err := ioutil.WriteFile(resolver.File, []byte("# Seeds\n10.0.0.5\n\n  10.0.0.6:8000  \n"), 0644)
+				So(err, ShouldBeNil)
+
+				So(resolver.Resolve(), ShouldResemble, []string{"10.0.0.1:7946", "10.0.0.5:7946", "10.0.0.6:8000"})
+			})
+
+			Convey("reads the file again when it changes", func() {
+				err := ioutil.WriteFile(resolver.File, []byte("10.0.0.5\n"), 0644)
+				So(err, ShouldBeNil)
+				So(resolver.Resolve(), ShouldContain, "10.0.0.5:7946")
+
+				err = ioutil.WriteFile(resolver.File, []byte("10.0.0.7\n"), 0644)
+				So(err, ShouldBeNil)
+				later := time.Now().Add(time.Minute)
+				So(os.Chtimes(resolver.File, later, later), ShouldBeNil)
+
+				So(resolver.Resolve(), ShouldResemble, []string{"10.0.0.1:7946", "10.0.0.7:7946"})
+			})
+
+			Convey("keeps the seeds it had when the file goes away", func() {
+				err := ioutil.WriteFile(resolver.File, []byte("10.0.0.5\n"), 0644)
+				So(err, ShouldBeNil)
+				resolver.Resolve()
+
+				So(os.Remove(resolver.File), ShouldBeNil)
+				So(resolver.Resolve(), ShouldResemble, []string{"10.0.0.1:7946", "10.0.0.5:7946"})
+			})
+
+			Convey("works without the file", func() {
+				So(resolver.Resolve(), ShouldResemble, []string{"10.0.0.1:7946"})
+			})
+		})
+	})
+}
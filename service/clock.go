@@ -0,0 +1,102 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	MAX_CLOCK_OFFSET = 5 * time.Minute // Don't follow peers whose clocks are further ahead than this
+)
+
+// A Clock is a hybrid logical clock. Each reading is a wall clock time that
+// never goes backward, plus a logical counter that orders readings sharing
+// the same time. Merging the readings we receive from peers keeps ours
+// ahead of everything we have seen, so the records we stamp supersede them
+// even when our wall clock is behind theirs.
+type Clock struct {
+	sync.Mutex
+	wall    time.Time
+	logical uint32
+	nowFunc func() time.Time
+}
+
+// NewClock returns a Clock that reads the system time
+func NewClock() *Clock {
+	return &Clock{
+		wall:    time.Unix(0, 0).UTC(),
+		nowFunc: time.Now,
+	}
+}
+
+// Now returns a new reading, later than any the clock has given out or
+// observed
+func (c *Clock) Now() (time.Time, uint32) {
+	c.Lock()
+	defer c.Unlock()
+
+	wall := c.nowFunc().UTC()
+	if wall.After(c.wall) {
+		c.wall = wall
+		c.logical = 0
+	} else {
+		c.logical++
+	}
+
+	return c.wall, c.logical
+}
+
+// Stamp makes sure a service record we are about to announce is later than
+// anything the clock has seen. Records that are already ahead of the clock
+// keep their own time, and move the clock up to it. Records that are behind
+// get a new reading.
+func (c *Clock) Stamp(svc *Service) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.isBehind(svc) {
+		c.logical++
+		svc.Updated = c.wall
+		svc.Logical = c.logical
+		return
+	}
+
+	c.wall = svc.Updated
+	c.logical = svc.Logical
+}
+
+// Supersede moves a record forward by the offset, so that it replaces the
+// record it was made from without replacing any newer ones that we missed.
+// The new reading is merged into the clock like any record we receive.
+func (c *Clock) Supersede(svc *Service, offset time.Duration) {
+	svc.Updated = svc.Updated.Add(offset)
+	c.Observe(svc)
+}
+
+// Observe merges the clock reading from a service record we received. It
+// returns how far ahead of our wall clock the record's time is, which is
+// negative when it is behind. Readings that are more than MAX_CLOCK_OFFSET
+// ahead are not merged, so one bad clock can't drag the cluster forward.
+func (c *Clock) Observe(svc *Service) time.Duration {
+	c.Lock()
+	defer c.Unlock()
+
+	offset := svc.Updated.Sub(c.nowFunc())
+	if offset > MAX_CLOCK_OFFSET {
+		return offset
+	}
+
+	if !c.isBehind(svc) {
+		c.wall = svc.Updated
+		c.logical = svc.Logical
+	}
+
+	return offset
+}
+
+// isBehind tells us if the service's reading is earlier than the clock's
+// Note: Not synchronized!
+func (c *Clock) isBehind(svc *Service) bool {
+	return svc.Updated.Before(c.wall) ||
+		(svc.Updated.Equal(c.wall) && svc.Logical < c.logical)
+}
@@ -0,0 +1,132 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_Clock(t *testing.T) {
+	Convey("The hybrid logical clock", t, func() {
+		baseTime := time.Now().UTC()
+		wallTime := baseTime
+
+		clock := NewClock()
+		clock.nowFunc = func() time.Time { return wallTime }
+
+		Convey("follows the wall clock", func() {
+			updated, logical := clock.Now()
+			So(updated.Equal(baseTime), ShouldBeTrue)
+			So(logical, ShouldEqual, 0)
+
+			wallTime = baseTime.Add(time.Second)
+			updated, logical = clock.Now()
+			So(updated.Equal(wallTime), ShouldBeTrue)
+			So(logical, ShouldEqual, 0)
+		})
+
+		Convey("counts readings when the wall clock doesn't move", func() {
+			clock.Now()
+			updated, logical := clock.Now()
+			So(updated.Equal(baseTime), ShouldBeTrue)
+			So(logical, ShouldEqual, 1)
+		})
+
+		Convey("doesn't go backward with the wall clock", func() {
+			clock.Now()
+			wallTime = baseTime.Add(-1 * time.Minute)
+
+			updated, logical := clock.Now()
+			So(updated.Equal(baseTime), ShouldBeTrue)
+			So(logical, ShouldEqual, 1)
+		})
+
+		Convey("moves ahead of records from a faster clock", func() {
+			remote := &Service{Updated: baseTime.Add(time.Minute), Logical: 4}
+			offset := clock.Observe(remote)
+			So(offset, ShouldEqual, time.Minute)
+
+			updated, logical := clock.Now()
+			So(updated.Equal(remote.Updated), ShouldBeTrue)
+			So(logical, ShouldEqual, 5)
+		})
+
+		Convey("ignores records too far in the future", func() {
+			remote := &Service{Updated: baseTime.Add(MAX_CLOCK_OFFSET + time.Second)}
+			clock.Observe(remote)
+
+			updated, _ := clock.Now()
+			So(updated.Equal(baseTime), ShouldBeTrue)
+		})
+
+		Convey("Stamp()", func() {
+			Convey("leaves records that are ahead of the clock alone", func() {
+				svc := &Service{Updated: baseTime}
+				clock.Stamp(svc)
+				So(svc.Updated.Equal(baseTime), ShouldBeTrue)
+				So(svc.Logical, ShouldEqual, 0)
+			})
+
+			Convey("moves records that are behind ahead of what we've seen", func() {
+				remote := &Service{Updated: baseTime.Add(time.Minute)}
+				clock.Observe(remote)
+
+				svc := &Service{Updated: baseTime}
+				clock.Stamp(svc)
+				So(svc.Invalidates(remote), ShouldBeTrue)
+			})
+		})
+
+		Convey("Supersede() moves a record just past itself and into the clock", func() {
+			original := Service{Updated: baseTime.Add(time.Minute), Logical: 2}
+			svc := original
+			clock.Supersede(&svc, time.Second)
+
+			So(svc.Updated.Equal(original.Updated.Add(time.Second)), ShouldBeTrue)
+			So(svc.Invalidates(&original), ShouldBeTrue)
+
+			updated, _ := clock.Now()
+			So(updated.Equal(svc.Updated), ShouldBeTrue)
+		})
+
+		Convey("Tombstone() supersedes the records the clock has seen", func() {
+			remote := &Service{Updated: baseTime.Add(time.Minute), Logical: 3}
+			clock.Observe(remote)
+
+			svc := &Service{Updated: baseTime, Status: ALIVE}
+			svc.Tombstone(clock)
+
+			So(svc.IsTombstone(), ShouldBeTrue)
+			So(svc.Invalidates(remote), ShouldBeTrue)
+		})
+
+		Convey("Tombstone() supersedes a record from ahead of the clock", func() {
+			svc := &Service{Updated: baseTime.Add(time.Minute), Logical: 3, Status: ALIVE}
+			original := *svc
+			svc.Tombstone(clock)
+
+			So(svc.Invalidates(&original), ShouldBeTrue)
+		})
+	})
+
+	Convey("Invalidates()", t, func() {
+		baseTime := time.Now().UTC()
+		svc := &Service{Updated: baseTime, Logical: 1}
+
+		Convey("compares the Updated times first", func() {
+			So(svc.Invalidates(&Service{Updated: baseTime.Add(-1 * time.Second), Logical: 5}), ShouldBeTrue)
+			So(svc.Invalidates(&Service{Updated: baseTime.Add(time.Second)}), ShouldBeFalse)
+		})
+
+		Convey("compares the logical counters when the times are the same", func() {
+			So(svc.Invalidates(&Service{Updated: baseTime}), ShouldBeTrue)
+			So(svc.Invalidates(&Service{Updated: baseTime, Logical: 1}), ShouldBeFalse)
+			So(svc.Invalidates(&Service{Updated: baseTime, Logical: 2}), ShouldBeFalse)
+		})
+
+		Convey("never invalidates nil", func() {
+			So(svc.Invalidates(nil), ShouldBeFalse)
+		})
+	})
+}
@@ -4,7 +4,6 @@ package service
 
 import (
 	"fmt"
-	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -29,6 +28,15 @@ type Port struct {
 	IP          string
 }
 
+// HealthSummary is a compact description of the latest health check result
+// for a service. It travels with the service so that peers can tell why a
+// service is not healthy.
+type HealthSummary struct {
+	Type      string
+	LastError string
+	FailCount int
+}
+
 type Service struct {
 	ID        string
 	Name      string
@@ -37,8 +45,11 @@ type Service struct {
 	Hostname  string
 	Ports     []Port
 	Updated   time.Time
+	Logical   uint32 `json:",omitempty"` // Orders records with the same Updated time
 	ProxyMode string
 	Status    int
+	Health    *HealthSummary    `json:",omitempty"`
+	Tags      map[string]string `json:",omitempty"`
 }
 
 func (svc *Service) Encode() ([]byte, error) {
@@ -61,8 +72,57 @@ func (svc *Service) IsDraining() bool {
 	return svc.Status == DRAINING
 }
 
+// Invalidates tells us if this record is newer than the other one. Records
+// are ordered by their hybrid logical clock reading: the Updated time, then
+// the Logical counter.
 func (svc *Service) Invalidates(otherSvc *Service) bool {
-	return otherSvc != nil && svc.Updated.After(otherSvc.Updated)
+	if otherSvc == nil {
+		return false
+	}
+
+	if svc.Updated.Equal(otherSvc.Updated) {
+		return svc.Logical > otherSvc.Logical
+	}
+
+	return svc.Updated.After(otherSvc.Updated)
+}
+
+// SameContent tells us if the other record describes the service the same
+// way, ignoring when it was updated. Anything that differs here is a change
+// to the service that peers and listeners need to hear about.
+func (svc *Service) SameContent(otherSvc *Service) bool {
+	if otherSvc == nil {
+		return false
+	}
+
+	if svc.Name != otherSvc.Name || svc.Image != otherSvc.Image ||
+		svc.ProxyMode != otherSvc.ProxyMode || svc.Status != otherSvc.Status {
+		return false
+	}
+
+	if len(svc.Ports) != len(otherSvc.Ports) {
+		return false
+	}
+	for i, port := range svc.Ports {
+		if port != otherSvc.Ports[i] {
+			return false
+		}
+	}
+
+	if len(svc.Tags) != len(otherSvc.Tags) {
+		return false
+	}
+	for key, value := range svc.Tags {
+		otherValue, ok := otherSvc.Tags[key]
+		if !ok || value != otherValue {
+			return false
+		}
+	}
+
+	if svc.Health == nil || otherSvc.Health == nil {
+		return svc.Health == otherSvc.Health
+	}
+	return *svc.Health == *otherSvc.Health
 }
 
 func (svc *Service) Format() string {
@@ -82,9 +142,26 @@ func (svc *Service) Format() string {
 	)
 }
 
-func (svc *Service) Tombstone() {
+// HasTag tells us if the service is tagged with the tag, which is given as
+// "key:value", or as just "key" to match any value.
+func (svc *Service) HasTag(tag string) bool {
+	parts := strings.SplitN(tag, ":", 2)
+
+	value, ok := svc.Tags[parts[0]]
+	if !ok {
+		return false
+	}
+
+	return len(parts) == 1 || value == parts[1]
+}
+
+// Tombstone marks the service as gone. The tombstone gets a reading from
+// the clock, after the record it replaces, so it supersedes every record of
+// the service that the clock has seen.
+func (svc *Service) Tombstone(clock *Clock) {
+	clock.Observe(svc)
 	svc.Status = TOMBSTONE
-	svc.Updated = time.Now().UTC()
+	svc.Updated, svc.Logical = clock.Now()
 }
 
 // Look up a (usually Docker) mapped Port for a service by ServicePort
@@ -116,9 +193,19 @@ func (svc *Service) Version() string {
 	return parts[0]
 }
 
-// Decode decodes the input data JSON into a *Service. If it fails, it returns
-// a non-nil error
+// Decode decodes the input data into a *Service. It accepts both JSON and
+// the compact wire format. If it fails, it returns a non-nil error
 func Decode(data []byte) (*Service, error) {
+	if !IsJSON(data) {
+		var wire WireService
+		err := UnmarshalWire(data, &wire)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode service: %s", err)
+		}
+
+		return wire.ToService(), nil
+	}
+
 	var svc Service
 	err := svc.UnmarshalJSON(data)
 	if err != nil {
@@ -129,10 +216,10 @@ func Decode(data []byte) (*Service, error) {
 }
 
 // Format an APIContainers struct into a more compact struct we
-// can ship over the wire in a broadcast.
-func ToService(container *docker.APIContainers, ip string) Service {
+// can ship over the wire in a broadcast. The hostname is the name of
+// the node the container runs on.
+func ToService(container *docker.APIContainers, ip string, hostname string) Service {
 	var svc Service
-	hostname, _ := os.Hostname()
 
 	svc.ID = container.ID[0:12]   // Use short IDs
 	svc.Name = container.Names[0] // Use the first name
@@ -174,6 +261,17 @@ func StatusString(status int) string {
 	}
 }
 
+// StatusFromString is the reverse of StatusString. It ignores case.
+func StatusFromString(status string) (int, error) {
+	for _, candidate := range []int{ALIVE, TOMBSTONE, UNHEALTHY, UNKNOWN, DRAINING} {
+		if strings.EqualFold(status, StatusString(candidate)) {
+			return candidate, nil
+		}
+	}
+
+	return -1, fmt.Errorf("unknown status %q", status)
+}
+
 // Figure out the correct port configuration for a service
 func buildPortFor(port *docker.APIPort, container *docker.APIContainers, ip string) Port {
 	// We look up service port labels by convention in the format "ServicePort_80=8080"
@@ -355,6 +355,297 @@ done:
 	return nil
 }
 
+func (mj *HealthSummary) MarshalJSON() ([]byte, error) {
+	var buf fflib.Buffer
+	if mj == nil {
+		buf.WriteString("null")
+		return buf.Bytes(), nil
+	}
+	err := mj.MarshalJSONBuf(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+func (mj *HealthSummary) MarshalJSONBuf(buf fflib.EncodingBuffer) error {
+	if mj == nil {
+		buf.WriteString("null")
+		return nil
+	}
+	var err error
+	var obj []byte
+	_ = obj
+	_ = err
+	buf.WriteString(`{"Type":`)
+	fflib.WriteJsonString(buf, string(mj.Type))
+	buf.WriteString(`,"LastError":`)
+	fflib.WriteJsonString(buf, string(mj.LastError))
+	buf.WriteString(`,"FailCount":`)
+	fflib.FormatBits2(buf, uint64(mj.FailCount), 10, mj.FailCount < 0)
+	buf.WriteByte('}')
+	return nil
+}
+
+const (
+	ffj_t_HealthSummarybase = iota
+	ffj_t_HealthSummaryno_such_key
+
+	ffj_t_HealthSummary_Type
+
+	ffj_t_HealthSummary_LastError
+
+	ffj_t_HealthSummary_FailCount
+)
+
+var ffj_key_HealthSummary_Type = []byte("Type")
+
+var ffj_key_HealthSummary_LastError = []byte("LastError")
+
+var ffj_key_HealthSummary_FailCount = []byte("FailCount")
+
+func (uj *HealthSummary) UnmarshalJSON(input []byte) error {
+	fs := fflib.NewFFLexer(input)
+	return uj.UnmarshalJSONFFLexer(fs, fflib.FFParse_map_start)
+}
+
+func (uj *HealthSummary) UnmarshalJSONFFLexer(fs *fflib.FFLexer, state fflib.FFParseState) error {
+	var err error = nil
+	currentKey := ffj_t_HealthSummarybase
+	_ = currentKey
+	tok := fflib.FFTok_init
+	wantedTok := fflib.FFTok_init
+
+mainparse:
+	for {
+		tok = fs.Scan()
+		//	println(fmt.Sprintf("debug: tok: %v  state: %v", tok, state))
+		if tok == fflib.FFTok_error {
+			goto tokerror
+		}
+
+		switch state {
+
+		case fflib.FFParse_map_start:
+			if tok != fflib.FFTok_left_bracket {
+				wantedTok = fflib.FFTok_left_bracket
+				goto wrongtokenerror
+			}
+			state = fflib.FFParse_want_key
+			continue
+
+		case fflib.FFParse_after_value:
+			if tok == fflib.FFTok_comma {
+				state = fflib.FFParse_want_key
+			} else if tok == fflib.FFTok_right_bracket {
+				goto done
+			} else {
+				wantedTok = fflib.FFTok_comma
+				goto wrongtokenerror
+			}
+
+		case fflib.FFParse_want_key:
+			// json {} ended. goto exit. woo.
+			if tok == fflib.FFTok_right_bracket {
+				goto done
+			}
+			if tok != fflib.FFTok_string {
+				wantedTok = fflib.FFTok_string
+				goto wrongtokenerror
+			}
+
+			kn := fs.Output.Bytes()
+			if len(kn) <= 0 {
+				// "" case. hrm.
+				currentKey = ffj_t_HealthSummaryno_such_key
+				state = fflib.FFParse_want_colon
+				goto mainparse
+			} else {
+				switch kn[0] {
+
+				case 'F':
+
+					if bytes.Equal(ffj_key_HealthSummary_FailCount, kn) {
+						currentKey = ffj_t_HealthSummary_FailCount
+						state = fflib.FFParse_want_colon
+						goto mainparse
+					}
+
+				case 'L':
+
+					if bytes.Equal(ffj_key_HealthSummary_LastError, kn) {
+						currentKey = ffj_t_HealthSummary_LastError
+						state = fflib.FFParse_want_colon
+						goto mainparse
+					}
+
+				case 'T':
+
+					if bytes.Equal(ffj_key_HealthSummary_Type, kn) {
+						currentKey = ffj_t_HealthSummary_Type
+						state = fflib.FFParse_want_colon
+						goto mainparse
+					}
+
+				}
+
+				if fflib.SimpleLetterEqualFold(ffj_key_HealthSummary_FailCount, kn) {
+					currentKey = ffj_t_HealthSummary_FailCount
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.EqualFoldRight(ffj_key_HealthSummary_LastError, kn) {
+					currentKey = ffj_t_HealthSummary_LastError
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.SimpleLetterEqualFold(ffj_key_HealthSummary_Type, kn) {
+					currentKey = ffj_t_HealthSummary_Type
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				currentKey = ffj_t_HealthSummaryno_such_key
+				state = fflib.FFParse_want_colon
+				goto mainparse
+			}
+
+		case fflib.FFParse_want_colon:
+			if tok != fflib.FFTok_colon {
+				wantedTok = fflib.FFTok_colon
+				goto wrongtokenerror
+			}
+			state = fflib.FFParse_want_value
+			continue
+		case fflib.FFParse_want_value:
+
+			if tok == fflib.FFTok_left_brace || tok == fflib.FFTok_left_bracket || tok == fflib.FFTok_integer || tok == fflib.FFTok_double || tok == fflib.FFTok_string || tok == fflib.FFTok_bool || tok == fflib.FFTok_null {
+				switch currentKey {
+
+				case ffj_t_HealthSummary_Type:
+					goto handle_Type
+
+				case ffj_t_HealthSummary_LastError:
+					goto handle_LastError
+
+				case ffj_t_HealthSummary_FailCount:
+					goto handle_FailCount
+
+				case ffj_t_HealthSummaryno_such_key:
+					err = fs.SkipField(tok)
+					if err != nil {
+						return fs.WrapErr(err)
+					}
+					state = fflib.FFParse_after_value
+					goto mainparse
+				}
+			} else {
+				goto wantedvalue
+			}
+		}
+	}
+
+handle_Type:
+
+	/* handler: uj.Type type=string kind=string quoted=false*/
+
+	{
+
+		{
+			if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
+				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
+			}
+		}
+
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			outBuf := fs.Output.Bytes()
+
+			uj.Type = string(string(outBuf))
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_LastError:
+
+	/* handler: uj.LastError type=string kind=string quoted=false*/
+
+	{
+
+		{
+			if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
+				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
+			}
+		}
+
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			outBuf := fs.Output.Bytes()
+
+			uj.LastError = string(string(outBuf))
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_FailCount:
+
+	/* handler: uj.FailCount type=int kind=int quoted=false*/
+
+	{
+		if tok != fflib.FFTok_integer && tok != fflib.FFTok_null {
+			return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for int", tok))
+		}
+	}
+
+	{
+
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			tval, err := fflib.ParseInt(fs.Output.Bytes(), 10, 64)
+
+			if err != nil {
+				return fs.WrapErr(err)
+			}
+
+			uj.FailCount = int(tval)
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+wantedvalue:
+	return fs.WrapErr(fmt.Errorf("wanted value token, but got token: %v", tok))
+wrongtokenerror:
+	return fs.WrapErr(fmt.Errorf("ffjson: wanted token: %v, but got token: %v output=%s", wantedTok, tok, fs.Output.String()))
+tokerror:
+	if fs.BigError != nil {
+		return fs.WrapErr(fs.BigError)
+	}
+	err = fs.Error.ToError()
+	if err != nil {
+		return fs.WrapErr(err)
+	}
+	panic("ffjson-generated: unreachable, please report bug.")
+done:
+
+	return nil
+}
+
 func (mj *Service) MarshalJSON() ([]byte, error) {
 	var buf fflib.Buffer
 	if mj == nil {
@@ -427,10 +718,42 @@ func (mj *Service) MarshalJSONBuf(buf fflib.EncodingBuffer) error {
 		buf.Write(obj)
 
 	}
+	if mj.Logical != 0 {
+		buf.WriteString(`,"Logical":`)
+		fflib.FormatBits2(buf, uint64(mj.Logical), 10, false)
+	}
 	buf.WriteString(`,"ProxyMode":`)
 	fflib.WriteJsonString(buf, string(mj.ProxyMode))
 	buf.WriteString(`,"Status":`)
 	fflib.FormatBits2(buf, uint64(mj.Status), 10, mj.Status < 0)
+	if mj.Health != nil {
+		buf.WriteString(`,"Health":`)
+
+		{
+
+			err = mj.Health.MarshalJSONBuf(buf)
+			if err != nil {
+				return err
+			}
+
+		}
+	}
+	if len(mj.Tags) != 0 {
+		buf.WriteString(`,"Tags":`)
+		if mj.Tags == nil {
+			buf.WriteString(`null`)
+		} else {
+			buf.WriteString(`{ `)
+			for key, value := range mj.Tags {
+				fflib.WriteJsonString(buf, key)
+				buf.WriteString(`:`)
+				fflib.WriteJsonString(buf, string(value))
+				buf.WriteByte(',')
+			}
+			buf.Rewind(1)
+			buf.WriteByte('}')
+		}
+	}
 	buf.WriteByte('}')
 	return nil
 }
@@ -453,9 +776,15 @@ const (
 
 	ffj_t_Service_Updated
 
+	ffj_t_Service_Logical
+
 	ffj_t_Service_ProxyMode
 
 	ffj_t_Service_Status
+
+	ffj_t_Service_Health
+
+	ffj_t_Service_Tags
 )
 
 var ffj_key_Service_ID = []byte("ID")
@@ -472,10 +801,16 @@ var ffj_key_Service_Ports = []byte("Ports")
 
 var ffj_key_Service_Updated = []byte("Updated")
 
+var ffj_key_Service_Logical = []byte("Logical")
+
 var ffj_key_Service_ProxyMode = []byte("ProxyMode")
 
 var ffj_key_Service_Status = []byte("Status")
 
+var ffj_key_Service_Health = []byte("Health")
+
+var ffj_key_Service_Tags = []byte("Tags")
+
 func (uj *Service) UnmarshalJSON(input []byte) error {
 	fs := fflib.NewFFLexer(input)
 	return uj.UnmarshalJSONFFLexer(fs, fflib.FFParse_map_start)
@@ -549,6 +884,11 @@ mainparse:
 						currentKey = ffj_t_Service_Hostname
 						state = fflib.FFParse_want_colon
 						goto mainparse
+
+					} else if bytes.Equal(ffj_key_Service_Health, kn) {
+						currentKey = ffj_t_Service_Health
+						state = fflib.FFParse_want_colon
+						goto mainparse
 					}
 
 				case 'I':
@@ -564,6 +904,14 @@ mainparse:
 						goto mainparse
 					}
 
+				case 'L':
+
+					if bytes.Equal(ffj_key_Service_Logical, kn) {
+						currentKey = ffj_t_Service_Logical
+						state = fflib.FFParse_want_colon
+						goto mainparse
+					}
+
 				case 'N':
 
 					if bytes.Equal(ffj_key_Service_Name, kn) {
@@ -593,6 +941,14 @@ mainparse:
 						goto mainparse
 					}
 
+				case 'T':
+
+					if bytes.Equal(ffj_key_Service_Tags, kn) {
+						currentKey = ffj_t_Service_Tags
+						state = fflib.FFParse_want_colon
+						goto mainparse
+					}
+
 				case 'U':
 
 					if bytes.Equal(ffj_key_Service_Updated, kn) {
@@ -603,6 +959,18 @@ mainparse:
 
 				}
 
+				if fflib.EqualFoldRight(ffj_key_Service_Tags, kn) {
+					currentKey = ffj_t_Service_Tags
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
+				if fflib.SimpleLetterEqualFold(ffj_key_Service_Health, kn) {
+					currentKey = ffj_t_Service_Health
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
 				if fflib.EqualFoldRight(ffj_key_Service_Status, kn) {
 					currentKey = ffj_t_Service_Status
 					state = fflib.FFParse_want_colon
@@ -615,6 +983,12 @@ mainparse:
 					goto mainparse
 				}
 
+				if fflib.SimpleLetterEqualFold(ffj_key_Service_Logical, kn) {
+					currentKey = ffj_t_Service_Logical
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
 				if fflib.SimpleLetterEqualFold(ffj_key_Service_Updated, kn) {
 					currentKey = ffj_t_Service_Updated
 					state = fflib.FFParse_want_colon
@@ -695,12 +1069,21 @@ mainparse:
 				case ffj_t_Service_Updated:
 					goto handle_Updated
 
+				case ffj_t_Service_Logical:
+					goto handle_Logical
+
 				case ffj_t_Service_ProxyMode:
 					goto handle_ProxyMode
 
 				case ffj_t_Service_Status:
 					goto handle_Status
 
+				case ffj_t_Service_Health:
+					goto handle_Health
+
+				case ffj_t_Service_Tags:
+					goto handle_Tags
+
 				case ffj_t_Serviceno_such_key:
 					err = fs.SkipField(tok)
 					if err != nil {
@@ -940,6 +1323,36 @@ handle_Updated:
 	state = fflib.FFParse_after_value
 	goto mainparse
 
+handle_Logical:
+
+	/* handler: uj.Logical type=uint32 kind=uint32 quoted=false*/
+
+	{
+		if tok != fflib.FFTok_integer && tok != fflib.FFTok_null {
+			return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for uint32", tok))
+		}
+	}
+
+	{
+
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			tval, err := fflib.ParseUint(fs.Output.Bytes(), 10, 32)
+
+			if err != nil {
+				return fs.WrapErr(err)
+			}
+
+			uj.Logical = uint32(tval)
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
 handle_ProxyMode:
 
 	/* handler: uj.ProxyMode type=string kind=string quoted=false*/
@@ -996,6 +1409,138 @@ handle_Status:
 	state = fflib.FFParse_after_value
 	goto mainparse
 
+handle_Health:
+
+	/* handler: uj.Health type=service.HealthSummary kind=struct quoted=false*/
+
+	{
+		if tok == fflib.FFTok_null {
+
+			uj.Health = nil
+
+			state = fflib.FFParse_after_value
+			goto mainparse
+		}
+
+		if uj.Health == nil {
+			uj.Health = new(HealthSummary)
+		}
+
+		err = uj.Health.UnmarshalJSONFFLexer(fs, fflib.FFParse_want_key)
+		if err != nil {
+			return err
+		}
+		state = fflib.FFParse_after_value
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
+handle_Tags:
+
+	/* handler: uj.Tags type=map[string]string kind=map quoted=false*/
+
+	{
+
+		{
+			if tok != fflib.FFTok_left_bracket && tok != fflib.FFTok_null {
+				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for ", tok))
+			}
+		}
+
+		if tok == fflib.FFTok_null {
+			uj.Tags = nil
+		} else {
+
+			uj.Tags = make(map[string]string, 0)
+
+			wantVal := true
+
+			for {
+
+				var k string
+
+				var tmp_uj__Tags string
+
+				tok = fs.Scan()
+				if tok == fflib.FFTok_error {
+					goto tokerror
+				}
+				if tok == fflib.FFTok_right_bracket {
+					break
+				}
+
+				if tok == fflib.FFTok_comma {
+					if wantVal == true {
+						// TODO(pquerna): this isn't an ideal error message, this handles
+						// things like [,,,] as an array value.
+						return fs.WrapErr(fmt.Errorf("wanted value token, but got token: %v", tok))
+					}
+					continue
+				} else {
+					wantVal = true
+				}
+
+				/* handler: k type=string kind=string quoted=false*/
+
+				{
+
+					{
+						if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
+							return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
+						}
+					}
+
+					if tok == fflib.FFTok_null {
+
+					} else {
+
+						outBuf := fs.Output.Bytes()
+
+						k = string(string(outBuf))
+
+					}
+				}
+
+				// Expect ':' after key
+				tok = fs.Scan()
+				if tok != fflib.FFTok_colon {
+					return fs.WrapErr(fmt.Errorf("wanted colon token, but got token: %v", tok))
+				}
+
+				tok = fs.Scan()
+				/* handler: tmp_uj__Tags type=string kind=string quoted=false*/
+
+				{
+
+					{
+						if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
+							return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
+						}
+					}
+
+					if tok == fflib.FFTok_null {
+
+					} else {
+
+						outBuf := fs.Output.Bytes()
+
+						tmp_uj__Tags = string(string(outBuf))
+
+					}
+				}
+
+				uj.Tags[k] = tmp_uj__Tags
+
+				wantVal = false
+			}
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
 wantedvalue:
 	return fs.WrapErr(fmt.Errorf("wanted value token, but got token: %v", tok))
 wrongtokenerror:
@@ -1,8 +1,8 @@
 package service
 
 import (
-	"os"
 	"testing"
+	"time"
 
 	"github.com/fsouza/go-dockerclient"
 	. "github.com/smartystreets/goconvey/convey"
@@ -120,11 +120,11 @@ func Test_ToService(t *testing.T) {
 		},
 	}
 
-	sampleHostname, _ := os.Hostname()
+	sampleHostname := "chaucer"
 
 	Convey("ToService()", t, func() {
 		Convey("Decodes service correctly", func() {
-			service := ToService(sampleAPIContainer, "127.0.0.1")
+			service := ToService(sampleAPIContainer, "127.0.0.1", sampleHostname)
 			So(service.ID, ShouldEqual, sampleAPIContainer.ID[:12])
 			So(service.Image, ShouldEqual, sampleAPIContainer.Image)
 			So(service.Name, ShouldEqual, sampleAPIContainer.Names[0])
@@ -137,3 +137,176 @@ func Test_ToService(t *testing.T) {
 		})
 	})
 }
+
+func Test_EncodeDecode(t *testing.T) {
+	Convey("Encoding and decoding services", t, func() {
+		svc := &Service{
+			ID:     "deadbeef123",
+			Name:   "beowulf",
+			Status: UNHEALTHY,
+			Health: &HealthSummary{
+				Type:      "HttpGet",
+				LastError: "HTTP status 503",
+				FailCount: 3,
+			},
+		}
+
+		Convey("Round trips the health summary", func() {
+			data, err := svc.Encode()
+			So(err, ShouldBeNil)
+
+			decoded, err := Decode(data)
+			So(err, ShouldBeNil)
+			So(decoded.Health, ShouldResemble, svc.Health)
+		})
+
+		Convey("Leaves out the health summary when there is none", func() {
+			svc.Health = nil
+			data, err := svc.Encode()
+			So(err, ShouldBeNil)
+			So(string(data), ShouldNotContainSubstring, "Health")
+
+			decoded, err := Decode(data)
+			So(err, ShouldBeNil)
+			So(decoded.Health, ShouldBeNil)
+		})
+
+		Convey("Round trips the tags", func() {
+			svc.Tags = map[string]string{"zone": "us-east-1a", "team": "octopus"}
+			data, err := svc.Encode()
+			So(err, ShouldBeNil)
+
+			decoded, err := Decode(data)
+			So(err, ShouldBeNil)
+			So(decoded.Tags, ShouldResemble, svc.Tags)
+		})
+
+		Convey("Leaves out the tags when there are none", func() {
+			data, err := svc.Encode()
+			So(err, ShouldBeNil)
+			So(string(data), ShouldNotContainSubstring, "Tags")
+
+			decoded, err := Decode(data)
+			So(err, ShouldBeNil)
+			So(decoded.Tags, ShouldBeNil)
+		})
+
+		Convey("Round trips the logical clock", func() {
+			svc.Logical = 3
+			data, err := svc.Encode()
+			So(err, ShouldBeNil)
+
+			decoded, err := Decode(data)
+			So(err, ShouldBeNil)
+			So(decoded.Logical, ShouldEqual, 3)
+		})
+
+		Convey("Leaves out the logical clock when it is zero", func() {
+			data, err := svc.Encode()
+			So(err, ShouldBeNil)
+			So(string(data), ShouldNotContainSubstring, "Logical")
+		})
+	})
+}
+
+func Test_HasTag(t *testing.T) {
+	Convey("HasTag()", t, func() {
+		svc := &Service{
+			ID:   "deadbeef123",
+			Tags: map[string]string{"zone": "us-east-1a"},
+		}
+
+		Convey("matches a key and value", func() {
+			So(svc.HasTag("zone:us-east-1a"), ShouldBeTrue)
+			So(svc.HasTag("zone:us-west-2b"), ShouldBeFalse)
+		})
+
+		Convey("matches just a key", func() {
+			So(svc.HasTag("zone"), ShouldBeTrue)
+			So(svc.HasTag("team"), ShouldBeFalse)
+		})
+
+		Convey("doesn't match when there are no tags", func() {
+			svc.Tags = nil
+			So(svc.HasTag("zone"), ShouldBeFalse)
+		})
+	})
+}
+
+func Test_SameContent(t *testing.T) {
+	Convey("SameContent()", t, func() {
+		svc := &Service{
+			ID:        "deadbeef123",
+			Name:      "beowulf",
+			Image:     "heorot:1",
+			Ports:     []Port{{Type: "tcp", Port: 10234, ServicePort: 9999}},
+			ProxyMode: "http",
+			Tags:      map[string]string{"zone": "us-east-1a"},
+			Health:    &HealthSummary{Type: "HttpGet", FailCount: 1},
+			Updated:   time.Now().UTC(),
+		}
+		other := *svc
+		other.Ports = []Port{{Type: "tcp", Port: 10234, ServicePort: 9999}}
+		other.Tags = map[string]string{"zone": "us-east-1a"}
+		other.Health = &HealthSummary{Type: "HttpGet", FailCount: 1}
+		other.Updated = svc.Updated.Add(time.Second)
+
+		Convey("ignores when the records were updated", func() {
+			So(svc.SameContent(&other), ShouldBeTrue)
+		})
+
+		Convey("notices changed ports", func() {
+			other.Ports[0].Port = 10235
+			So(svc.SameContent(&other), ShouldBeFalse)
+		})
+
+		Convey("notices changed tags", func() {
+			other.Tags["zone"] = "us-west-2b"
+			So(svc.SameContent(&other), ShouldBeFalse)
+		})
+
+		Convey("notices a changed image or proxy mode", func() {
+			other.Image = "heorot:2"
+			So(svc.SameContent(&other), ShouldBeFalse)
+
+			other.Image = svc.Image
+			other.ProxyMode = "tcp"
+			So(svc.SameContent(&other), ShouldBeFalse)
+		})
+
+		Convey("notices changed health", func() {
+			other.Health.FailCount = 2
+			So(svc.SameContent(&other), ShouldBeFalse)
+
+			other.Health = nil
+			So(svc.SameContent(&other), ShouldBeFalse)
+		})
+
+		Convey("treats missing and empty tags the same", func() {
+			svc.Tags = nil
+			other.Tags = map[string]string{}
+			So(svc.SameContent(&other), ShouldBeTrue)
+		})
+	})
+}
+
+func Test_StatusFromString(t *testing.T) {
+	Convey("StatusFromString()", t, func() {
+		Convey("reverses StatusString(), ignoring case", func() {
+			for _, status := range []int{ALIVE, TOMBSTONE, UNHEALTHY, UNKNOWN, DRAINING} {
+				parsed, err := StatusFromString(StatusString(status))
+				So(err, ShouldBeNil)
+				So(parsed, ShouldEqual, status)
+			}
+
+			parsed, err := StatusFromString("alive")
+			So(err, ShouldBeNil)
+			So(parsed, ShouldEqual, ALIVE)
+		})
+
+		Convey("returns an error for unknown statuses", func() {
+			_, err := StatusFromString("sleepy")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
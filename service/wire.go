@@ -0,0 +1,237 @@
+package service
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"time"
+
+	"github.com/hashicorp/go-msgpack/codec"
+)
+
+// The compact wire format is msgpack, optionally compressed with DEFLATE.
+// Every compact message starts with a version byte so we can change the
+// format later. Versions are always control characters, which JSON and
+// other text never start with, so we can accept both while a cluster is
+// being upgraded.
+
+// A WireFormat is the encoding we use for messages we send to our peers
+type WireFormat int
+
+const (
+	JSON_FORMAT       WireFormat = iota // ffjson-encoded, what older Sidecars speak
+	MSGPACK_FORMAT                      // Compact msgpack
+	COMPRESSED_FORMAT                   // Compact msgpack, compressed with DEFLATE
+)
+
+const (
+	WIRE_VERSION_MSGPACK    = 1        // The version byte for msgpack messages
+	WIRE_VERSION_COMPRESSED = 2        // The version byte for compressed msgpack messages
+	MAX_DECOMPRESSED_SIZE   = 32 << 20 // Refuse to inflate messages beyond 32MB
+)
+
+var msgpackHandle codec.MsgpackHandle
+
+// ParseWireFormat returns the WireFormat for a name from the config
+func ParseWireFormat(name string) (WireFormat, error) {
+	switch name {
+	case "", "json":
+		return JSON_FORMAT, nil
+	case "msgpack":
+		return MSGPACK_FORMAT, nil
+	case "msgpack-deflate":
+		return COMPRESSED_FORMAT, nil
+	default:
+		return JSON_FORMAT, fmt.Errorf("unknown wire format %q", name)
+	}
+}
+
+// IsJSON tells us if a message is in the JSON format rather than the
+// compact one. Anything that doesn't start with a version byte is treated
+// as JSON, including pretty printed JSON and junk, so that the errors come
+// from the JSON decoder.
+func IsJSON(data []byte) bool {
+	if len(data) < 1 {
+		return false
+	}
+
+	switch data[0] {
+	case '\t', '\r', '\n':
+		return true
+	}
+
+	return data[0] >= ' '
+}
+
+// MarshalWire encodes a value in the compact format, with the version byte
+func MarshalWire(value interface{}, format WireFormat) ([]byte, error) {
+	var encoded []byte
+	err := codec.NewEncoderBytes(&encoded, &msgpackHandle).Encode(value)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case MSGPACK_FORMAT:
+		return append([]byte{WIRE_VERSION_MSGPACK}, encoded...), nil
+	case COMPRESSED_FORMAT:
+		buf := bytes.NewBuffer([]byte{WIRE_VERSION_COMPRESSED})
+		writer, err := flate.NewWriter(buf, flate.BestSpeed)
+		if err != nil {
+			return nil, err
+		}
+		_, err = writer.Write(encoded)
+		if err != nil {
+			return nil, err
+		}
+		err = writer.Close()
+		if err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	default:
+		return nil, fmt.Errorf("format %d is not a compact wire format", format)
+	}
+}
+
+// UnmarshalWire decodes a message in the compact format into the value
+func UnmarshalWire(data []byte, value interface{}) error {
+	if len(data) < 1 {
+		return fmt.Errorf("empty message")
+	}
+
+	encoded := data[1:]
+
+	switch data[0] {
+	case WIRE_VERSION_MSGPACK:
+	case WIRE_VERSION_COMPRESSED:
+		reader := flate.NewReader(bytes.NewReader(encoded))
+		defer reader.Close()
+
+		var err error
+		encoded, err = ioutil.ReadAll(io.LimitReader(reader, MAX_DECOMPRESSED_SIZE+1))
+		if err != nil {
+			return fmt.Errorf("failed to decompress message: %s", err)
+		}
+		if len(encoded) > MAX_DECOMPRESSED_SIZE {
+			return fmt.Errorf("message is larger than %d bytes", MAX_DECOMPRESSED_SIZE)
+		}
+	default:
+		return fmt.Errorf("unknown wire format version %d", data[0])
+	}
+
+	return codec.NewDecoderBytes(encoded, &msgpackHandle).Decode(value)
+}
+
+// WireService is the representation of a Service in the compact format.
+// The short keys keep the messages small.
+type WireService struct {
+	ID        string            `codec:"i"`
+	Name      string            `codec:"n"`
+	Image     string            `codec:"m"`
+	Created   int64             `codec:"c"`
+	Hostname  string            `codec:"h"`
+	Ports     []wirePort        `codec:"p,omitempty"`
+	Updated   int64             `codec:"u"`
+	Logical   uint32            `codec:"g,omitempty"`
+	ProxyMode string            `codec:"x"`
+	Status    int               `codec:"s"`
+	Health    *wireHealth       `codec:"l,omitempty"`
+	Tags      map[string]string `codec:"t,omitempty"`
+}
+
+type wirePort struct {
+	Type        string `codec:"t"`
+	Port        int64  `codec:"p"`
+	ServicePort int64  `codec:"s"`
+	IP          string `codec:"i"`
+}
+
+type wireHealth struct {
+	Type      string `codec:"t"`
+	LastError string `codec:"e"`
+	FailCount int    `codec:"f"`
+}
+
+// ToWire returns the compact representation of the service
+func (svc *Service) ToWire() *WireService {
+	wire := &WireService{
+		ID:        svc.ID,
+		Name:      svc.Name,
+		Image:     svc.Image,
+		Created:   WireTime(svc.Created),
+		Hostname:  svc.Hostname,
+		Updated:   WireTime(svc.Updated),
+		Logical:   svc.Logical,
+		ProxyMode: svc.ProxyMode,
+		Status:    svc.Status,
+		Tags:      svc.Tags,
+	}
+
+	for _, port := range svc.Ports {
+		wire.Ports = append(wire.Ports, wirePort(port))
+	}
+
+	if svc.Health != nil {
+		health := wireHealth(*svc.Health)
+		wire.Health = &health
+	}
+
+	return wire
+}
+
+// ToService returns the Service from its compact representation
+func (wire *WireService) ToService() *Service {
+	svc := &Service{
+		ID:        wire.ID,
+		Name:      wire.Name,
+		Image:     wire.Image,
+		Created:   FromWireTime(wire.Created),
+		Hostname:  wire.Hostname,
+		Updated:   FromWireTime(wire.Updated),
+		Logical:   wire.Logical,
+		ProxyMode: wire.ProxyMode,
+		Status:    wire.Status,
+		Tags:      wire.Tags,
+	}
+
+	for _, port := range wire.Ports {
+		svc.Ports = append(svc.Ports, Port(port))
+	}
+
+	if wire.Health != nil {
+		health := HealthSummary(*wire.Health)
+		svc.Health = &health
+	}
+
+	return svc
+}
+
+// EncodeWire encodes the service in the format we gossip with
+func (svc *Service) EncodeWire(format WireFormat) ([]byte, error) {
+	if format == JSON_FORMAT {
+		return svc.Encode()
+	}
+
+	return MarshalWire(svc.ToWire(), format)
+}
+
+// WireTime converts a time to nanoseconds since the epoch for the compact
+// format. The zero time can't be represented that way, so it gets a marker.
+func WireTime(t time.Time) int64 {
+	if t.IsZero() {
+		return math.MinInt64
+	}
+	return t.UnixNano()
+}
+
+// FromWireTime converts nanoseconds since the epoch back into a UTC time
+func FromWireTime(nanos int64) time.Time {
+	if nanos == math.MinInt64 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos).UTC()
+}
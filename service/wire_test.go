@@ -0,0 +1,100 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_WireFormat(t *testing.T) {
+	Convey("The compact wire format", t, func() {
+		baseTime := time.Now().UTC()
+
+		svc := &Service{
+			ID:        "deadbeef123",
+			Name:      "beowulf",
+			Image:     "101deadbeef",
+			Created:   baseTime,
+			Hostname:  "chaucer",
+			Ports:     []Port{{Type: "tcp", Port: 32768, ServicePort: 10000, IP: "127.0.0.1"}},
+			Updated:   baseTime,
+			Logical:   2,
+			ProxyMode: "http",
+			Status:    UNHEALTHY,
+			Health:    &HealthSummary{Type: "HttpGet", LastError: "HTTP status 503", FailCount: 3},
+			Tags:      map[string]string{"zone": "us-east-1a"},
+		}
+
+		Convey("round trips a service through Decode()", func() {
+			for _, format := range []WireFormat{MSGPACK_FORMAT, COMPRESSED_FORMAT} {
+				data, err := svc.EncodeWire(format)
+				So(err, ShouldBeNil)
+				So(IsJSON(data), ShouldBeFalse)
+
+				decoded, err := Decode(data)
+				So(err, ShouldBeNil)
+				So(decoded, ShouldResemble, svc)
+			}
+		})
+
+		Convey("is smaller than JSON", func() {
+			jsonData, err := svc.EncodeWire(JSON_FORMAT)
+			So(err, ShouldBeNil)
+			So(IsJSON(jsonData), ShouldBeTrue)
+
+			data, err := svc.EncodeWire(MSGPACK_FORMAT)
+			So(err, ShouldBeNil)
+			So(len(data), ShouldBeLessThan, len(jsonData))
+		})
+
+		Convey("starts with the version byte", func() {
+			data, err := svc.EncodeWire(MSGPACK_FORMAT)
+			So(err, ShouldBeNil)
+			So(data[0], ShouldEqual, WIRE_VERSION_MSGPACK)
+
+			data, err = svc.EncodeWire(COMPRESSED_FORMAT)
+			So(err, ShouldBeNil)
+			So(data[0], ShouldEqual, WIRE_VERSION_COMPRESSED)
+		})
+
+		Convey("keeps the zero time", func() {
+			svc.Created = time.Time{}
+			data, err := svc.EncodeWire(MSGPACK_FORMAT)
+			So(err, ShouldBeNil)
+
+			decoded, err := Decode(data)
+			So(err, ShouldBeNil)
+			So(decoded.Created.IsZero(), ShouldBeTrue)
+		})
+
+		Convey("rejects unknown versions", func() {
+			_, err := Decode([]byte{12, 1, 2, 3})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "unknown wire format version 12")
+		})
+
+		Convey("treats anything that isn't versioned as JSON", func() {
+			So(IsJSON([]byte("\n  {\"ID\": \"deadbeef123\"}")), ShouldBeTrue)
+			So(IsJSON([]byte("so bad!")), ShouldBeTrue)
+			So(IsJSON(nil), ShouldBeFalse)
+
+			decoded, err := Decode([]byte("\n  {\"ID\": \"deadbeef123\"}"))
+			So(err, ShouldBeNil)
+			So(decoded.ID, ShouldEqual, "deadbeef123")
+		})
+
+		Convey("ParseWireFormat() understands the config names", func() {
+			format, err := ParseWireFormat("msgpack-deflate")
+			So(err, ShouldBeNil)
+			So(format, ShouldEqual, COMPRESSED_FORMAT)
+
+			format, err = ParseWireFormat("")
+			So(err, ShouldBeNil)
+			So(format, ShouldEqual, JSON_FORMAT)
+
+			_, err = ParseWireFormat("protobuf")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -1,42 +1,39 @@
 package main
 
 import (
-	"encoding/json"
-	"math/rand"
+	"sync"
 	"time"
 
 	"github.com/Nitro/memberlist"
 	"github.com/Nitro/sidecar/catalog"
+	"github.com/Nitro/sidecar/keyring"
+	"github.com/Nitro/sidecar/members"
 	"github.com/Nitro/sidecar/service"
 	metrics "github.com/armon/go-metrics"
 	"github.com/pquerna/ffjson/ffjson"
 	log "github.com/sirupsen/logrus"
 )
 
-const (
-	MAX_PENDING_LENGTH = 100 // Number of messages we can replace into the pending queue
-)
-
 type servicesDelegate struct {
-	state             *catalog.ServicesState
-	pendingBroadcasts [][]byte
-	notifications     chan []byte
-	Started           bool
-	StartedAt         time.Time
-	Metadata          NodeMetadata
-}
-
-type NodeMetadata struct {
-	ClusterName string
-	State       string
+	state         *catalog.ServicesState
+	broadcasts    *broadcastQueue
+	notifications chan []byte
+	Started       bool
+	StartedAt     time.Time
+	Metadata      catalog.NodeMetadata
+	DigestSync    bool // Exchange digests rather than the whole state on push/pull
+	Members       *members.Tracker
+	Keys          *keyring.Exchange // Handles key requests from our peers
+	list          *memberlist.Memberlist
+	listLock      sync.RWMutex
 }
 
 func NewServicesDelegate(state *catalog.ServicesState) *servicesDelegate {
 	delegate := servicesDelegate{
-		state:             state,
-		pendingBroadcasts: make([][]byte, 0),
-		notifications:     make(chan []byte, 25),
-		Metadata:          NodeMetadata{ClusterName: "default"},
+		state:         state,
+		broadcasts:    newBroadcastQueue(MAX_PENDING_LENGTH),
+		notifications: make(chan []byte, 25),
+		Metadata:      catalog.NodeMetadata{ClusterName: "default"},
 	}
 
 	return &delegate
@@ -46,12 +43,28 @@ func NewServicesDelegate(state *catalog.ServicesState) *servicesDelegate {
 func (d *servicesDelegate) Start() {
 	go func() {
 		for message := range d.notifications {
-			entry, err := service.Decode(message)
-			if err != nil {
-				log.Errorf("Start(): error decoding message: %s", err)
-				continue
+			switch message[0] {
+			case catalog.DIGEST_REQUEST_MSG:
+				d.handleDigestRequest(message)
+			case catalog.SERVERS_MSG:
+				d.mergeServers(message)
+			case keyring.KEY_REQUEST_MSG:
+				if d.Keys != nil {
+					// Replying blocks on the network, so don't hold up the others
+					go d.Keys.HandleRequest(message)
+				}
+			case keyring.KEY_RESPONSE_MSG:
+				if d.Keys != nil {
+					d.Keys.HandleResponse(message)
+				}
+			default:
+				entry, err := service.Decode(message)
+				if err != nil {
+					log.Errorf("Start(): error decoding message: %s", err)
+					continue
+				}
+				d.state.UpdateService(*entry)
 			}
-			d.state.UpdateService(*entry)
 		}
 	}()
 
@@ -59,14 +72,23 @@ func (d *servicesDelegate) Start() {
 	d.StartedAt = time.Now().UTC()
 }
 
+// SetMemberlist gives us the memberlist we are the delegate for, once it has
+// been created. We need it to send messages directly to our peers.
+func (d *servicesDelegate) SetMemberlist(list *memberlist.Memberlist) {
+	d.listLock.Lock()
+	defer d.listLock.Unlock()
+	d.list = list
+}
+
+func (d *servicesDelegate) getMemberlist() *memberlist.Memberlist {
+	d.listLock.RLock()
+	defer d.listLock.RUnlock()
+	return d.list
+}
+
 func (d *servicesDelegate) NodeMeta(limit int) []byte {
 	log.Debugf("NodeMeta(): %d", limit)
-	data, err := json.Marshal(d.Metadata)
-	if err != nil {
-		log.Error("Error encoding Node metadata!")
-		data = []byte("{}")
-	}
-	return data
+	return d.Metadata.Encode(limit)
 }
 
 func (d *servicesDelegate) NotifyMsg(message []byte) {
@@ -77,50 +99,43 @@ func (d *servicesDelegate) NotifyMsg(message []byte) {
 		return
 	}
 
-	log.Debugf("NotifyMsg(): %s", string(message))
+	// Key requests hold the keys and the token, so never log them
+	if message[0] == keyring.KEY_REQUEST_MSG {
+		log.Debug("NotifyMsg(): key request")
+	} else {
+		log.Debugf("NotifyMsg(): %s", string(message))
+	}
 
 	d.notifications <- message
 }
 
+// PendingBroadcasts returns the number of broadcasts from the state that
+// we haven't handed to memberlist yet
+func (d *servicesDelegate) PendingBroadcasts() int {
+	return len(d.state.Broadcasts) + d.broadcasts.Len()
+}
+
 func (d *servicesDelegate) GetBroadcasts(overhead, limit int) [][]byte {
 	defer metrics.MeasureSince([]string{"delegate", "GetBroadcasts"}, time.Now())
-	metrics.SetGauge([]string{"delegate", "pendingBroadcasts"}, float32(len(d.pendingBroadcasts)))
 
 	log.Debugf("GetBroadcasts(): %d %d", overhead, limit)
 
-	var broadcast [][]byte
-
-	select {
-	case broadcast = <-d.state.Broadcasts:
-	default:
-		if len(d.pendingBroadcasts) < 1 {
-			return nil
+	// Queue up everything the state has sent us since last time
+	for done := false; !done; {
+		select {
+		case broadcast := <-d.state.Broadcasts:
+			for _, message := range broadcast {
+				d.broadcasts.Push(message)
+			}
+		default:
+			done = true
 		}
 	}
 
-	// Prefer newest messages (TODO what about tombstones?). We use the one new
-	// broadcast and then append all the pending ones to see if we can get
-	// them into the packet.
-	if len(d.pendingBroadcasts) > 0 {
-		broadcast = append(broadcast, d.pendingBroadcasts...)
-	}
-	broadcast, leftover := d.packPacket(broadcast, limit, overhead)
-
-	if len(leftover) > 0 {
-		// We don't want to store old messages forever, or starve ourselves to death
-		if len(leftover) > MAX_PENDING_LENGTH {
-			d.pendingBroadcasts = leftover[:MAX_PENDING_LENGTH]
-		} else {
-			d.pendingBroadcasts = leftover
-		}
+	metrics.SetGauge([]string{"delegate", "pendingBroadcasts"}, float32(d.broadcasts.Len()))
 
-		log.Debugf("Leaving %d messages unsent", len(leftover))
-	} else {
-		d.pendingBroadcasts = [][]byte{}
-	}
-
-	if broadcast == nil || len(broadcast) < 1 {
-		log.Debug("Note: Not enough space to fit any messages or message was nil")
+	broadcast := d.broadcasts.Pop(overhead, limit)
+	if len(broadcast) < 1 {
 		return nil
 	}
 
@@ -145,14 +160,28 @@ func (d *servicesDelegate) GetBroadcasts(overhead, limit int) [][]byte {
 
 func (d *servicesDelegate) LocalState(join bool) []byte {
 	log.Debugf("LocalState(): %t", join)
+
+	// Peers that are joining get everything. So does everyone until we
+	// can receive their requests for the servers that differ.
+	list := d.getMemberlist()
+
 	d.state.RLock()
 	defer d.state.RUnlock()
-	return d.state.Encode()
+
+	if !d.DigestSync || join || list == nil {
+		return d.state.EncodeWire()
+	}
+	return d.state.EncodeDigest(list.LocalNode().Name)
 }
 
 func (d *servicesDelegate) MergeRemoteState(buf []byte, join bool) {
 	defer metrics.MeasureSince([]string{"delegate", "MergeRemoteState"}, time.Now())
 
+	if len(buf) > 0 && buf[0] == catalog.DIGEST_MSG {
+		d.mergeDigest(buf)
+		return
+	}
+
 	log.Debugf("MergeRemoteState(): %s %t", string(buf), join)
 
 	otherState, err := catalog.Decode(buf)
@@ -166,58 +195,105 @@ func (d *servicesDelegate) MergeRemoteState(buf []byte, join bool) {
 	d.state.Merge(otherState)
 }
 
-func (d *servicesDelegate) NotifyJoin(node *memberlist.Node) {
-	log.Debugf("NotifyJoin(): %s %s", node.Name, string(node.Meta))
+// mergeDigest compares a peer's digest with our state, and asks the peer for
+// the servers that differ
+func (d *servicesDelegate) mergeDigest(buf []byte) {
+	digest, err := catalog.DecodeDigest(buf)
+	if err != nil {
+		log.Errorf("Failed to decode digest: %s", err)
+		return
+	}
+
+	d.state.RLock()
+	names := d.state.DiffDigest(digest)
+	d.state.RUnlock()
+
+	log.Debugf("Digest from %s differs on %d servers", digest.Node, len(names))
+	metrics.IncrCounter([]string{"delegate", "digestServersRequested"}, float32(len(names)))
+
+	if len(names) == 0 {
+		return
+	}
+
+	list := d.getMemberlist()
+	if list == nil {
+		return
+	}
+
+	// Don't hold up memberlist's push/pull while we send
+	go d.sendTo(digest.Node, catalog.EncodeDigestRequest(list.LocalNode().Name, names))
 }
 
-func (d *servicesDelegate) NotifyLeave(node *memberlist.Node) {
-	log.Debugf("NotifyLeave(): %s", node.Name)
-	go d.state.ExpireServer(node.Name)
+// handleDigestRequest sends a peer the servers it asked for
+func (d *servicesDelegate) handleDigestRequest(message []byte) {
+	node, names, err := catalog.DecodeDigestRequest(message)
+	if err != nil {
+		log.Errorf("Failed to decode digest request: %s", err)
+		return
+	}
+
+	d.state.RLock()
+	encoded := d.state.EncodeServers(names)
+	d.state.RUnlock()
+
+	if len(encoded) == 0 {
+		return
+	}
+
+	go d.sendTo(node, encoded)
 }
 
-func (d *servicesDelegate) NotifyUpdate(node *memberlist.Node) {
-	log.Debugf("NotifyUpdate(): %s", node.Name)
+// mergeServers merges the servers a peer sent us after we asked for them
+func (d *servicesDelegate) mergeServers(message []byte) {
+	otherState, err := catalog.DecodeServers(message)
+	if err != nil {
+		log.Errorf("Failed to decode servers: %s", err)
+		return
+	}
+
+	d.state.Merge(otherState)
 }
 
-// Try to pack as many messages into the packet as we can. Note that this
-// assumes that no messages will be longer than the normal UDP packet size.
-// This means that max message length is somewhere around 1398 when taking
-// messaging overhead into account.
-func (d *servicesDelegate) packPacket(broadcasts [][]byte, limit int, overhead int) (packet [][]byte, leftover [][]byte) {
-	total := 0
-	lastItem := -1
+// sendTo sends a message to the named member of the cluster over TCP
+func (d *servicesDelegate) sendTo(name string, message []byte) {
+	list := d.getMemberlist()
+	if list == nil {
+		return
+	}
 
-	// Find the index of the last item that fits into the packet we're building
-	for i, message := range broadcasts {
-		if total+len(message)+overhead > limit {
-			break
+	for _, node := range list.Members() {
+		if node.Name != name {
+			continue
 		}
 
-		lastItem = i
-		total += len(message) + overhead
+		err := list.SendReliable(node, message)
+		if err != nil {
+			log.Warnf("Unable to send anti-entropy message to %s: %s", name, err)
+		}
+		return
 	}
 
-	if lastItem < 0 && len(broadcasts) > 0 {
-		// Don't warn on startup... it's fairly normal
-		gracePeriod := time.Now().UTC().Add(0 - (5 * time.Second))
-		if d.StartedAt.Before(gracePeriod) {
-			// Sample this so that we don't go apeshit logging when there is something
-			// a bit blocked up. We'll log 1/50th of the time.
-			if rand.Intn(50) == 1 {
-				log.Warnf("All messages were too long to fit! No broadcasts!")
-			}
-		}
+	log.Warnf("Unable to send anti-entropy message to %s: not a member", name)
+}
 
-		// There could be a scenario here where one hugely long broadcast could
-		// get stuck forever and prevent anything else from going out. There
-		// may be a better way to handle this. Scanning for the next message that
-		// does fit results in lots of memory copying and doesn't perform at scale.
-		return nil, broadcasts
+func (d *servicesDelegate) NotifyJoin(node *memberlist.Node) {
+	log.Debugf("NotifyJoin(): %s %s", node.Name, string(node.Meta))
+	if d.Members != nil {
+		d.Members.NotifyJoin(node)
 	}
+}
 
-	// Save the leftover messages after the last one that fit. If this is too
-	// much, then set it to the lastItem.
-	firstLeftover := lastItem + 1
+func (d *servicesDelegate) NotifyLeave(node *memberlist.Node) {
+	log.Debugf("NotifyLeave(): %s", node.Name)
+	if d.Members != nil {
+		d.Members.NotifyLeave(node)
+	}
+	go d.state.ExpireServer(node.Name)
+}
 
-	return broadcasts[:lastItem+1], broadcasts[firstLeftover:]
+func (d *servicesDelegate) NotifyUpdate(node *memberlist.Node) {
+	log.Debugf("NotifyUpdate(): %s", node.Name)
+	if d.Members != nil {
+		d.Members.NotifyUpdate(node)
+	}
 }
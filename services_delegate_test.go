@@ -1,12 +1,33 @@
 package main
 
 import (
+	"fmt"
 	"testing"
+	"time"
 
 	"github.com/Nitro/sidecar/catalog"
+	"github.com/Nitro/sidecar/service"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
+func asStrings(messages [][]byte) []string {
+	var result []string
+	for _, message := range messages {
+		result = append(result, string(message))
+	}
+	return result
+}
+
+// asBroadcasts wraps encoded services up the way the state sends them
+func asBroadcasts(messages [][]byte) []catalog.Broadcast {
+	var result []catalog.Broadcast
+	for _, message := range messages {
+		svc, _ := service.Decode(message)
+		result = append(result, catalog.Broadcast{Service: svc, Message: message})
+	}
+	return result
+}
+
 func Test_GetBroadcasts(t *testing.T) {
 	Convey("When handing back broadcast messages", t, func() {
 		state := catalog.NewServicesState()
@@ -42,65 +63,119 @@ func Test_GetBroadcasts(t *testing.T) {
 				So(delegate.GetBroadcasts(3, 1398), ShouldBeNil)
 			})
 
-			Convey("Returns from the pending list when nothing in the channel", func() {
-				data := []byte("data")
-				delegate.pendingBroadcasts = [][]byte{data}
+			Convey("Returns from the queue when nothing in the channel", func() {
+				delegate.broadcasts.Push(asBroadcasts(bCast)[0])
 
 				result := delegate.GetBroadcasts(3, 1398)
-				So(string(result[0]), ShouldEqual, string(data))
+				So(string(result[0]), ShouldEqual, string(bCast[0]))
 				So(len(result), ShouldEqual, 1)
 			})
 
 			Convey("Returns what's in the channel", func() {
-				state.Broadcasts = make(chan [][]byte, 1)
-				state.Broadcasts <- bCast
+				state.Broadcasts = make(chan []catalog.Broadcast, 1)
+				state.Broadcasts <- asBroadcasts(bCast)
 				result := delegate.GetBroadcasts(3, 1398)
 
 				So(len(result), ShouldEqual, 2)
-				So(string(result[0]), ShouldEqual, string(bCast[0]))
-				So(string(result[1]), ShouldEqual, string(bCast[1]))
-				So(len(delegate.pendingBroadcasts), ShouldEqual, 0)
+				So(asStrings(result), ShouldContain, string(bCast[0]))
+				So(asStrings(result), ShouldContain, string(bCast[1]))
+				So(delegate.broadcasts.Len(), ShouldEqual, 0)
 			})
 
-			Convey("Returns what's left when nothing is new", func() {
-				delegate.pendingBroadcasts = bCast
+			Convey("Drains everything in the channel", func() {
+				state.Broadcasts = make(chan []catalog.Broadcast, 3)
+				state.Broadcasts <- asBroadcasts(bCast)
+				state.Broadcasts <- nil
+				state.Broadcasts <- asBroadcasts(bCast2)
+				result := delegate.GetBroadcasts(3, 1398)
+
+				So(len(result), ShouldEqual, 3) // One is in both
+				So(len(state.Broadcasts), ShouldEqual, 0)
+			})
+
+			Convey("Only sends a service once", func() {
+				state.Broadcasts = make(chan []catalog.Broadcast, 1)
+				delegate.broadcasts.Push(asBroadcasts(bCast)[1])
+				state.Broadcasts <- asBroadcasts(bCast2)
 
 				result := delegate.GetBroadcasts(3, 1398)
 				So(len(result), ShouldEqual, 2)
-				So(string(result[0]), ShouldEqual, string(bCast[0]))
-				So(string(result[1]), ShouldEqual, string(bCast[1]))
-				So(len(delegate.pendingBroadcasts), ShouldEqual, 0)
+				So(asStrings(result), ShouldContain, string(bCast2[0]))
+				So(asStrings(result), ShouldContain, string(bCast2[1]))
 			})
 
-			Convey("Returns what's left and what's new when it fits", func() {
-				state.Broadcasts = make(chan [][]byte, 1)
-				delegate.pendingBroadcasts = bCast
-				state.Broadcasts <- bCast2
+			Convey("Leaves what doesn't fit for the next packet", func() {
+				state.Broadcasts = make(chan []catalog.Broadcast, 1)
+				state.Broadcasts <- asBroadcasts(append(bCast2, bCast...))
+
+				So(delegate.GetBroadcasts(3, 100), ShouldBeNil)
+				So(len(delegate.GetBroadcasts(3, 300)), ShouldEqual, 1) // 1 message fits here
+				So(delegate.GetBroadcasts(3, 100), ShouldBeNil)
 
 				result := delegate.GetBroadcasts(3, 1398)
-				So(len(result), ShouldEqual, 4)
-				for i, entry := range append(bCast2, bCast...) {
-					So(string(result[i]), ShouldEqual, string(entry))
-				}
-				So(len(delegate.pendingBroadcasts), ShouldEqual, 0)
+				So(len(result), ShouldEqual, 2)
+				So(delegate.broadcasts.Len(), ShouldEqual, 0)
 			})
+		})
+	})
+}
 
-			Convey("Many runs with leftovers don't leave junk or bad buffers", func() {
-				state.Broadcasts = make(chan [][]byte, 1)
-				delegate.pendingBroadcasts = bCast
-				state.Broadcasts <- append(bCast2, bCast...)
+func Test_StateExchange(t *testing.T) {
+	Convey("When exchanging state with peers", t, func() {
+		state := catalog.NewServicesState()
+		delegate := NewServicesDelegate(state)
+
+		svc := service.Service{
+			ID:       "deadbeef123",
+			Name:     "beowulf",
+			Hostname: "chaucer",
+			Updated:  time.Now().UTC(),
+			Status:   service.ALIVE,
+		}
+		state.AddServiceEntry(svc)
 
-				delegate.GetBroadcasts(3, 100)
-				delegate.GetBroadcasts(3, 300) // 1 message fits here
-				delegate.GetBroadcasts(3, 100)
+		otherState := catalog.NewServicesState()
+		otherDelegate := NewServicesDelegate(otherState)
 
-				result := delegate.GetBroadcasts(3, 1398)
-				So(len(result), ShouldEqual, 5)
-				for i, entry := range append(bCast2[1:], bCast...) {
-					So(string(result[i]), ShouldEqual, string(entry))
-				}
-				So(len(delegate.pendingBroadcasts), ShouldEqual, 0)
+		for _, format := range []service.WireFormat{service.JSON_FORMAT, service.MSGPACK_FORMAT, service.COMPRESSED_FORMAT} {
+			format := format
+
+			Convey(fmt.Sprintf("merges the state in wire format %d", format), func() {
+				state.WireFormat = format
+				otherDelegate.MergeRemoteState(delegate.LocalState(false), false)
+
+				merged := <-otherState.ServiceMsgs
+				So(merged.ID, ShouldEqual, svc.ID)
+				So(merged.Updated.Equal(svc.Updated), ShouldBeTrue)
 			})
+		}
+
+		Convey("sends the compact format when configured", func() {
+			state.WireFormat = service.MSGPACK_FORMAT
+			So(delegate.LocalState(false)[0], ShouldEqual, service.WIRE_VERSION_MSGPACK)
+		})
+
+		Convey("sends the whole state without a memberlist to take requests", func() {
+			delegate.DigestSync = true
+			So(delegate.LocalState(false)[0], ShouldEqual, '{')
+		})
+
+		Convey("ignores digests that match our state", func() {
+			otherState.AddServiceEntry(svc)
+			state.RLock()
+			digest := state.EncodeDigest("other-node")
+			state.RUnlock()
+
+			otherDelegate.MergeRemoteState(digest, false)
+			So(len(otherState.ServiceMsgs), ShouldEqual, 0)
+		})
+
+		Convey("merges the servers a peer sends back", func() {
+			otherDelegate.Start()
+			otherDelegate.NotifyMsg(state.EncodeServers([]string{"chaucer"}))
+
+			merged := <-otherState.ServiceMsgs
+			So(merged.ID, ShouldEqual, svc.ID)
 		})
 	})
 }
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Nitro/memberlist"
+	"github.com/Nitro/sidecar/catalog"
+	"github.com/Nitro/sidecar/service"
+	"github.com/relistan/go-director"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	SHUTDOWN_TOMBSTONE_COUNT = 3                     // Send our tombstones 3 times on the way out
+	LEAVE_TIMEOUT            = 5 * time.Second       // How long we wait for the cluster to hear we left
+	BROADCAST_FLUSH_TIMEOUT  = 3 * time.Second       // How long we wait for our last broadcasts to go out
+	BROADCAST_FLUSH_POLL     = 50 * time.Millisecond // How often we check whether they went out
+)
+
+// A shutdownSequence leaves the cluster in an orderly fashion so that our
+// peers hear about our services going away right away, rather than when
+// memberlist notices we're gone or the services expire.
+type shutdownSequence struct {
+	State        *catalog.ServicesState
+	List         *memberlist.Memberlist
+	Delegate     *servicesDelegate
+	GracePeriod  time.Duration      // How long to leave our services DRAINING. Zero to skip it
+	Loopers      []director.Looper  // The loopers that announce our services and rejoin the cluster
+	Snapshots    director.Looper    // The looper writing state snapshots. Nil when they're disabled
+	SnapshotFile string             // Where the final snapshot goes
+	Cancel       context.CancelFunc // Stops the HTTP and gRPC servers
+	Servers      *sync.WaitGroup    // Done when the servers have stopped
+	StopProfiler func()
+}
+
+// waitForSignal blocks until we get a SIGTERM or SIGINT. A second signal
+// while we are shutting down exits immediately.
+func waitForSignal() {
+	sigChannel := make(chan os.Signal, 1)
+	signal.Notify(sigChannel, syscall.SIGTERM, os.Interrupt)
+
+	sig := <-sigChannel
+	log.Warnf("Captured %v, shutting down. Signal again to exit immediately.", sig)
+
+	go func() {
+		sig := <-sigChannel
+		log.Warnf("Captured %v, exiting now", sig)
+		os.Exit(1)
+	}()
+}
+
+// Run drains and tombstones our services, tells the cluster, leaves it, and
+// then stops the servers.
+func (s *shutdownSequence) Run() {
+	// Stop announcing our services, otherwise discovery would bring them
+	// right back. Stop rejoining too, we're about to leave.
+	for _, looper := range s.Loopers {
+		looper.Quit()
+	}
+	for _, looper := range s.Loopers {
+		looper.Wait()
+	}
+
+	s.writeFinalSnapshot()
+
+	if s.GracePeriod > 0 {
+		drained := s.State.DrainLocalServices()
+		if len(drained) > 0 {
+			log.Infof("Draining %d services for %s", len(drained), s.GracePeriod)
+			s.State.SendServices(
+				drained,
+				director.NewTimedLooper(catalog.ALIVE_COUNT, catalog.TOMBSTONE_RETRANSMIT, nil),
+			)
+			time.Sleep(s.GracePeriod)
+		}
+	}
+
+	s.State.Lock()
+	tombstones := s.State.TombstoneServices(s.State.Hostname, []service.Service{})
+	s.State.Unlock()
+
+	if len(tombstones) > 0 {
+		log.Infof("Announcing tombstones for %d services", countServices(tombstones))
+		looper := director.NewTimedLooper(
+			SHUTDOWN_TOMBSTONE_COUNT, catalog.TOMBSTONE_RETRANSMIT, make(chan error, 1),
+		)
+		s.State.SendServices(tombstones, looper)
+		looper.Wait()
+	}
+
+	// Memberlist only sends broadcasts while we're a member, so give the
+	// gossip a chance to pick up the tombstones before we leave
+	s.waitForBroadcasts(BROADCAST_FLUSH_TIMEOUT)
+
+	log.Info("Leaving the cluster")
+	err := s.List.Leave(LEAVE_TIMEOUT)
+	if err != nil {
+		log.Warnf("Error leaving the cluster: %s", err)
+	}
+	err = s.List.Shutdown()
+	if err != nil {
+		log.Warnf("Error shutting down memberlist: %s", err)
+	}
+
+	s.Cancel()
+	s.Servers.Wait()
+
+	if s.StopProfiler != nil {
+		s.StopProfiler()
+	}
+
+	log.Info("Shutdown complete")
+}
+
+// writeFinalSnapshot stops the snapshot looper and writes one last snapshot
+// of the state before we drain and tombstone our services. Otherwise the
+// snapshot would be up to SNAPSHOT_INTERVAL old, or hold our tombstones.
+func (s *shutdownSequence) writeFinalSnapshot() {
+	if s.Snapshots == nil {
+		return
+	}
+
+	s.Snapshots.Quit()
+	s.Snapshots.Wait()
+
+	err := s.State.WriteSnapshot(s.SnapshotFile)
+	if err != nil {
+		log.Errorf("Unable to write the final state snapshot %s: %s", s.SnapshotFile, err)
+	}
+}
+
+// waitForBroadcasts waits until the delegate has handed all the pending
+// broadcasts to memberlist, or the timeout runs out.
+func (s *shutdownSequence) waitForBroadcasts(timeout time.Duration) {
+	if s.Delegate == nil {
+		return
+	}
+
+	deadline := time.Now().Add(timeout)
+	for s.Delegate.PendingBroadcasts() > 0 {
+		if time.Now().After(deadline) {
+			log.Warnf("Leaving with %d broadcasts still pending", s.Delegate.PendingBroadcasts())
+			return
+		}
+		time.Sleep(BROADCAST_FLUSH_POLL)
+	}
+}
+
+// countServices returns the number of distinct services in the list, which
+// may hold more than one record for each.
+func countServices(services []service.Service) int {
+	ids := make(map[string]struct{}, len(services))
+	for _, svc := range services {
+		ids[svc.ID] = struct{}{}
+	}
+
+	return len(ids)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Nitro/sidecar/catalog"
+	"github.com/Nitro/sidecar/service"
+	"github.com/relistan/go-director"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_shutdownSequence(t *testing.T) {
+	Convey("When shutting down", t, func() {
+		state := catalog.NewServicesState()
+		delegate := NewServicesDelegate(state)
+		shutdown := &shutdownSequence{State: state, Delegate: delegate}
+
+		svc := service.Service{ID: "deadbeef123", Hostname: "chaucer", Status: service.TOMBSTONE}
+		encoded, err := svc.Encode()
+		So(err, ShouldBeNil)
+
+		Convey("counts each service once", func() {
+			other := service.Service{ID: "deadbeef456"}
+			So(countServices([]service.Service{svc, svc, other, other}), ShouldEqual, 2)
+			So(countServices(nil), ShouldEqual, 0)
+		})
+
+		Convey("doesn't wait when there are no pending broadcasts", func() {
+			started := time.Now()
+			shutdown.waitForBroadcasts(time.Second)
+
+			So(time.Since(started), ShouldBeLessThan, 500*time.Millisecond)
+		})
+
+		Convey("waits for the pending broadcasts to go out", func() {
+			state.Broadcasts <- []catalog.Broadcast{{Service: &svc, Message: encoded}}
+			So(delegate.PendingBroadcasts(), ShouldEqual, 1)
+
+			go func() {
+				time.Sleep(100 * time.Millisecond)
+				delegate.GetBroadcasts(3, 1398)
+			}()
+
+			started := time.Now()
+			shutdown.waitForBroadcasts(5 * time.Second)
+
+			So(time.Since(started), ShouldBeLessThan, 5*time.Second)
+			So(delegate.PendingBroadcasts(), ShouldEqual, 0)
+		})
+
+		Convey("gives up when the deadline passes", func() {
+			state.Broadcasts <- []catalog.Broadcast{{Service: &svc, Message: encoded}}
+
+			started := time.Now()
+			shutdown.waitForBroadcasts(100 * time.Millisecond)
+
+			So(time.Since(started), ShouldBeGreaterThanOrEqualTo, 100*time.Millisecond)
+			So(delegate.PendingBroadcasts(), ShouldEqual, 1)
+		})
+
+		Convey("stops the snapshots and writes a final one", func() {
+			dir, err := ioutil.TempDir("", "shutdown")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+
+			state.Hostname = "chaucer"
+			alive := service.Service{ID: "deadbeef789", Hostname: "chaucer", Status: service.ALIVE, Updated: time.Now().UTC()}
+			state.AddServiceEntry(alive)
+
+			looper := director.NewTimedLooper(director.FOREVER, time.Hour, make(chan error, 1))
+			go state.WriteSnapshots(filepath.Join(dir, "snapshot"), looper)
+
+			shutdown.Snapshots = looper
+			shutdown.SnapshotFile = filepath.Join(dir, "snapshot")
+			shutdown.writeFinalSnapshot()
+
+			restored := catalog.NewServicesState()
+			So(restored.LoadSnapshot(shutdown.SnapshotFile), ShouldBeNil)
+			So(restored.HasServer("chaucer"), ShouldBeTrue)
+			So(restored.Servers["chaucer"].Services["deadbeef789"], ShouldNotBeNil)
+		})
+
+		Convey("skips the snapshot when they're disabled", func() {
+			shutdown.writeFinalSnapshot()
+		})
+	})
+}
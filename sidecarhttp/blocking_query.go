@@ -0,0 +1,129 @@
+package sidecarhttp
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+	log "github.com/sirupsen/logrus"
+)
+
+// Blocking queries let clients long-poll the services endpoints, like they
+// would with Consul. Responses carry the revision of the state in the
+// X-Sidecar-Index header. A client that passes it back in ?index= doesn't
+// get an answer until the state moves past it, or ?wait= runs out. Each
+// response also has an ETag, so a client that polls with If-None-Match
+// gets a 304 when nothing changed.
+
+const (
+	INDEX_HEADER          = "X-Sidecar-Index"
+	DEFAULT_BLOCKING_WAIT = 5 * time.Minute
+	MAX_BLOCKING_WAIT     = 10 * time.Minute
+)
+
+// blockingQuery waits until a service that matches changes after the index
+// the client gave us, if it gave us one. The match func gets the status the
+// service had before the change too. A nil match func matches all the
+// services. It returns false when the handler should not respond, because
+// we sent an error or the client went away.
+func (s *SidecarApi) blockingQuery(response http.ResponseWriter, req *http.Request,
+	matches func(svc *service.Service, previousStatus int) bool) bool {
+	query := req.URL.Query()
+	if len(query.Get("index")) == 0 {
+		return true
+	}
+
+	index, err := strconv.ParseUint(query.Get("index"), 10, 64)
+	if err != nil {
+		sendJsonError(response, 400, fmt.Sprintf("Bad request - Invalid index: %s", err))
+		return false
+	}
+
+	wait := DEFAULT_BLOCKING_WAIT
+	if len(query.Get("wait")) > 0 {
+		wait, err = time.ParseDuration(query.Get("wait"))
+		if err != nil || wait <= 0 {
+			sendJsonError(response, 400, fmt.Sprintf("Bad request - Invalid wait: %q", query.Get("wait")))
+			return false
+		}
+	}
+
+	if wait > MAX_BLOCKING_WAIT {
+		wait = MAX_BLOCKING_WAIT
+	}
+
+	// Subscribe before we look at the revision so we don't miss a change
+	// in between
+	listener := NewHttpListener()
+	s.state.AddListener(listener)
+	defer func() {
+		err := s.state.RemoveListener(listener.Name())
+		if err != nil {
+			log.Warnf("Failed to remove HTTP listener: %s", err)
+		}
+	}()
+
+	s.state.RLock()
+	revision := s.state.Revision()
+	s.state.RUnlock()
+
+	// Either the state already moved on, or the index isn't one of ours,
+	// e.g. from another node or from before a restart
+	if revision != index {
+		return true
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-req.Context().Done():
+			return false
+
+		case <-timer.C:
+			return true
+
+		case changed := <-listener.Chan():
+			if changed.Revision > index && (matches == nil || matches(&changed.Service, changed.PreviousStatus)) {
+				return true
+			}
+		}
+	}
+}
+
+// setIndex sends the revision of the state the response came from
+func setIndex(response http.ResponseWriter, revision uint64) {
+	response.Header().Set(INDEX_HEADER, strconv.FormatUint(revision, 10))
+}
+
+// sendWithETag writes the response with an ETag, or sends a 304 if the
+// client already has it
+func sendWithETag(response http.ResponseWriter, req *http.Request, jsonBytes []byte) error {
+	etag := fmt.Sprintf(`"%x"`, sha1.Sum(jsonBytes))
+	response.Header().Set("ETag", etag)
+
+	if etagMatches(req.Header.Get("If-None-Match"), etag) {
+		response.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	_, err := response.Write(jsonBytes)
+	return err
+}
+
+// etagMatches tells if any of the ETags in an If-None-Match header is ours
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,168 @@
+package sidecarhttp
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Nitro/sidecar/catalog"
+	"github.com/Nitro/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_blockingQueries(t *testing.T) {
+	Convey("Blocking queries on the services endpoints", t, func() {
+		hostname := "chaucer"
+		state := catalog.NewServicesState()
+		state.Hostname = hostname
+		state.Servers[hostname] = catalog.NewServer(hostname)
+
+		baseTime := time.Now().UTC()
+
+		svc := service.Service{
+			ID:       "deadbeef123",
+			Name:     "bocaccio",
+			Image:    "101deadbeef",
+			Created:  baseTime,
+			Hostname: hostname,
+			Updated:  baseTime,
+			Status:   service.ALIVE,
+		}
+		state.AddServiceEntry(svc)
+
+		revision := state.Revision()
+
+		api := &SidecarApi{state: state}
+		recorder := httptest.NewRecorder()
+		params := map[string]string{"extension": "json"}
+
+		// Changes a service after a little while, in the background
+		changeLater := func(svc service.Service) {
+			go func() {
+				time.Sleep(20 * time.Millisecond)
+				svc.Status = service.UNHEALTHY
+				svc.Updated = svc.Updated.Add(time.Second)
+				state.AddServiceEntry(svc)
+			}()
+		}
+
+		Convey("send the index", func() {
+			req := httptest.NewRequest("GET", "/services.json", nil)
+			api.servicesHandler(recorder, req, params)
+
+			So(recorder.Code, ShouldEqual, 200)
+			So(recorder.Header().Get(INDEX_HEADER), ShouldEqual, strconv.FormatUint(revision, 10))
+		})
+
+		Convey("return right away when the state already moved past the index", func() {
+			req := httptest.NewRequest("GET", fmt.Sprintf("/services.json?index=%d&wait=10s", revision-1), nil)
+
+			started := time.Now()
+			api.servicesHandler(recorder, req, params)
+
+			So(time.Since(started), ShouldBeLessThan, time.Second)
+			So(recorder.Code, ShouldEqual, 200)
+			So(recorder.Body.String(), ShouldContainSubstring, "bocaccio")
+		})
+
+		Convey("return when the state changes", func() {
+			req := httptest.NewRequest("GET", fmt.Sprintf("/services.json?index=%d&wait=10s", revision), nil)
+
+			changeLater(svc)
+			started := time.Now()
+			api.servicesHandler(recorder, req, params)
+
+			So(time.Since(started), ShouldBeLessThan, 5*time.Second)
+			So(recorder.Code, ShouldEqual, 200)
+			So(recorder.Header().Get(INDEX_HEADER), ShouldEqual, strconv.FormatUint(revision+1, 10))
+		})
+
+		Convey("return when the wait runs out", func() {
+			req := httptest.NewRequest("GET", fmt.Sprintf("/services.json?index=%d&wait=20ms", revision), nil)
+
+			started := time.Now()
+			api.servicesHandler(recorder, req, params)
+
+			So(time.Since(started), ShouldBeGreaterThanOrEqualTo, 20*time.Millisecond)
+			So(recorder.Code, ShouldEqual, 200)
+			So(recorder.Header().Get(INDEX_HEADER), ShouldEqual, strconv.FormatUint(revision, 10))
+		})
+
+		Convey("don't respond when the client goes away", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			req := httptest.NewRequest("GET", fmt.Sprintf("/services.json?index=%d", revision), nil)
+			api.servicesHandler(recorder, req.WithContext(ctx), params)
+
+			So(recorder.Body.String(), ShouldBeEmpty)
+		})
+
+		Convey("only return for changes to the service that was asked for", func() {
+			other := svc
+			other.ID = "deadbeef456"
+			other.Name = "shakespeare"
+			changeLater(other)
+
+			req := httptest.NewRequest("GET", fmt.Sprintf("/services/bocaccio.json?index=%d&wait=100ms", revision), nil)
+			params["name"] = "bocaccio"
+
+			started := time.Now()
+			api.oneServiceHandler(recorder, req, params)
+
+			So(time.Since(started), ShouldBeGreaterThanOrEqualTo, 100*time.Millisecond)
+			So(recorder.Code, ShouldEqual, 200)
+			So(recorder.Header().Get(INDEX_HEADER), ShouldEqual, strconv.FormatUint(revision+1, 10))
+		})
+
+		Convey("reject a bad index or wait", func() {
+			req := httptest.NewRequest("GET", "/services.json?index=junk", nil)
+			api.servicesHandler(recorder, req, params)
+
+			So(recorder.Code, ShouldEqual, 400)
+			So(recorder.Body.String(), ShouldContainSubstring, "Invalid index")
+
+			recorder = httptest.NewRecorder()
+			req = httptest.NewRequest("GET", fmt.Sprintf("/services.json?index=%d&wait=-1s", revision), nil)
+			api.servicesHandler(recorder, req, params)
+
+			So(recorder.Code, ShouldEqual, 400)
+			So(recorder.Body.String(), ShouldContainSubstring, "Invalid wait")
+		})
+
+		Convey("send an ETag", func() {
+			req := httptest.NewRequest("GET", "/services.json", nil)
+			api.servicesHandler(recorder, req, params)
+
+			etag := recorder.Header().Get("ETag")
+			So(etag, ShouldNotBeEmpty)
+
+			Convey("and a 304 when the client already has it", func() {
+				recorder = httptest.NewRecorder()
+				req := httptest.NewRequest("GET", "/services.json", nil)
+				req.Header.Set("If-None-Match", `"junk", W/`+etag)
+				api.servicesHandler(recorder, req, params)
+
+				So(recorder.Code, ShouldEqual, 304)
+				So(recorder.Body.String(), ShouldBeEmpty)
+			})
+
+			Convey("and the new version when it changed", func() {
+				svc.Status = service.UNHEALTHY
+				svc.Updated = svc.Updated.Add(time.Second)
+				state.AddServiceEntry(svc)
+
+				recorder = httptest.NewRecorder()
+				req := httptest.NewRequest("GET", "/services.json", nil)
+				req.Header.Set("If-None-Match", etag)
+				api.servicesHandler(recorder, req, params)
+
+				So(recorder.Code, ShouldEqual, 200)
+				So(recorder.Header().Get("ETag"), ShouldNotEqual, etag)
+			})
+		})
+	})
+}
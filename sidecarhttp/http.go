@@ -1,19 +1,31 @@
 package sidecarhttp
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 	_ "net/http/pprof"
 	"time"
 
 	"github.com/Nitro/memberlist"
 	"github.com/Nitro/sidecar/catalog"
+	"github.com/Nitro/sidecar/discovery"
+	"github.com/Nitro/sidecar/healthy"
+	"github.com/Nitro/sidecar/keyring"
+	"github.com/Nitro/sidecar/members"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	HTTP_PORT        = 7777            // The port we serve the API and UI on
+	SHUTDOWN_TIMEOUT = 5 * time.Second // How long we wait for requests to finish on shutdown
+)
+
 type HttpConfig struct {
 	BindIP       string
 	UseHostnames bool
+	KeysToken    string // Needed to change the gossip keys over the API
 }
 
 func makeHandler(fn func(http.ResponseWriter, *http.Request,
@@ -53,12 +65,20 @@ func uiRedirectHandler(response http.ResponseWriter, req *http.Request) {
 	http.Redirect(response, req, "/ui/", 301)
 }
 
-func ServeHttp(list *memberlist.Memberlist, state *catalog.ServicesState, config *HttpConfig) {
+// ServeHttp runs the HTTP server until the context is cancelled, then shuts
+// it down gracefully. It returns once the server has stopped.
+func ServeHttp(ctx context.Context, list *memberlist.Memberlist, state *catalog.ServicesState,
+	monitor *healthy.Monitor, registrar *discovery.ApiDiscovery, keys *keyring.Exchange,
+	tracker *members.Tracker, config *HttpConfig) {
+
 	srvrsHandle := makeHandler(serversHandler, list, state)
 	staticFs := http.FileServer(http.Dir("views/static"))
 	uiFs := http.FileServer(http.Dir("ui/app"))
 
-	api := &SidecarApi{state: state, list: list}
+	api := &SidecarApi{
+		state: state, list: list, monitor: monitor, registrar: registrar, tracker: tracker,
+		keys: keys.Manager, exchange: keys, keysToken: config.KeysToken,
+	}
 	envoyApi := &EnvoyApi{state: state, list: list, config: config}
 
 	router := mux.NewRouter()
@@ -77,8 +97,26 @@ func ServeHttp(list *memberlist.Memberlist, state *catalog.ServicesState, config
 
 	http.Handle("/", router)
 
-	err := http.ListenAndServe("0.0.0.0:7777", nil)
-	if err != nil {
+	server := &http.Server{Addr: fmt.Sprintf("0.0.0.0:%d", HTTP_PORT)}
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), SHUTDOWN_TIMEOUT)
+		defer cancel()
+
+		err := server.Shutdown(shutdownCtx)
+		if err != nil {
+			log.Warnf("HTTP server did not shut down cleanly: %s", err)
+		}
+	}()
+
+	err := server.ListenAndServe()
+	if err != http.ErrServerClosed {
 		log.Fatalf("Can't start HTTP server: %s", err)
 	}
+
+	<-stopped
 }
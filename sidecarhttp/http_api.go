@@ -10,6 +10,10 @@ import (
 
 	"github.com/Nitro/memberlist"
 	"github.com/Nitro/sidecar/catalog"
+	"github.com/Nitro/sidecar/discovery"
+	"github.com/Nitro/sidecar/healthy"
+	"github.com/Nitro/sidecar/keyring"
+	"github.com/Nitro/sidecar/members"
 	"github.com/Nitro/sidecar/service"
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
@@ -19,6 +23,8 @@ type ApiServer struct {
 	Name         string
 	LastUpdated  time.Time
 	ServiceCount int
+	ClockSkew    time.Duration         // How far its clock is ahead of ours, in nanoseconds
+	Metadata     *catalog.NodeMetadata `json:",omitempty"`
 }
 
 type ApiServices struct {
@@ -27,9 +33,48 @@ type ApiServices struct {
 	ClusterName    string
 }
 
+// ApiMember is what we know about a member of the cluster, from memberlist
+// and from the services it sends us
+type ApiMember struct {
+	ApiServer
+	State        string // alive, suspect or dead
+	Addr         string
+	Port         uint16
+	Protocol     ApiProtocol
+	LastHeard    time.Time
+	RTT          time.Duration       `json:",omitempty"` // Of the last probe, in nanoseconds
+	Coordinate   *members.Coordinate `json:",omitempty"`
+	EstimatedRTT time.Duration       `json:",omitempty"` // From our coordinate to its coordinate
+}
+
+// ApiProtocol holds the memberlist protocol versions a member understands
+type ApiProtocol struct {
+	Min             uint8
+	Max             uint8
+	Current         uint8
+	DelegateMin     uint8
+	DelegateMax     uint8
+	DelegateCurrent uint8
+}
+
+type ApiEvents struct {
+	Events []catalog.JournalEvent
+}
+
+type ApiMembers struct {
+	Members     map[string]*ApiMember
+	ClusterName string
+}
+
 type SidecarApi struct {
-	list  *memberlist.Memberlist
-	state *catalog.ServicesState
+	list      *memberlist.Memberlist
+	state     *catalog.ServicesState
+	monitor   *healthy.Monitor
+	registrar *discovery.ApiDiscovery
+	keys      *keyring.Manager
+	exchange  *keyring.Exchange // Passes key changes on to our peers
+	keysToken string            // Needed to change the keys
+	tracker   *members.Tracker
 }
 
 func (s *SidecarApi) HttpMux() http.Handler {
@@ -38,7 +83,15 @@ func (s *SidecarApi) HttpMux() http.Handler {
 	router.HandleFunc("/services/{id}/drain", wrap(s.drainServiceHandler)).Methods("POST")
 	router.HandleFunc("/services.{extension}", wrap(s.servicesHandler)).Methods("GET")
 	router.HandleFunc("/state.{extension}", wrap(s.stateHandler)).Methods("GET")
+	router.HandleFunc("/members.{extension}", wrap(s.membersHandler)).Methods("GET")
+	router.HandleFunc("/events.{extension}", wrap(s.eventsHandler)).Methods("GET")
+	router.HandleFunc("/checks/{id}.{extension}", wrap(s.oneCheckHandler)).Methods("GET")
+	router.HandleFunc("/checks.{extension}", wrap(s.checksHandler)).Methods("GET")
+	router.HandleFunc("/local/services/{id}", wrap(s.registerServiceHandler)).Methods("PUT")
+	router.HandleFunc("/local/services/{id}", wrap(s.deregisterServiceHandler)).Methods("DELETE")
 	router.HandleFunc("/watch", wrap(s.watchHandler)).Methods("GET")
+	router.HandleFunc("/keys.{extension}", wrap(s.keysHandler)).Methods("GET")
+	router.HandleFunc("/keys/{op}", wrap(s.changeKeysHandler)).Methods("POST")
 	router.HandleFunc("/{path}", s.optionsHandler).Methods("OPTIONS")
 
 	return router
@@ -53,12 +106,31 @@ func (s *SidecarApi) optionsHandler(response http.ResponseWriter, req *http.Requ
 // watchHandler takes an optional GET parameter, "by_service"
 // By default, watchHandler returns `json.Marshal(state.ByService())` payloads
 // If the client passes "by_service=false", watchHandler returns `json.Marshal(state)` payloads
+// With "format=ndjson" or "format=sse", it streams each change instead. See watchStream().
+// The services can be filtered, see ServiceFilter.
 func (s *SidecarApi) watchHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
 	defer req.Body.Close()
 
+	filter, err := parseServiceFilter(req.URL.Query())
+	if err != nil {
+		sendJsonError(response, 400, fmt.Sprintf("Bad request - Invalid filter: %s", err))
+		return
+	}
+
+	switch format := req.URL.Query().Get("format"); format {
+	case "":
+	case WATCH_FORMAT_NDJSON, WATCH_FORMAT_SSE:
+		s.watchStream(response, req, format, filter)
+		return
+	default:
+		sendJsonError(response, 400, fmt.Sprintf("Bad request - Unknown format %q", format))
+		return
+	}
+
 	response.Header().Set("Content-Type", "application/json")
 
 	listener := NewHttpListener()
+	listener.filter = filter
 
 	// Let's subscribe to state change events
 	// AddListener and RemoveListener are thread safe
@@ -80,7 +152,7 @@ func (s *SidecarApi) watchHandler(response http.ResponseWriter, req *http.Reques
 		if byService {
 			s.state.RLock()
 			var err error
-			jsonBytes, err = json.Marshal(s.state.ByService())
+			jsonBytes, err = json.Marshal(s.byService(filter))
 			s.state.RUnlock()
 
 			if err != nil {
@@ -88,7 +160,7 @@ func (s *SidecarApi) watchHandler(response http.ResponseWriter, req *http.Reques
 			}
 		} else {
 			s.state.RLock()
-			jsonBytes = s.state.Encode()
+			jsonBytes = s.encodeState(filter)
 			s.state.RUnlock()
 		}
 
@@ -107,7 +179,7 @@ func (s *SidecarApi) watchHandler(response http.ResponseWriter, req *http.Reques
 	}
 
 	// Push the first update right away
-	err := pushUpdate()
+	err = pushUpdate()
 	if err != nil {
 		log.Errorf("Error marshaling state in watchHandler: %s", err.Error())
 		return
@@ -155,12 +227,25 @@ func (s *SidecarApi) oneServiceHandler(response http.ResponseWriter, req *http.R
 		return
 	}
 
+	// Blocking queries only care about changes to this service
+	ok = s.blockingQuery(response, req, func(svc *service.Service, previousStatus int) bool {
+		return svc.Name == name
+	})
+	if !ok {
+		return
+	}
+
+	// Instances must have all of the tags we're asked for, e.g.
+	// ?tag=zone:us-east-1a&tag=canary
+	tags := req.URL.Query()["tag"]
+
 	var instances []*service.Service
 	// Enter critical section
 	s.state.RLock()
 	defer s.state.RUnlock()
+	setIndex(response, s.state.Revision())
 	s.state.EachService(func(hostname *string, id *string, svc *service.Service) {
-		if svc.Name == name {
+		if svc.Name == name && hasAllTags(svc, tags) {
 			instances = append(instances, svc)
 		}
 	})
@@ -192,7 +277,7 @@ func (s *SidecarApi) oneServiceHandler(response http.ResponseWriter, req *http.R
 		return
 	}
 
-	_, err = response.Write(jsonBytes)
+	err = sendWithETag(response, req, jsonBytes)
 	if err != nil {
 		log.Errorf("Error writing one service response to client: %s", err)
 	}
@@ -213,41 +298,29 @@ func (s *SidecarApi) servicesHandler(response http.ResponseWriter, req *http.Req
 
 	response.Header().Set("Content-Type", "application/json")
 
-	var listMembers []*memberlist.Node
-	var clusterName string
-	if s.list != nil {
-		listMembers = s.list.Members()
-		sort.Sort(catalog.ListByName(listMembers))
-		clusterName = s.list.ClusterName()
+	filter, err := parseServiceFilter(req.URL.Query())
+	if err != nil {
+		sendJsonError(response, 400, fmt.Sprintf("Bad request - Invalid filter: %s", err))
+		return
 	}
 
-	members := make(map[string]*ApiServer, len(listMembers))
+	if !s.blockingQuery(response, req, filter.MatchesChange) {
+		return
+	}
+
+	listMembers, clusterName := s.members()
 
 	var jsonBytes []byte
-	var err error
 
 	func() { // Wrap critical section
 		s.state.RLock()
 		defer s.state.RUnlock()
 
-		for _, member := range listMembers {
-			if s.state.HasServer(member.Name) {
-				members[member.Name] = &ApiServer{
-					Name:         member.Name,
-					LastUpdated:  s.state.Servers[member.Name].LastUpdated,
-					ServiceCount: len(s.state.Servers[member.Name].Services),
-				}
-			} else {
-				members[member.Name] = &ApiServer{
-					Name:         member.Name,
-					LastUpdated:  time.Unix(0, 0),
-					ServiceCount: 0,
-				}
-			}
-		}
+		setIndex(response, s.state.Revision())
+		members := s.apiServers(listMembers)
 
 		result := ApiServices{
-			Services:       s.state.ByService(),
+			Services:       s.byService(filter),
 			ClusterMembers: members,
 			ClusterName:    clusterName,
 		}
@@ -261,12 +334,209 @@ func (s *SidecarApi) servicesHandler(response http.ResponseWriter, req *http.Req
 		return
 	}
 
-	_, err = response.Write(jsonBytes)
+	err = sendWithETag(response, req, jsonBytes)
 	if err != nil {
 		log.Errorf("Error writing services response to client: %s", err)
 	}
 }
 
+// membersHandler returns the members of the cluster, including the ones
+// that recently left, how they look from here, what we know about their
+// services, and the metadata they publish
+func (s *SidecarApi) membersHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	response.Header().Set("Access-Control-Allow-Origin", "*")
+	response.Header().Set("Access-Control-Allow-Methods", "GET")
+
+	if params["extension"] != "json" {
+		sendJsonError(response, 404, "Not Found - Invalid content type extension")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+
+	listMembers, clusterName := s.members()
+
+	s.state.RLock()
+	result := ApiMembers{
+		Members:     s.apiMembers(listMembers),
+		ClusterName: clusterName,
+	}
+	s.state.RUnlock()
+
+	jsonBytes, err := json.MarshalIndent(&result, "", "  ")
+	if err != nil {
+		log.Errorf("Error marshaling members in membersHandler: %s", err)
+		sendJsonError(response, 500, "Internal server error")
+		return
+	}
+
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing members response to client: %s", err)
+	}
+}
+
+// members returns the memberlist members sorted by name, and the name of
+// the cluster
+func (s *SidecarApi) members() ([]*memberlist.Node, string) {
+	if s.list == nil {
+		return nil, ""
+	}
+
+	listMembers := s.list.Members()
+	sort.Sort(catalog.ListByName(listMembers))
+
+	return listMembers, s.list.ClusterName()
+}
+
+// apiServers describes each of the members, by name
+// Note: Not synchronized!
+func (s *SidecarApi) apiServers(listMembers []*memberlist.Node) map[string]*ApiServer {
+	members := make(map[string]*ApiServer, len(listMembers))
+
+	for _, member := range listMembers {
+		members[member.Name] = s.apiServer(member)
+	}
+
+	return members
+}
+
+// apiMembers describes each of the members we track, by name. Without a
+// tracker, all we have are the live memberlist members.
+// Note: Not synchronized!
+func (s *SidecarApi) apiMembers(listMembers []*memberlist.Node) map[string]*ApiMember {
+	if s.tracker == nil {
+		result := make(map[string]*ApiMember, len(listMembers))
+		for _, node := range listMembers {
+			member := s.apiMember(node)
+			member.State = members.ALIVE
+			result[node.Name] = member
+		}
+		return result
+	}
+
+	tracked := s.tracker.Members()
+	ourCoordinate := s.tracker.Coordinate()
+
+	result := make(map[string]*ApiMember, len(tracked))
+	for _, trackedMember := range tracked {
+		member := s.apiMember(&trackedMember.Node)
+		member.State = trackedMember.State
+		member.LastHeard = trackedMember.LastHeard
+		member.RTT = trackedMember.RTT
+		member.Coordinate = trackedMember.Coordinate
+
+		if ourCoordinate != nil && member.Coordinate != nil && member.Name != s.state.Hostname {
+			member.EstimatedRTT = ourCoordinate.DistanceTo(member.Coordinate)
+		}
+
+		result[member.Name] = member
+	}
+
+	return result
+}
+
+// Note: Not synchronized!
+func (s *SidecarApi) apiMember(node *memberlist.Node) *ApiMember {
+	return &ApiMember{
+		ApiServer: *s.apiServer(node),
+		Addr:      node.Addr.String(),
+		Port:      node.Port,
+		Protocol: ApiProtocol{
+			Min:             node.PMin,
+			Max:             node.PMax,
+			Current:         node.PCur,
+			DelegateMin:     node.DMin,
+			DelegateMax:     node.DMax,
+			DelegateCurrent: node.DCur,
+		},
+	}
+}
+
+// Note: Not synchronized!
+func (s *SidecarApi) apiServer(member *memberlist.Node) *ApiServer {
+	server := &ApiServer{
+		Name:        member.Name,
+		LastUpdated: time.Unix(0, 0),
+	}
+
+	if s.state.HasServer(member.Name) {
+		server.LastUpdated = s.state.Servers[member.Name].LastUpdated
+		server.ServiceCount = len(s.state.Servers[member.Name].Services)
+		server.ClockSkew = s.state.ClockSkew(member.Name)
+	}
+
+	if len(member.Meta) > 0 {
+		meta, err := catalog.DecodeNodeMetadata(member.Meta)
+		if err != nil {
+			log.Warnf("Unable to decode the metadata for %s: %s", member.Name, err)
+		} else {
+			server.Metadata = meta
+		}
+	}
+
+	return server
+}
+
+// eventsHandler returns the recent changes to services, oldest first. They
+// can be narrowed down to one service, by name or ID, and to the changes
+// after a time, given as RFC 3339 or as a duration ago.
+func (s *SidecarApi) eventsHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	response.Header().Set("Access-Control-Allow-Origin", "*")
+	response.Header().Set("Access-Control-Allow-Methods", "GET")
+
+	if params["extension"] != "json" {
+		sendJsonError(response, 404, "Not Found - Invalid content type extension")
+		return
+	}
+
+	since, err := parseSince(req.URL.Query().Get("since"))
+	if err != nil {
+		sendJsonError(response, 400, fmt.Sprintf("Bad request - Invalid since: %s", err))
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+
+	result := ApiEvents{
+		Events: s.state.Journal.Events(req.URL.Query().Get("service"), since),
+	}
+	if result.Events == nil {
+		result.Events = []catalog.JournalEvent{}
+	}
+
+	jsonBytes, err := json.MarshalIndent(&result, "", "  ")
+	if err != nil {
+		log.Errorf("Error marshaling events in eventsHandler: %s", err)
+		sendJsonError(response, 500, "Internal server error")
+		return
+	}
+
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing events response to client: %s", err)
+	}
+}
+
+// parseSince parses a time like 2018-06-13T12:10:14Z, or a duration ago
+// like 2h. An empty string is the beginning of time.
+func parseSince(since string) (time.Time, error) {
+	if len(since) == 0 {
+		return time.Time{}, nil
+	}
+
+	ago, err := time.ParseDuration(since)
+	if err == nil {
+		return time.Now().UTC().Add(-ago), nil
+	}
+
+	return time.Parse(time.RFC3339, since)
+}
+
 // stateHandler simply dumps the JSON output of the whole state object. This is
 // useful for listeners or other clients that need a full state dump on startup.
 func (s *SidecarApi) stateHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
@@ -284,12 +554,161 @@ func (s *SidecarApi) stateHandler(response http.ResponseWriter, req *http.Reques
 	response.Header().Set("Access-Control-Allow-Origin", "*")
 	response.Header().Set("Access-Control-Allow-Methods", "GET")
 
-	_, err := response.Write(s.state.Encode())
+	filter, err := parseServiceFilter(req.URL.Query())
+	if err != nil {
+		sendJsonError(response, 400, fmt.Sprintf("Bad request - Invalid filter: %s", err))
+		return
+	}
+
+	_, err = response.Write(s.encodeState(filter))
 	if err != nil {
 		log.Errorf("Error writing state response to client: %s", err)
 	}
 }
 
+// checksHandler returns the status and recent history of all the local
+// health checks
+func (s *SidecarApi) checksHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	response.Header().Set("Access-Control-Allow-Origin", "*")
+	response.Header().Set("Access-Control-Allow-Methods", "GET")
+
+	if params["extension"] != "json" {
+		sendJsonError(response, 404, "Not Found - Invalid content type extension")
+		return
+	}
+
+	checks := []*healthy.CheckStatus{}
+	if s.monitor != nil {
+		checks = s.monitor.CheckStatuses()
+	}
+
+	jsonBytes, err := json.MarshalIndent(checks, "", "  ")
+	if err != nil {
+		log.Errorf("Error marshaling checks in checksHandler: %s", err.Error())
+		sendJsonError(response, 500, "Internal server error")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing checks response to client: %s", err)
+	}
+}
+
+// oneCheckHandler returns the status and recent history of a single local
+// health check
+func (s *SidecarApi) oneCheckHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	response.Header().Set("Access-Control-Allow-Origin", "*")
+	response.Header().Set("Access-Control-Allow-Methods", "GET")
+
+	if params["extension"] != "json" {
+		sendJsonError(response, 404, "Not Found - Invalid content type extension")
+		return
+	}
+
+	checkID := params["id"]
+
+	var check *healthy.CheckStatus
+	if s.monitor != nil {
+		check = s.monitor.CheckStatus(checkID)
+	}
+
+	if check == nil {
+		sendJsonError(response, 404, fmt.Sprintf("Not Found - Check ID %q not found", checkID))
+		return
+	}
+
+	jsonBytes, err := json.MarshalIndent(check, "", "  ")
+	if err != nil {
+		log.Errorf("Error marshaling check in oneCheckHandler: %s", err.Error())
+		sendJsonError(response, 500, "Internal server error")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing check response to client: %s", err)
+	}
+}
+
+// registerServiceHandler registers a local service with the API discoverer,
+// or refreshes its registration if it was already registered.
+func (s *SidecarApi) registerServiceHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if s.registrar == nil {
+		sendJsonError(response, 404, "Not Found - API discovery is not enabled")
+		return
+	}
+
+	var registration discovery.ApiRegistration
+	err := json.NewDecoder(req.Body).Decode(&registration)
+	if err != nil {
+		sendJsonError(response, 400, fmt.Sprintf("Bad request - Invalid registration: %s", err))
+		return
+	}
+
+	svc, err := s.registrar.Register(params["id"], &registration)
+	if err != nil {
+		sendJsonError(response, 400, fmt.Sprintf("Bad request - %s", err))
+		return
+	}
+
+	jsonBytes, err := json.MarshalIndent(svc, "", "  ")
+	if err != nil {
+		log.Errorf("Error marshaling service in registerServiceHandler: %s", err.Error())
+		sendJsonError(response, 500, "Internal server error")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing register service response to client: %s", err)
+	}
+}
+
+// deregisterServiceHandler removes a local service from the API discoverer.
+// Sidecar will then tombstone it like any other service that went away.
+func (s *SidecarApi) deregisterServiceHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if s.registrar == nil {
+		sendJsonError(response, 404, "Not Found - API discovery is not enabled")
+		return
+	}
+
+	serviceID := params["id"]
+	err := s.registrar.Deregister(serviceID)
+	if err != nil {
+		sendJsonError(response, 404, fmt.Sprintf("Not Found - Service ID %q not found", serviceID))
+		return
+	}
+
+	result := struct {
+		Message string
+	}{
+		Message: fmt.Sprintf("Service instance %q deregistered", serviceID),
+	}
+	jsonBytes, err := json.MarshalIndent(&result, "", "  ")
+	if err != nil {
+		sendJsonError(response, 500, "Internal Server Error - Something went terribly wrong")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing deregister service response to client: %s", err)
+	}
+}
+
 // drainServiceHandler instructs Sidecar to set the status of a given service
 // instance to DRAINING. This allows us to decomission the given service
 // instance and let it sit around for a short amount of time, so it can finish
@@ -319,7 +738,7 @@ func (s *SidecarApi) drainServiceHandler(response http.ResponseWriter, req *http
 		return
 	}
 
-	svc.Updated = time.Now()
+	svc.Updated, svc.Logical = s.state.Clock.Now()
 	svc.Status = service.DRAINING
 	s.state.UpdateService(svc)
 
@@ -342,6 +761,16 @@ func (s *SidecarApi) drainServiceHandler(response http.ResponseWriter, req *http
 	}
 }
 
+func hasAllTags(svc *service.Service, tags []string) bool {
+	for _, tag := range tags {
+		if !svc.HasTag(tag) {
+			return false
+		}
+	}
+
+	return true
+}
+
 // Send back a JSON encoded error and message
 func sendJsonError(response http.ResponseWriter, status int, message string) {
 	output := map[string]string{
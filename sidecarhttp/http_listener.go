@@ -3,6 +3,7 @@ package sidecarhttp
 import (
 	"fmt"
 	_ "net/http/pprof"
+	"sync/atomic"
 	"time"
 
 	"github.com/Nitro/sidecar/catalog"
@@ -12,12 +13,18 @@ import (
 type HttpListener struct {
 	eventChan chan catalog.ChangeEvent
 	name      string
+	filter    *ServiceFilter // Only the matching services wake us up
+	missed    uint32         // Set when we had no room for a change we wanted
 }
 
+// Makes the names unique when many clients connect at once
+var httpListenerSeq uint64
+
 func NewHttpListener() *HttpListener {
 	return &HttpListener{
-		// This should be fine enough granularity for practical purposes
-		name: fmt.Sprintf("httpListener-%d", time.Now().UTC().UnixNano()),
+		name: fmt.Sprintf("httpListener-%d-%d",
+			time.Now().UTC().UnixNano(), atomic.AddUint64(&httpListenerSeq, 1),
+		),
 		// Listeners must have buffered channels. We'll use a
 		// somewhat larger buffer here because of the slow link
 		// problem with http
@@ -36,3 +43,25 @@ func (h *HttpListener) Name() string {
 func (h *HttpListener) Managed() bool {
 	return false
 }
+
+// Wants tells the state which changes to send us. We skip the changes we
+// want but have no room for too, and remember that we missed them, so the
+// watch stream can tell them apart from the ones the filter skipped.
+func (h *HttpListener) Wants(event *catalog.ChangeEvent) bool {
+	if !h.filter.MatchesChange(&event.Service, event.PreviousStatus) {
+		return false
+	}
+
+	if len(h.eventChan) == cap(h.eventChan) {
+		atomic.StoreUint32(&h.missed, 1)
+		return false
+	}
+
+	return true
+}
+
+// Missed tells if we missed any changes we wanted since the last time we
+// were asked
+func (h *HttpListener) Missed() bool {
+	return atomic.SwapUint32(&h.missed, 0) == 1
+}
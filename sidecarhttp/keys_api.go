@@ -0,0 +1,232 @@
+package sidecarhttp
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/Nitro/sidecar/keyring"
+	log "github.com/sirupsen/logrus"
+)
+
+// The keys API manages the gossip encryption keyring on every node in the
+// cluster. The node that gets the request applies it to itself and passes it
+// on to each of the other members over memberlist, which encrypts it with
+// the gossip keys. Keys are never sent back, we only show their fingerprints.
+// Changing the keys needs the token that every node shares, in a "Bearer"
+// Authorization header.
+
+// authorizeKeys checks the token on a request to change the keys. Without a
+// token configured, nobody can change them over the API.
+func (s *SidecarApi) authorizeKeys(response http.ResponseWriter, req *http.Request) bool {
+	if len(s.keysToken) == 0 {
+		sendJsonError(response, 403, "Forbidden - Changing keys needs SIDECAR_KEYS_TOKEN to be set")
+		return false
+	}
+
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.keysToken)) != 1 {
+		sendJsonError(response, 401, "Unauthorized - Invalid keys token")
+		return false
+	}
+
+	return true
+}
+
+// A KeyRequest is the body of a request to change the keyring
+type KeyRequest struct {
+	Key string // Base64 encoded
+}
+
+// NodeKeys are the fingerprints of the keys installed on one node
+type NodeKeys struct {
+	Node       string
+	PrimaryKey string
+	Keys       []string
+}
+
+// ClusterKeys are the keys installed across the cluster, and the errors from
+// any nodes that we could not list or change
+type ClusterKeys struct {
+	Nodes  []*NodeKeys
+	Errors map[string]string `json:",omitempty"`
+}
+
+// keysHandler lists the keys installed on each node
+func (s *SidecarApi) keysHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if params["extension"] != "json" {
+		sendJsonError(response, 404, "Not Found - Invalid content type extension")
+		return
+	}
+
+	if !s.keys.Enabled() {
+		sendJsonError(response, 404, "Not Found - Gossip encryption is not enabled")
+		return
+	}
+
+	if req.URL.Query().Get("local") == "true" {
+		sendKeysJson(response, 200, s.localKeys())
+		return
+	}
+
+	local := func() (*NodeKeys, error) { return s.localKeys(), nil }
+	s.sendClusterKeys(response, local, keyring.LIST_KEYS, "")
+}
+
+// changeKeysHandler installs a key, makes it the primary key, or removes it
+func (s *SidecarApi) changeKeysHandler(response http.ResponseWriter, req *http.Request, params map[string]string) {
+	defer req.Body.Close()
+
+	if !s.keys.Enabled() {
+		sendJsonError(response, 404, "Not Found - Gossip encryption is not enabled")
+		return
+	}
+
+	if !s.authorizeKeys(response, req) {
+		return
+	}
+
+	// Browsers must ask before sending JSON to another origin, and we
+	// never say yes. That stops web pages from changing our keys.
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		sendJsonError(response, 415, "Unsupported Media Type - Expected application/json")
+		return
+	}
+
+	var change func(string) error
+	switch params["op"] {
+	case keyring.INSTALL_KEY:
+		change = s.keys.Install
+	case keyring.USE_KEY:
+		change = s.keys.Use
+	case keyring.REMOVE_KEY:
+		change = s.keys.Remove
+	default:
+		sendJsonError(response, 404, fmt.Sprintf("Not Found - Unknown operation %q", params["op"]))
+		return
+	}
+
+	clusterWide := req.URL.Query().Get("local") != "true"
+
+	// Without encryption both ways, the change could go out in the clear
+	if clusterWide && !s.exchange.CanChange() {
+		sendJsonError(response, 403,
+			"Forbidden - Changes are only passed on when SIDECAR_GOSSIP_VERIFY_INCOMING and "+
+				"SIDECAR_GOSSIP_VERIFY_OUTGOING are true, use ?local=true on each host instead",
+		)
+		return
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		sendJsonError(response, 400, fmt.Sprintf("Bad request - %s", err))
+		return
+	}
+
+	var keyReq KeyRequest
+	err = json.Unmarshal(body, &keyReq)
+	if err != nil {
+		sendJsonError(response, 400, fmt.Sprintf("Bad request - Invalid key request: %s", err))
+		return
+	}
+
+	local := func() (*NodeKeys, error) {
+		err := change(keyReq.Key)
+		if err != nil {
+			return nil, err
+		}
+		log.Warnf("Gossip keyring changed: %s", params["op"])
+		return s.localKeys(), nil
+	}
+
+	if !clusterWide {
+		nodeKeys, err := local()
+		if err != nil {
+			sendJsonError(response, 400, fmt.Sprintf("Bad request - %s", err))
+			return
+		}
+		sendKeysJson(response, 200, nodeKeys)
+		return
+	}
+
+	s.sendClusterKeys(response, local, params["op"], keyReq.Key)
+}
+
+// localKeys returns the fingerprints of the keys on this node
+func (s *SidecarApi) localKeys() *NodeKeys {
+	primary, keys := s.keys.Fingerprints()
+	return &NodeKeys{Node: s.localNodeName(), PrimaryKey: primary, Keys: keys}
+}
+
+func (s *SidecarApi) localNodeName() string {
+	if s.list == nil {
+		return "localhost"
+	}
+	return s.list.LocalNode().Name
+}
+
+// sendClusterKeys runs the request locally, then passes it on to every other
+// member of the cluster, and sends back all the results. Any error makes it
+// a 500.
+func (s *SidecarApi) sendClusterKeys(response http.ResponseWriter, local func() (*NodeKeys, error),
+	op string, key string) {
+
+	result := &ClusterKeys{Errors: make(map[string]string)}
+
+	// Always do our own first. If it fails here, it will fail everywhere.
+	nodeKeys, err := local()
+	if err != nil {
+		result.Errors[s.localNodeName()] = err.Error()
+		sendKeysJson(response, 500, result)
+		return
+	}
+	result.Nodes = append(result.Nodes, nodeKeys)
+
+	for _, peer := range s.exchange.Request(op, key) {
+		if len(peer.Error) > 0 {
+			result.Errors[peer.Node] = peer.Error
+			continue
+		}
+		result.Nodes = append(result.Nodes, &NodeKeys{
+			Node: peer.Node, PrimaryKey: peer.PrimaryKey, Keys: peer.Keys,
+		})
+	}
+
+	sort.Sort(nodeKeysByName(result.Nodes))
+
+	status := 200
+	if len(result.Errors) > 0 {
+		status = 500
+	}
+	sendKeysJson(response, status, result)
+}
+
+func sendKeysJson(response http.ResponseWriter, status int, result interface{}) {
+	jsonBytes, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		log.Errorf("Error marshaling keys: %s", err)
+		sendJsonError(response, 500, "Internal server error")
+		return
+	}
+
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(status)
+	_, err = response.Write(jsonBytes)
+	if err != nil {
+		log.Errorf("Error writing keys response to client: %s", err)
+	}
+}
+
+type nodeKeysByName []*NodeKeys
+
+func (k nodeKeysByName) Len() int           { return len(k) }
+func (k nodeKeysByName) Swap(i, j int)      { k[i], k[j] = k[j], k[i] }
+func (k nodeKeysByName) Less(i, j int) bool { return k[i].Node < k[j].Node }
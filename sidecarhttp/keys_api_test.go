@@ -0,0 +1,158 @@
+package sidecarhttp
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Nitro/sidecar/keyring"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_keysHandlers(t *testing.T) {
+	Convey("The gossip keys handlers", t, func() {
+		first, _ := keyring.GenerateKey()
+		second, _ := keyring.GenerateKey()
+		firstKey, _ := keyring.DecodeKey(first)
+		secondKey, _ := keyring.DecodeKey(second)
+
+		ring, err := keyring.Load(first, "")
+		So(err, ShouldBeNil)
+
+		manager := keyring.NewManager(ring, "")
+		api := &SidecarApi{
+			keys: manager, exchange: keyring.NewExchange(manager, "beowulf", true), keysToken: "beowulf",
+		}
+		recorder := httptest.NewRecorder()
+
+		keyBody := `{"Key": "` + second + `"}`
+
+		postKey := func(op string, query string) {
+			req := httptest.NewRequest("POST", "/keys/"+op+query, strings.NewReader(keyBody))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer beowulf")
+			api.changeKeysHandler(recorder, req, map[string]string{"op": op})
+		}
+
+		Convey("list the local keys", func() {
+			req := httptest.NewRequest("GET", "/keys.json?local=true", nil)
+			api.keysHandler(recorder, req, map[string]string{"extension": "json"})
+
+			status, headers, body := getResult(recorder)
+			So(status, ShouldEqual, 200)
+			So(headers.Get("Content-Type"), ShouldEqual, "application/json")
+
+			var nodeKeys NodeKeys
+			So(json.Unmarshal([]byte(body), &nodeKeys), ShouldBeNil)
+			So(nodeKeys.PrimaryKey, ShouldEqual, keyring.Fingerprint(firstKey))
+			So(nodeKeys.Keys, ShouldResemble, []string{keyring.Fingerprint(firstKey)})
+		})
+
+		Convey("never send back the keys themselves", func() {
+			req := httptest.NewRequest("GET", "/keys.json", nil)
+			api.keysHandler(recorder, req, map[string]string{"extension": "json"})
+
+			_, _, body := getResult(recorder)
+			So(body, ShouldNotContainSubstring, first)
+		})
+
+		Convey("install a key across the cluster", func() {
+			postKey("install", "")
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 200)
+
+			var result ClusterKeys
+			So(json.Unmarshal([]byte(body), &result), ShouldBeNil)
+			So(result.Errors, ShouldBeEmpty)
+			So(len(result.Nodes), ShouldEqual, 1)
+			So(result.Nodes[0].Keys, ShouldContain, keyring.Fingerprint(secondKey))
+			So(ring.GetPrimaryKey(), ShouldResemble, firstKey)
+		})
+
+		Convey("install and use a key locally", func() {
+			postKey("install", "?local=true")
+			recorder = httptest.NewRecorder()
+			postKey("use", "?local=true")
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 200)
+			So(body, ShouldContainSubstring, `"PrimaryKey": "`+keyring.Fingerprint(secondKey)+`"`)
+			So(ring.GetPrimaryKey(), ShouldResemble, secondKey)
+		})
+
+		Convey("report errors from the keyring", func() {
+			postKey("use", "")
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 500)
+			So(body, ShouldContainSubstring, "not in the keyring")
+		})
+
+		Convey("reject unknown operations", func() {
+			postKey("shred", "")
+
+			status, _, _ := getResult(recorder)
+			So(status, ShouldEqual, 404)
+		})
+
+		Convey("reject requests without the token", func() {
+			req := httptest.NewRequest("POST", "/keys/install", strings.NewReader(keyBody))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Authorization", "Bearer grendel")
+			api.changeKeysHandler(recorder, req, map[string]string{"op": "install"})
+
+			status, _, _ := getResult(recorder)
+			So(status, ShouldEqual, 401)
+			So(len(ring.GetKeys()), ShouldEqual, 1)
+		})
+
+		Convey("refuse changes when there is no token", func() {
+			api.keysToken = ""
+			postKey("install", "?local=true")
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 403)
+			So(body, ShouldContainSubstring, "SIDECAR_KEYS_TOKEN")
+			So(len(ring.GetKeys()), ShouldEqual, 1)
+		})
+
+		Convey("only change the local keys when gossip isn't encrypted both ways", func() {
+			api.exchange.Encrypted = false
+			postKey("install", "")
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 403)
+			So(body, ShouldContainSubstring, "?local=true")
+			So(len(ring.GetKeys()), ShouldEqual, 1)
+
+			recorder = httptest.NewRecorder()
+			postKey("install", "?local=true")
+
+			status, _, _ = getResult(recorder)
+			So(status, ShouldEqual, 200)
+			So(len(ring.GetKeys()), ShouldEqual, 2)
+		})
+
+		Convey("reject requests that aren't JSON", func() {
+			req := httptest.NewRequest("POST", "/keys/install", strings.NewReader(keyBody))
+			req.Header.Set("Authorization", "Bearer beowulf")
+			api.changeKeysHandler(recorder, req, map[string]string{"op": "install"})
+
+			status, _, _ := getResult(recorder)
+			So(status, ShouldEqual, 415)
+			So(len(ring.GetKeys()), ShouldEqual, 1)
+		})
+
+		Convey("return a 404 when encryption is not enabled", func() {
+			api.keys = keyring.NewManager(nil, "")
+			req := httptest.NewRequest("GET", "/keys.json", nil)
+			api.keysHandler(recorder, req, map[string]string{"extension": "json"})
+
+			status, _, body := getResult(recorder)
+			So(status, ShouldEqual, 404)
+			So(body, ShouldContainSubstring, "not enabled")
+		})
+	})
+}
@@ -0,0 +1,136 @@
+package sidecarhttp
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+
+	"github.com/Nitro/sidecar/service"
+)
+
+// A ServiceFilter picks services by the query parameters on the request, e.g.
+// ?name=bocaccio&name=web-*&status=alive. Services have to match one of the
+// values we're given for each parameter, and all of the tags.
+type ServiceFilter struct {
+	Names     []string // Globs
+	Statuses  []int
+	Hostnames []string // Globs
+	Images    []string // Globs
+	Tags      []string // See service.HasTag()
+}
+
+// parseServiceFilter returns the filter from the query, or nil when there
+// isn't one
+func parseServiceFilter(query url.Values) (*ServiceFilter, error) {
+	filter := &ServiceFilter{
+		Names:     query["name"],
+		Hostnames: query["hostname"],
+		Images:    query["image"],
+		Tags:      query["tag"],
+	}
+
+	for _, status := range query["status"] {
+		parsed, err := service.StatusFromString(status)
+		if err != nil {
+			return nil, err
+		}
+		filter.Statuses = append(filter.Statuses, parsed)
+	}
+
+	// Catch bad patterns now rather than failing to match later
+	for _, patterns := range [][]string{filter.Names, filter.Hostnames, filter.Images} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q", pattern)
+			}
+		}
+	}
+
+	if len(filter.Names) == 0 && len(filter.Statuses) == 0 && len(filter.Hostnames) == 0 &&
+		len(filter.Images) == 0 && len(filter.Tags) == 0 {
+		return nil, nil
+	}
+
+	return filter, nil
+}
+
+// Matches tells if the service passes the filter. Everything passes a nil
+// filter.
+func (f *ServiceFilter) Matches(svc *service.Service) bool {
+	if f == nil {
+		return true
+	}
+
+	if len(f.Statuses) > 0 && !containsStatus(f.Statuses, svc.Status) {
+		return false
+	}
+
+	return matchesAny(f.Names, svc.Name) &&
+		matchesAny(f.Hostnames, svc.Hostname) &&
+		matchesAny(f.Images, svc.Image) &&
+		hasAllTags(svc, f.Tags)
+}
+
+// MatchesChange tells if the service passes the filter either before or
+// after a change, so that a client watching ?status=alive hears about a
+// service that stops being alive.
+func (f *ServiceFilter) MatchesChange(svc *service.Service, previousStatus int) bool {
+	if f.Matches(svc) {
+		return true
+	}
+
+	if f == nil || len(f.Statuses) == 0 || previousStatus == svc.Status {
+		return false
+	}
+
+	previous := *svc
+	previous.Status = previousStatus
+	return f.Matches(&previous)
+}
+
+// matchesAny tells if the value matches one of the patterns, or there are
+// no patterns
+func matchesAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+
+	for _, pattern := range patterns {
+		// We checked the patterns when we parsed them
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsStatus(statuses []int, status int) bool {
+	for _, candidate := range statuses {
+		if candidate == status {
+			return true
+		}
+	}
+
+	return false
+}
+
+// byService returns the services that pass the filter, grouped by name
+// Note: Not synchronized!
+func (s *SidecarApi) byService(filter *ServiceFilter) map[string][]*service.Service {
+	if filter == nil {
+		return s.state.ByService()
+	}
+
+	return s.state.Filtered(filter.Matches).ByService()
+}
+
+// encodeState returns the state, with only the services that pass the filter
+// Note: Not synchronized!
+func (s *SidecarApi) encodeState(filter *ServiceFilter) []byte {
+	if filter == nil {
+		return s.state.Encode()
+	}
+
+	return s.state.Filtered(filter.Matches).Encode()
+}
@@ -0,0 +1,264 @@
+package sidecarhttp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Nitro/sidecar/catalog"
+	"github.com/Nitro/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_ServiceFilter(t *testing.T) {
+	Convey("Filtering services", t, func() {
+		svc := &service.Service{
+			ID:       "deadbeef123",
+			Name:     "web-bocaccio",
+			Image:    "gonitro/bocaccio:1.2",
+			Hostname: "chaucer",
+			Status:   service.ALIVE,
+			Tags:     map[string]string{"zone": "us-east-1a"},
+		}
+
+		parse := func(query string) *ServiceFilter {
+			values, err := url.ParseQuery(query)
+			So(err, ShouldBeNil)
+			filter, err := parseServiceFilter(values)
+			So(err, ShouldBeNil)
+			return filter
+		}
+
+		Convey("returns no filter without any parameters", func() {
+			So(parse("by_service=false"), ShouldBeNil)
+			So(parse("").Matches(svc), ShouldBeTrue)
+		})
+
+		Convey("matches names, exactly or by glob", func() {
+			So(parse("name=web-bocaccio").Matches(svc), ShouldBeTrue)
+			So(parse("name=web-*").Matches(svc), ShouldBeTrue)
+			So(parse("name=shakespeare&name=*-bocaccio").Matches(svc), ShouldBeTrue)
+			So(parse("name=shakespeare").Matches(svc), ShouldBeFalse)
+		})
+
+		Convey("matches statuses, ignoring case", func() {
+			So(parse("status=alive").Matches(svc), ShouldBeTrue)
+			So(parse("status=Unhealthy&status=Alive").Matches(svc), ShouldBeTrue)
+			So(parse("status=tombstone").Matches(svc), ShouldBeFalse)
+		})
+
+		Convey("matches hostnames and images", func() {
+			So(parse("hostname=chaucer").Matches(svc), ShouldBeTrue)
+			So(parse("hostname=shakespeare").Matches(svc), ShouldBeFalse)
+			So(parse("image=gonitro/bocaccio:*").Matches(svc), ShouldBeTrue)
+			So(parse("image=gonitro/bocaccio:1.3").Matches(svc), ShouldBeFalse)
+		})
+
+		Convey("matches tags", func() {
+			So(parse("tag=zone:us-east-1a").Matches(svc), ShouldBeTrue)
+			So(parse("tag=zone&tag=canary").Matches(svc), ShouldBeFalse)
+		})
+
+		Convey("needs all the parameters to match", func() {
+			So(parse("name=web-*&status=alive&hostname=chaucer").Matches(svc), ShouldBeTrue)
+			So(parse("name=web-*&status=draining").Matches(svc), ShouldBeFalse)
+		})
+
+		Convey("matches changes into or out of the statuses", func() {
+			filter := parse("status=alive")
+			So(filter.MatchesChange(svc, service.UNHEALTHY), ShouldBeTrue)
+
+			svc.Status = service.TOMBSTONE
+			So(filter.MatchesChange(svc, service.ALIVE), ShouldBeTrue)
+			So(filter.MatchesChange(svc, service.UNHEALTHY), ShouldBeFalse)
+			So(parse("name=shakespeare").MatchesChange(svc, service.ALIVE), ShouldBeFalse)
+			So(parse("").MatchesChange(svc, service.ALIVE), ShouldBeTrue)
+		})
+
+		Convey("rejects unknown statuses and bad patterns", func() {
+			_, err := parseServiceFilter(url.Values{"status": {"sleepy"}})
+			So(err, ShouldNotBeNil)
+
+			_, err = parseServiceFilter(url.Values{"name": {"web-["}})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func Test_filteredEndpoints(t *testing.T) {
+	Convey("Filtering on the API endpoints", t, func() {
+		hostname := "chaucer"
+		state := catalog.NewServicesState()
+		state.Hostname = hostname
+		state.Servers[hostname] = catalog.NewServer(hostname)
+
+		baseTime := time.Now().UTC()
+
+		svc := service.Service{
+			ID:       "deadbeef123",
+			Name:     "bocaccio",
+			Image:    "101deadbeef",
+			Created:  baseTime,
+			Hostname: hostname,
+			Updated:  baseTime,
+			Status:   service.ALIVE,
+		}
+		other := svc
+		other.ID = "deadbeef456"
+		other.Name = "shakespeare"
+
+		state.AddServiceEntry(svc)
+		state.AddServiceEntry(other)
+
+		api := &SidecarApi{state: state}
+		recorder := httptest.NewRecorder()
+		params := map[string]string{"extension": "json"}
+
+		Convey("services only returns the matching services", func() {
+			req := httptest.NewRequest("GET", "/services.json?name=boc*", nil)
+			api.servicesHandler(recorder, req, params)
+
+			So(recorder.Code, ShouldEqual, 200)
+
+			var result ApiServices
+			So(json.Unmarshal(recorder.Body.Bytes(), &result), ShouldBeNil)
+			So(len(result.Services), ShouldEqual, 1)
+			So(result.Services["bocaccio"], ShouldNotBeEmpty)
+		})
+
+		Convey("state only returns the matching services", func() {
+			req := httptest.NewRequest("GET", "/state.json?name=shakespeare", nil)
+			api.stateHandler(recorder, req, params)
+
+			So(recorder.Code, ShouldEqual, 200)
+
+			decoded, err := catalog.Decode(recorder.Body.Bytes())
+			So(err, ShouldBeNil)
+			So(len(decoded.Servers[hostname].Services), ShouldEqual, 1)
+			So(decoded.Servers[hostname].Services["deadbeef456"], ShouldNotBeNil)
+		})
+
+		Convey("bad filters are rejected", func() {
+			req := httptest.NewRequest("GET", "/services.json?status=sleepy", nil)
+			api.servicesHandler(recorder, req, params)
+
+			So(recorder.Code, ShouldEqual, 400)
+			So(recorder.Body.String(), ShouldContainSubstring, "Invalid filter")
+		})
+
+		Convey("watches only send the matching services", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			req := httptest.NewRequest("GET", "/watch?format=ndjson&name=bocaccio", nil)
+			api.watchHandler(recorder, req.WithContext(ctx), nil)
+
+			var event ApiWatchEvent
+			So(json.Unmarshal([]byte(strings.TrimSpace(recorder.Body.String())), &event), ShouldBeNil)
+			So(event.Type, ShouldEqual, WATCH_EVENT_SNAPSHOT)
+			So(len(event.Services), ShouldEqual, 1)
+			So(event.Services["bocaccio"], ShouldNotBeEmpty)
+		})
+
+		Convey("watches only replay the matching changes", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			since := state.Revision()
+			other.Status = service.UNHEALTHY
+			other.Updated = other.Updated.Add(time.Second)
+			state.AddServiceEntry(other)
+			svc.Status = service.UNHEALTHY
+			svc.Updated = svc.Updated.Add(time.Second)
+			state.AddServiceEntry(svc)
+
+			req := httptest.NewRequest("GET", fmt.Sprintf("/watch?format=ndjson&name=bocaccio&since=%d", since), nil)
+			api.watchHandler(recorder, req.WithContext(ctx), nil)
+
+			lines := strings.Split(strings.TrimSpace(recorder.Body.String()), "\n")
+			So(len(lines), ShouldEqual, 1)
+
+			var event ApiWatchEvent
+			So(json.Unmarshal([]byte(lines[0]), &event), ShouldBeNil)
+			So(event.Type, ShouldEqual, WATCH_EVENT_CHANGE)
+			So(event.Service.Name, ShouldEqual, "bocaccio")
+			So(event.Revision, ShouldEqual, since+2)
+		})
+
+		Convey("watches replay services leaving the statuses they want", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			since := state.Revision()
+			svc.Status = service.TOMBSTONE
+			svc.Updated = svc.Updated.Add(time.Second)
+			state.AddServiceEntry(svc)
+
+			req := httptest.NewRequest("GET", fmt.Sprintf("/watch?format=ndjson&status=alive&since=%d", since), nil)
+			api.watchHandler(recorder, req.WithContext(ctx), nil)
+
+			var event ApiWatchEvent
+			So(json.Unmarshal([]byte(strings.TrimSpace(recorder.Body.String())), &event), ShouldBeNil)
+			So(event.Type, ShouldEqual, WATCH_EVENT_CHANGE)
+			So(event.Service.Status, ShouldEqual, service.TOMBSTONE)
+			So(event.PreviousStatus, ShouldEqual, "Alive")
+		})
+
+		Convey("watch listeners hear about services leaving the statuses they want", func() {
+			listener := NewHttpListener()
+			listener.filter = &ServiceFilter{Statuses: []int{service.ALIVE}}
+			state.AddListener(listener)
+
+			svc.Status = service.DRAINING
+			svc.Updated = svc.Updated.Add(time.Second)
+			state.AddServiceEntry(svc)
+
+			So(len(listener.Chan()), ShouldEqual, 1)
+		})
+
+		Convey("watch listeners remember the changes they had no room for", func() {
+			listener := NewHttpListener()
+			listener.eventChan = make(chan catalog.ChangeEvent, 1)
+			listener.filter = &ServiceFilter{Names: []string{"bocaccio"}}
+			state.AddListener(listener)
+
+			other.Status = service.UNHEALTHY
+			other.Updated = other.Updated.Add(time.Second)
+			state.AddServiceEntry(other)
+			So(listener.Missed(), ShouldBeFalse)
+
+			for _, status := range []int{service.UNHEALTHY, service.ALIVE} {
+				svc.Status = status
+				svc.Updated = svc.Updated.Add(time.Second)
+				state.AddServiceEntry(svc)
+			}
+
+			So(len(listener.Chan()), ShouldEqual, 1)
+			So(listener.Missed(), ShouldBeTrue)
+			So(listener.Missed(), ShouldBeFalse)
+		})
+
+		Convey("watch listeners only wake up for matching changes", func() {
+			listener := NewHttpListener()
+			listener.filter = &ServiceFilter{Names: []string{"bocaccio"}}
+			state.AddListener(listener)
+
+			other.Status = service.UNHEALTHY
+			other.Updated = other.Updated.Add(time.Second)
+			state.AddServiceEntry(other)
+
+			So(len(listener.Chan()), ShouldEqual, 0)
+
+			svc.Status = service.UNHEALTHY
+			svc.Updated = svc.Updated.Add(time.Second)
+			state.AddServiceEntry(svc)
+
+			So(len(listener.Chan()), ShouldEqual, 1)
+		})
+	})
+}
@@ -0,0 +1,222 @@
+package sidecarhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Nitro/sidecar/service"
+	log "github.com/sirupsen/logrus"
+)
+
+// The framed watch stream sends one event per change, each carrying only
+// the service that changed, instead of the whole state each time. Every
+// event has the revision of the state after it, so a client that gets cut
+// off can come back with ?since=<revision> and we replay what it missed
+// from the journal. When the journal doesn't go back that far, or the
+// client is new, it gets a snapshot of the whole state first.
+
+const (
+	WATCH_FORMAT_NDJSON = "ndjson" // One JSON event per line
+	WATCH_FORMAT_SSE    = "sse"    // Server-Sent Events
+
+	WATCH_EVENT_SNAPSHOT = "snapshot"
+	WATCH_EVENT_CHANGE   = "change"
+)
+
+// ApiWatchEvent is one event in a framed watch stream
+type ApiWatchEvent struct {
+	Type           string
+	Revision       uint64
+	Time           time.Time                     // When the change happened, or the snapshot was taken
+	Service        *service.Service              `json:",omitempty"` // The service that changed
+	PreviousStatus string                        `json:",omitempty"`
+	Services       map[string][]*service.Service `json:",omitempty"` // The whole state, in snapshots
+}
+
+// watchStream streams the changes to the services that pass the filter as
+// framed events, in the format the client asked for
+func (s *SidecarApi) watchStream(response http.ResponseWriter, req *http.Request, format string, filter *ServiceFilter) {
+	since, haveSince, err := watchSince(req, format)
+	if err != nil {
+		sendJsonError(response, 400, fmt.Sprintf("Bad request - Invalid since: %s", err))
+		return
+	}
+
+	if format == WATCH_FORMAT_SSE {
+		response.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		response.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	response.Header().Set("Cache-Control", "no-cache")
+
+	// Subscribe before we look at the state so we don't miss anything
+	// in between. We skip the events we've already sent.
+	listener := NewHttpListener()
+	listener.filter = filter
+	s.state.AddListener(listener)
+	defer func() {
+		err := s.state.RemoveListener(listener.Name())
+		if err != nil {
+			log.Warnf("Failed to remove HTTP listener: %s", err)
+		}
+	}()
+
+	write := func(frames []byte) error {
+		_, err := response.Write(frames)
+
+		// In order to flush immediately, we have to cast to a Flusher
+		if f, ok := response.(http.Flusher); ok {
+			f.Flush()
+		}
+
+		return err
+	}
+
+	// catchUp sends what happened since the revision, or a snapshot when
+	// we can't replay that, and returns the revision it got up to
+	catchUp := func(since uint64, haveSince bool) (uint64, error) {
+		// The services in a snapshot belong to the state, so we have to
+		// encode them before we let go of the lock
+		s.state.RLock()
+		var frames bytes.Buffer
+		for _, event := range s.catchUpEvents(since, haveSince, filter) {
+			frame, err := encodeWatchEvent(event, format)
+			if err != nil {
+				s.state.RUnlock()
+				return since, err
+			}
+			frames.Write(frame)
+		}
+		revision := s.state.Revision()
+		s.state.RUnlock()
+
+		return revision, write(frames.Bytes())
+	}
+
+	lastRevision, err := catchUp(since, haveSince)
+	if err != nil {
+		log.Errorf("Unable to write watch events: %s", err)
+		return
+	}
+
+	for {
+		select {
+		// Find out when the http connection was closed so we can stop
+		case <-req.Context().Done():
+			return
+
+		case changed := <-listener.Chan():
+			if changed.Revision <= lastRevision {
+				continue
+			}
+
+			// The listener channel was full and we missed some changes.
+			// The journal can tell us which. Revisions the filter skipped
+			// are nothing to catch up on.
+			if listener.Missed() {
+				lastRevision, err = catchUp(lastRevision, true)
+			} else {
+				var frame []byte
+				frame, err = encodeWatchEvent(
+					changeEvent(&changed.Service, changed.PreviousStatus, changed.Time, changed.Revision),
+					format,
+				)
+				if err == nil {
+					err = write(frame)
+				}
+				lastRevision = changed.Revision
+			}
+
+			if err != nil {
+				log.Errorf("Unable to write watch events: %s", err)
+				return
+			}
+		}
+	}
+}
+
+// catchUpEvents returns the changes to the services that pass the filter
+// since the revision. Without a revision, or when we can't replay the
+// changes, it returns a snapshot.
+// Note: Not synchronized!
+func (s *SidecarApi) catchUpEvents(since uint64, haveSince bool, filter *ServiceFilter) []*ApiWatchEvent {
+	if haveSince {
+		if changes, ok := s.state.ChangesSince(since); ok {
+			var events []*ApiWatchEvent
+			for i := range changes {
+				change := &changes[i]
+				previousStatus, err := service.StatusFromString(change.PreviousStatus)
+				if err != nil {
+					previousStatus = change.Service.Status
+				}
+				if !filter.MatchesChange(&change.Service, previousStatus) {
+					continue
+				}
+
+				events = append(events, &ApiWatchEvent{
+					Type:           WATCH_EVENT_CHANGE,
+					Revision:       change.Revision,
+					Time:           change.Time,
+					Service:        &change.Service,
+					PreviousStatus: change.PreviousStatus,
+				})
+			}
+			return events
+		}
+	}
+
+	return []*ApiWatchEvent{{
+		Type:     WATCH_EVENT_SNAPSHOT,
+		Revision: s.state.Revision(),
+		Time:     time.Now().UTC(),
+		Services: s.byService(filter),
+	}}
+}
+
+func changeEvent(svc *service.Service, previousStatus int, changed time.Time, revision uint64) *ApiWatchEvent {
+	return &ApiWatchEvent{
+		Type:           WATCH_EVENT_CHANGE,
+		Revision:       revision,
+		Time:           changed,
+		Service:        svc,
+		PreviousStatus: service.StatusString(previousStatus),
+	}
+}
+
+// encodeWatchEvent frames the event in the format
+func encodeWatchEvent(event *ApiWatchEvent, format string) ([]byte, error) {
+	jsonBytes, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	if format == WATCH_FORMAT_SSE {
+		return []byte(fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", event.Revision, event.Type, jsonBytes)), nil
+	}
+
+	return append(jsonBytes, '\n'), nil
+}
+
+// watchSince returns the revision the client wants to resume from. SSE
+// clients send it in the Last-Event-ID header when they reconnect.
+func watchSince(req *http.Request, format string) (uint64, bool, error) {
+	since := req.URL.Query().Get("since")
+	if len(since) == 0 && format == WATCH_FORMAT_SSE {
+		since = req.Header.Get("Last-Event-ID")
+	}
+
+	if len(since) == 0 {
+		return 0, false, nil
+	}
+
+	revision, err := strconv.ParseUint(since, 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return revision, true, nil
+}
@@ -0,0 +1,132 @@
+package sidecarhttp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Nitro/sidecar/catalog"
+	"github.com/Nitro/sidecar/service"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func Test_watchStream(t *testing.T) {
+	Convey("When streaming framed watch events", t, func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		// We only test what gets sent before we start waiting for changes
+		cancel()
+
+		hostname := "chaucer"
+		state := catalog.NewServicesState()
+		state.Hostname = hostname
+		state.Servers[hostname] = catalog.NewServer(hostname)
+
+		start := state.Revision()
+
+		currentTime := time.Now().UTC()
+		svc := service.Service{
+			ID:       "deadbeef123",
+			Name:     "bocaccio",
+			Image:    "101deadbeef",
+			Created:  currentTime,
+			Hostname: hostname,
+			Updated:  currentTime,
+			Status:   service.ALIVE,
+		}
+		state.AddServiceEntry(svc)
+
+		api := &SidecarApi{state: state}
+		recorder := httptest.NewRecorder()
+
+		watch := func(query string) {
+			req := httptest.NewRequest("GET", "/watch?"+query, nil)
+			api.watchHandler(recorder, req.WithContext(ctx), nil)
+		}
+
+		decodeLines := func() []ApiWatchEvent {
+			var events []ApiWatchEvent
+			for _, line := range strings.Split(strings.TrimSpace(recorder.Body.String()), "\n") {
+				var event ApiWatchEvent
+				So(json.Unmarshal([]byte(line), &event), ShouldBeNil)
+				events = append(events, event)
+			}
+			return events
+		}
+
+		Convey("sends a snapshot first to new clients", func() {
+			watch("format=ndjson")
+
+			So(recorder.Code, ShouldEqual, 200)
+			So(recorder.Header().Get("Content-Type"), ShouldEqual, "application/x-ndjson")
+
+			events := decodeLines()
+			So(len(events), ShouldEqual, 1)
+			So(events[0].Type, ShouldEqual, WATCH_EVENT_SNAPSHOT)
+			So(events[0].Revision, ShouldEqual, start+1)
+			So(events[0].Services["bocaccio"], ShouldNotBeEmpty)
+		})
+
+		Convey("replays the changes since a revision", func() {
+			svc.Status = service.UNHEALTHY
+			svc.Updated = svc.Updated.Add(time.Second)
+			state.AddServiceEntry(svc)
+
+			watch(fmt.Sprintf("format=ndjson&since=%d", start))
+
+			events := decodeLines()
+			So(len(events), ShouldEqual, 2)
+			So(events[0].Type, ShouldEqual, WATCH_EVENT_CHANGE)
+			So(events[0].Revision, ShouldEqual, start+1)
+			So(events[0].PreviousStatus, ShouldEqual, "Unknown")
+			So(events[1].Revision, ShouldEqual, start+2)
+			So(events[1].PreviousStatus, ShouldEqual, "Alive")
+			So(events[1].Service.Status, ShouldEqual, service.UNHEALTHY)
+			So(events[1].Services, ShouldBeEmpty)
+		})
+
+		Convey("sends a snapshot when it can't replay the changes", func() {
+			watch(fmt.Sprintf("format=ndjson&since=%d", start+10))
+
+			events := decodeLines()
+			So(len(events), ShouldEqual, 1)
+			So(events[0].Type, ShouldEqual, WATCH_EVENT_SNAPSHOT)
+		})
+
+		Convey("frames Server-Sent Events", func() {
+			watch(fmt.Sprintf("format=sse&since=%d", start))
+
+			So(recorder.Header().Get("Content-Type"), ShouldEqual, "text/event-stream")
+			So(recorder.Body.String(), ShouldStartWith,
+				fmt.Sprintf("id: %d\nevent: change\ndata: {", start+1),
+			)
+			So(recorder.Body.String(), ShouldEndWith, "}\n\n")
+		})
+
+		Convey("resumes Server-Sent Events from the Last-Event-ID", func() {
+			req := httptest.NewRequest("GET", "/watch?format=sse", nil)
+			req.Header.Set("Last-Event-ID", fmt.Sprintf("%d", start+1))
+			api.watchHandler(recorder, req.WithContext(ctx), nil)
+
+			So(recorder.Code, ShouldEqual, 200)
+			So(recorder.Body.String(), ShouldBeEmpty)
+		})
+
+		Convey("rejects a bad revision", func() {
+			watch("format=ndjson&since=yesterday")
+
+			So(recorder.Code, ShouldEqual, 400)
+			So(recorder.Body.String(), ShouldContainSubstring, "Invalid since")
+		})
+
+		Convey("rejects an unknown format", func() {
+			watch("format=xml")
+
+			So(recorder.Code, ShouldEqual, 400)
+			So(recorder.Body.String(), ShouldContainSubstring, "Unknown format")
+		})
+	})
+}
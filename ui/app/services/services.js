@@ -18,6 +18,14 @@ angular.module('sidecar.services', ['ngRoute', 'ui.bootstrap'])
       });
     };
 
+	function svcGetChecks() {
+      return $http({
+        method: 'GET',
+        url: '/api/checks.json',
+		dataType: 'json',
+      });
+    };
+
 	var haproxyUrl = window.location.protocol +
 		'//' + window.location.hostname +
 		':3212/;csv;norefresh';
@@ -39,11 +47,16 @@ angular.module('sidecar.services', ['ngRoute', 'ui.bootstrap'])
 		waitFirstHaproxy: haproxyWaiter.promise,
 		services: {},
 		haproxy: {},
+		checks: [],
 
 		getServices: function() {
 			return state.services;
 		},
 
+		getChecks: function() {
+			return state.checks;
+		},
+
 		getHaproxy: function() {
 			return state.haproxy;
 		}
@@ -56,6 +69,10 @@ angular.module('sidecar.services', ['ngRoute', 'ui.bootstrap'])
 			serviceWaiter.resolve();
 		});
 
+		svcGetChecks().then(function(checks) {
+			state.checks = checks.data;
+		});
+
 		svcGetHaproxy().then(function(haproxy) {
 			state.haproxy = haproxy.data;
 			haproxyWaiter.resolve();
@@ -77,6 +94,7 @@ angular.module('sidecar.services', ['ngRoute', 'ui.bootstrap'])
 	$scope.collapsed = {};
 	$scope.expandedServiceInfo = {};
 	$scope.haproxyInfo = {};
+	$scope.checksList = [];
 
 	$scope.toggleCollapse = function(svcName) {
 		$scope.collapsed[svcName] = !$scope.isCollapsed(svcName);
@@ -121,6 +139,9 @@ angular.module('sidecar.services', ['ngRoute', 'ui.bootstrap'])
 		$scope.clusterName = servicesResponse.ClusterName;
 		$scope.serverList = servicesResponse.ClusterMembers;
 
+		// Local health checks
+		$scope.checksList = stateService.getChecks();
+
 		// Haproxy
 		var haproxyResponse = stateService.getHaproxy();
 		var raw = {};
@@ -207,6 +228,16 @@ angular.module('sidecar.services', ['ngRoute', 'ui.bootstrap'])
 	}
 })
 
+.filter('latencyStr', function() {
+	return function(nanos) {
+		if (nanos == null) {
+			return "";
+		}
+
+		return (nanos / 1000000).toFixed(1) + "ms";
+	}
+})
+
 .filter('timeAgo', function() {
 	return function(textDate) {
 		if (textDate == null || textDate == "") {